@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -11,9 +13,11 @@ import (
 	"path/filepath"
 	"plugin"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
+	"gopsi/pkg/conn"
 	"gopsi/pkg/inventory"
 	"gopsi/pkg/modhelp"
 	"gopsi/pkg/module"
@@ -27,6 +31,7 @@ import (
 	_ "gopsi/pkg/modules/package"
 	_ "gopsi/pkg/modules/pip"
 	_ "gopsi/pkg/modules/service"
+	_ "gopsi/pkg/modules/setup"
 	_ "gopsi/pkg/modules/shell"
 	_ "gopsi/pkg/modules/template"
 	_ "gopsi/pkg/modules/unarchive"
@@ -34,6 +39,16 @@ import (
 	"gopsi/pkg/runner"
 	"gopsi/pkg/vault"
 	"gopsi/pkg/version"
+
+	"golang.org/x/term"
+	"gopkg.in/yaml.v3"
+)
+
+// Exit codes of `gopsi run` besides 1 (error) and 2 (bad usage): some
+// hosts failed, or none failed but some were unreachable.
+const (
+	exitHostsFailed      = 3
+	exitHostsUnreachable = 4
 )
 
 var defaultModules []string
@@ -72,6 +87,8 @@ func main() {
 			usagePing()
 		case "modules":
 			usageModules()
+		case "known-hosts":
+			usageKnownHosts()
 		default:
 			printUsage()
 		}
@@ -158,36 +175,89 @@ func main() {
 		invFile := flag.NewFlagSet("inventory", flag.ExitOnError)
 		invFile.Usage = usageInventory
 		list := invFile.Bool("list", false, "list hosts")
-		file := invFile.String("i", "inventory.yml", "inventory file")
+		graph := invFile.Bool("graph", false, "print the group tree")
+		hostName := invFile.String("host", "", "print the merged vars of a host and their sources")
+		export := invFile.Bool("export", false, "print the inventory as dynamic inventory JSON")
+		asYAML := invFile.Bool("yaml", false, "print --host as YAML")
+		var file inventoryFlag
+		invFile.Var(&file, "i", "inventory file or directory (repeatable)")
+		cacheTTL := invFile.Duration("inventory-cache-ttl", 0, "reuse inventory script output for this long")
+		limit := invFile.String("limit", "", "host pattern")
 		_ = invFile.Parse(os.Args[2:])
-		inv, err := inventory.LoadFromFile(*file)
+		inv, err := loadInventory(file, *cacheTTL, os.Getenv("AT_VAULT_PASSWORD"))
 		if err != nil {
 			fmt.Fprintln(os.Stderr, err)
 			os.Exit(1)
 		}
 		if *list {
-			for _, h := range inv.AllHosts("") {
+			hosts, err := inv.Hosts(*limit)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(2)
+			}
+			for _, h := range hosts {
 				fmt.Println(h.Name)
 			}
 		}
+		if *graph {
+			fmt.Print(inv.Graph())
+		}
+		if *hostName != "" {
+			vars, sources, err := inv.HostVars(*hostName)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(2)
+			}
+			view := map[string]any{"vars": vars, "sources": sources}
+			var out []byte
+			if *asYAML {
+				out, err = yaml.Marshal(view)
+			} else {
+				out, err = json.MarshalIndent(view, "", "  ")
+			}
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			fmt.Println(strings.TrimRight(string(out), "\n"))
+		}
+		if *export {
+			out, err := inv.Export()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			fmt.Println(string(out))
+		}
 	case "run":
 		runFlags := flag.NewFlagSet("run", flag.ExitOnError)
 		runFlags.Usage = usageRun
-		invPath := runFlags.String("i", "inventory.yml", "inventory file")
-		limit := runFlags.String("limit", "", "limit hosts/group")
+		var invPath inventoryFlag
+		runFlags.Var(&invPath, "i", "inventory file or directory (repeatable)")
+		cacheTTL := runFlags.Duration("inventory-cache-ttl", 0, "reuse inventory script output for this long")
+		limit := runFlags.String("limit", "", "host pattern (group, glob, ~regex, a:b, a:&b, a:!b, @file)")
 		forks := runFlags.Int("forks", 5, "parallel forks")
 		check := runFlags.Bool("check", false, "check mode")
 		jsonOut := runFlags.Bool("json", false, "json output")
 		v := runFlags.Bool("v", false, "increase verbosity")
 		vv := runFlags.Bool("vv", false, "increase verbosity more")
 		vvv := runFlags.Bool("vvv", false, "maximum verbosity")
+		forceHandlers := runFlags.Bool("force-handlers", false, "run notified handlers even if a task fails")
+		askBecomePass := runFlags.Bool("ask-become-pass", false, "prompt for the become password")
+		factCacheTTL := runFlags.Duration("fact-cache-ttl", 0, "reuse facts cached under GOPSI_HOME for this long")
+		tags := runFlags.String("tags", "", "only run tasks with these comma-separated tags")
+		skipTags := runFlags.String("skip-tags", "", "skip tasks with these comma-separated tags")
+		listTags := runFlags.Bool("list-tags", false, "list tags selected in the playbook and exit")
+		listTasks := runFlags.Bool("list-tasks", false, "list tasks that would run and exit")
+		hostKeyChecking := runFlags.String("host-key-checking", "accept-new", "strict|accept-new|off")
+		vaultPassFile := runFlags.String("vault-password-file", "", "file holding the vault password (default AT_VAULT_PASSWORD env)")
 		argv := os.Args[2:]
 		var fl, ar []string
 		for i := 0; i < len(argv); i++ {
 			tok := argv[i]
 			if strings.HasPrefix(tok, "-") {
 				fl = append(fl, tok)
-				if !strings.Contains(tok, "=") && i+1 < len(argv) && !strings.HasPrefix(argv[i+1], "-") {
+				if !strings.Contains(tok, "=") && !isBoolFlag(runFlags, tok) && i+1 < len(argv) && !strings.HasPrefix(argv[i+1], "-") {
 					fl = append(fl, argv[i+1])
 					i++
 				}
@@ -203,12 +273,22 @@ func main() {
 			os.Exit(2)
 		}
 		playPath := args[0]
-		inv, err := inventory.LoadFromFile(*invPath)
+		pb, err := play.LoadPlaybook(playPath)
 		if err != nil {
 			fmt.Fprintln(os.Stderr, err)
 			os.Exit(1)
 		}
-		pb, err := play.LoadPlaybook(playPath)
+		only, skip := splitList(*tags), splitList(*skipTags)
+		if *listTags || *listTasks {
+			listPlaybook(pb, only, skip, *listTasks)
+			os.Exit(0)
+		}
+		vaultPass, err := readVaultPassword(*vaultPassFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		inv, err := loadInventory(invPath, *cacheTTL, vaultPass, filepath.Dir(playPath))
 		if err != nil {
 			fmt.Fprintln(os.Stderr, err)
 			os.Exit(1)
@@ -223,27 +303,59 @@ func main() {
 		if *vvv && verbosity < 3 {
 			verbosity = 3
 		}
+		hkMode, err := conn.ParseHostKeyMode(*hostKeyChecking)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
 		r := runner.NewWithOptions(*forks, *check, *jsonOut, verbosity)
-		hosts := inv.AllHosts(*limit)
+		r.SetHostKeyMode(hkMode)
+		r.SetVaultPassword(vaultPass)
+		if term.IsTerminal(int(os.Stdin.Fd())) {
+			r.SetPrompt(promptSecret)
+		}
+		r.SetForceHandlers(*forceHandlers)
+		r.SetAskBecomePass(*askBecomePass)
+		r.SetFactCache(filepath.Join(gopsiHome(), "cache", "facts"), *factCacheTTL)
+		r.SetTags(only, skip)
+		hosts, err := inv.Hosts(*limit)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		r.SetInventory(inv)
 		ctx := context.Background()
 		if err := r.Run(ctx, hosts, pb); err != nil {
 			fmt.Fprintln(os.Stderr, err)
+			var hf *runner.HostFailures
+			switch {
+			case errors.As(err, &hf) && (len(hf.Failed) > 0 || hf.Aborted != ""):
+				os.Exit(exitHostsFailed)
+			case errors.As(err, &hf):
+				os.Exit(exitHostsUnreachable)
+			}
 			os.Exit(1)
 		}
 	case "ping":
 		pf := flag.NewFlagSet("ping", flag.ExitOnError)
 		pf.Usage = usagePing
-		invPath := pf.String("i", "inventory.yml", "inventory file")
-		limit := pf.String("limit", "", "limit hosts/group")
-		port := pf.Int("port", 22, "TCP port to check")
+		var invPath inventoryFlag
+		pf.Var(&invPath, "i", "inventory file or directory (repeatable)")
+		cacheTTL := pf.Duration("inventory-cache-ttl", 0, "reuse inventory script output for this long")
+		limit := pf.String("limit", "", "host pattern (group, glob, ~regex, a:b, a:&b, a:!b, @file)")
+		port := pf.Int("port", 0, "TCP port to check (default: inventory port, ssh config, or 22)")
 		timeoutSec := pf.Int("timeout", 5, "ssh timeout seconds")
 		_ = pf.Parse(os.Args[2:])
-		inv, err := inventory.LoadFromFile(*invPath)
+		inv, err := loadInventory(invPath, *cacheTTL, os.Getenv("AT_VAULT_PASSWORD"))
 		if err != nil {
 			fmt.Fprintln(os.Stderr, err)
 			os.Exit(1)
 		}
-		hosts := inv.AllHosts(*limit)
+		hosts, err := inv.Hosts(*limit)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
 		if len(hosts) == 0 {
 			fmt.Fprintln(os.Stderr, "no hosts to ping")
 			os.Exit(2)
@@ -251,11 +363,16 @@ func main() {
 		timeout := time.Duration(*timeoutSec) * time.Second
 		unreachable := 0
 		for _, h := range hosts {
-			addr := h.Addr
-			if addr == "" {
-				addr = h.Name
+			if kind := stringVar(h.Vars, "connection"); kind != "" && kind != "ssh" {
+				if perr := pingTransport(h, kind, timeout); perr != nil {
+					fmt.Printf("%s | unreachable | %v\n", h.Name, perr)
+					unreachable++
+					continue
+				}
+				fmt.Printf("%s | reachable | %s\n", h.Name, kind)
+				continue
 			}
-			target := net.JoinHostPort(addr, fmt.Sprintf("%d", *port))
+			target := sshTarget(h, *port)
 			c, derr := net.DialTimeout("tcp", target, timeout)
 			if derr != nil {
 				fmt.Printf("%s | unreachable | %v\n", h.Name, derr)
@@ -268,10 +385,79 @@ func main() {
 		if unreachable > 0 {
 			os.Exit(1)
 		}
+	case "known-hosts":
+		if len(os.Args) < 3 || os.Args[2] != "scan" {
+			usageKnownHosts()
+			os.Exit(2)
+		}
+		kf := flag.NewFlagSet("known-hosts", flag.ExitOnError)
+		kf.Usage = usageKnownHosts
+		var invPath inventoryFlag
+		kf.Var(&invPath, "i", "inventory file or directory (repeatable)")
+		cacheTTL := kf.Duration("inventory-cache-ttl", 0, "reuse inventory script output for this long")
+		limit := kf.String("limit", "", "host pattern (group, glob, ~regex, a:b, a:&b, a:!b, @file)")
+		file := kf.String("file", "", "known_hosts file (default ~/.ssh/known_hosts or ssh_known_hosts_file)")
+		port := kf.Int("port", 0, "SSH port (default: inventory port, ssh config, or 22)")
+		timeoutSec := kf.Int("timeout", 5, "ssh timeout seconds")
+		_ = kf.Parse(os.Args[3:])
+		inv, err := loadInventory(invPath, *cacheTTL, os.Getenv("AT_VAULT_PASSWORD"))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		hosts, err := inv.Hosts(*limit)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		if len(hosts) == 0 {
+			fmt.Fprintln(os.Stderr, "no hosts to scan")
+			os.Exit(2)
+		}
+		timeout := time.Duration(*timeoutSec) * time.Second
+		failed := 0
+		for _, h := range hosts {
+			if kind := stringVar(h.Vars, "connection"); kind != "" && kind != "ssh" {
+				fmt.Printf("%s | skipped | connection=%s\n", h.Name, kind)
+				continue
+			}
+			kh := *file
+			if kh == "" {
+				kh = expandHome(stringVar(h.Vars, "ssh_known_hosts_file"))
+			}
+			if kh == "" {
+				kh = conn.DefaultKnownHostsFile()
+			}
+			target := sshTarget(h, *port)
+			key, serr := conn.ScanHostKey(target, timeout)
+			if serr != nil {
+				fmt.Printf("%s | unreachable | %v\n", h.Name, serr)
+				failed++
+				continue
+			}
+			known, cerr := conn.CheckKnownHost(kh, target, key)
+			switch {
+			case cerr != nil:
+				fmt.Println(colorRed(fmt.Sprintf("%s | MISMATCH | %s %v", h.Name, key.Type(), cerr)))
+				failed++
+			case known:
+				fmt.Printf("%s | known | %s\n", h.Name, key.Type())
+			default:
+				if aerr := conn.AddKnownHost(kh, target, key); aerr != nil {
+					fmt.Printf("%s | error | %v\n", h.Name, aerr)
+					failed++
+					continue
+				}
+				fmt.Println(colorLightGreen(fmt.Sprintf("%s | added | %s %s", h.Name, key.Type(), kh)))
+			}
+		}
+		if failed > 0 {
+			os.Exit(1)
+		}
 	case "vault":
 		vf := flag.NewFlagSet("vault", flag.ExitOnError)
 		vf.Usage = usageVault
-		mode := vf.String("mode", "encrypt", "encrypt|decrypt")
+		mode := vf.String("mode", "encrypt", "encrypt|decrypt|encrypt-string")
 		in := vf.String("in", "-", "input file or - for stdin")
 		out := vf.String("out", "-", "output file or - for stdout")
 		pass := vf.String("pass", "", "passphrase (use AT_VAULT_PASSWORD env if empty)")
@@ -312,6 +498,13 @@ func main() {
 				os.Exit(1)
 			}
 			outb = b
+		case "encrypt-string":
+			str, err := vault.EncryptString(strings.TrimRight(string(data), "\r\n"), []byte(p))
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			outb = []byte(str + "\n")
 		default:
 			fmt.Fprintln(os.Stderr, "unknown mode")
 			os.Exit(2)
@@ -375,6 +568,7 @@ func main() {
 	}
 }
 
+func colorRed(s string) string         { return "\033[31m" + s + "\033[0m" }
 func colorBold(s string) string        { return "\033[1m" + s + "\033[0m" }
 func colorViolet(s string) string      { return "\033[95;1m" + s + "\033[0m" }
 func colorLightYellow(s string) string { return "\033[93m" + s + "\033[0m" }
@@ -463,17 +657,19 @@ func printUsage() {
 	fmt.Println("  " + colorLightYellow("vault") + "       " + colorLightBlue("Encrypt/decrypt variable files"))
 	fmt.Println("  " + colorLightYellow("version") + "     " + colorLightBlue("Show build version info"))
 	fmt.Println("  " + colorLightYellow("ping") + "        " + colorLightBlue("Check TCP reachability for inventory hosts"))
+	fmt.Println("  " + colorLightYellow("known-hosts") + " " + colorLightBlue("Record inventory host keys in known_hosts"))
 	fmt.Println("  " + colorLightYellow("modules") + "     " + colorLightBlue("List registered modules"))
 	fmt.Println("  " + colorLightYellow("completion") + "  " + colorLightBlue("Output shell completion script (bash|zsh)"))
 	fmt.Println("  " + colorLightYellow("help") + "        " + colorLightBlue("Show detailed help for a command"))
 	fmt.Println(colorViolet("Flags by command:"))
-	fmt.Println("  " + colorLightYellow("run") + ": " + colorLightBlue("-i, --limit, --forks, --check, --json, -v, -vv, -vvv"))
-	fmt.Println("  " + colorLightYellow("inventory") + ": " + colorLightBlue("--list, -i"))
+	fmt.Println("  " + colorLightYellow("run") + ": " + colorLightBlue("-i, --inventory-cache-ttl, --limit, --forks, --check, --json, --vault-password-file, --host-key-checking, --force-handlers, --ask-become-pass, --fact-cache-ttl, --tags, --skip-tags, --list-tags, --list-tasks, -v, -vv, -vvv"))
+	fmt.Println("  " + colorLightYellow("inventory") + ": " + colorLightBlue("--list, --graph, --host, --yaml, --export, -i, --inventory-cache-ttl, --limit"))
 	fmt.Println("  " + colorLightYellow("vault") + ": " + colorLightBlue("--mode, --in, --out, --pass"))
-	fmt.Println("  " + colorLightYellow("ping") + ": " + colorLightBlue("-i, --limit, --port, --timeout"))
+	fmt.Println("  " + colorLightYellow("ping") + ": " + colorLightBlue("-i, --inventory-cache-ttl, --limit, --port, --timeout"))
+	fmt.Println("  " + colorLightYellow("known-hosts scan") + ": " + colorLightBlue("-i, --inventory-cache-ttl, --limit, --file, --port, --timeout"))
 	fmt.Println("  " + colorLightYellow("modules") + ": " + colorLightBlue("(no flags)"))
 	fmt.Println("  " + colorLightYellow("completion") + ": " + colorLightBlue("bash|zsh"))
-	fmt.Println("  " + colorLightYellow("help") + ": " + colorLightBlue("help <run|inventory|vault|version|ping|modules>"))
+	fmt.Println("  " + colorLightYellow("help") + ": " + colorLightBlue("help <run|inventory|vault|version|ping|modules|known-hosts>"))
 	fmt.Println(colorViolet("Examples:"))
 	fmt.Println("  " + colorLightGreen("Dry-run; shows predicted changes without applying"))
 	fmt.Println("  " + colorLightYellow("gopsi run -i inventory.yml play.yml --check"))
@@ -492,30 +688,74 @@ func usageRun() {
 	fmt.Println(colorViolet("Description:"))
 	fmt.Println("  " + colorLightBlue("Executes YAML playbook tasks across selected hosts using SSH."))
 	fmt.Println(colorViolet("Flags:"))
-	fmt.Println("  " + colorLightYellow("-i string") + "  " + colorLightGreen("Inventory file path (default 'inventory.yml')"))
-	fmt.Println("  " + colorLightYellow("--limit string") + "  " + colorLightGreen("Limit execution to a host or group name"))
+	fmt.Println("  " + colorLightYellow("-i path") + "  " + colorLightGreen("Inventory file (YAML, INI or executable script) or directory; repeatable, merged in order (default 'inventory.yml')"))
+	fmt.Println("  " + colorLightYellow("--inventory-cache-ttl duration") + "  " + colorLightGreen("Reuse inventory script output cached under GOPSI_HOME for this long, e.g. 10m (default 0, always run)"))
+	fmt.Println("  " + colorLightYellow("--limit string") + "  " + colorLightGreen("Host pattern: group, host, glob (web*), ~regex, web:db, web:&prod, all:!canary, @retry-file"))
 	fmt.Println("  " + colorLightYellow("--forks int") + "  " + colorLightGreen("Number of parallel workers (default 5)"))
 	fmt.Println("  " + colorLightYellow("--check") + "  " + colorLightGreen("Dry-run; predict changes without applying"))
-	fmt.Println("  " + colorLightYellow("--json") + "  " + colorLightGreen("Print per-task results as JSON lines"))
+	fmt.Println("  " + colorLightYellow("--json") + "  " + colorLightGreen("Print per-task results as JSON lines, then the recap as one JSON line"))
+	fmt.Println("  " + colorLightYellow("--vault-password-file string") + "  " + colorLightGreen("Vault password for inline encrypted vars and vars files (default AT_VAULT_PASSWORD env)"))
+	fmt.Println("  " + colorLightYellow("--host-key-checking string") + "  " + colorLightGreen("strict|accept-new|off (default 'accept-new'); inventory var ssh_host_key_checking overrides"))
+	fmt.Println("  " + colorLightYellow("--force-handlers") + "  " + colorLightGreen("Run notified handlers even after a task fails"))
+	fmt.Println("  " + colorLightYellow("--ask-become-pass") + "  " + colorLightGreen("Prompt once for the become password of hosts without become_password"))
+	fmt.Println("  " + colorLightYellow("--fact-cache-ttl duration") + "  " + colorLightGreen("Reuse facts cached under GOPSI_HOME/cache/facts for this long, e.g. 1h (default 0, always gather)"))
+	fmt.Println("  " + colorLightYellow("--tags string") + "  " + colorLightGreen("Only run tasks tagged with any of these (comma-separated)"))
+	fmt.Println("  " + colorLightYellow("--skip-tags string") + "  " + colorLightGreen("Skip tasks tagged with any of these (comma-separated)"))
+	fmt.Println("  " + colorLightYellow("--list-tags") + "  " + colorLightGreen("List tags of the selected tasks and exit"))
+	fmt.Println("  " + colorLightYellow("--list-tasks") + "  " + colorLightGreen("List tasks that would run and exit; no hosts are contacted"))
 	fmt.Println("  " + colorLightYellow("-v") + ", " + colorLightYellow("-vv") + ", " + colorLightYellow("-vvv") + "  " + colorLightGreen("Increase diagnostics verbosity (1/2/3)"))
 	fmt.Println(colorViolet("Ordering:"))
 	fmt.Println("  " + colorLightBlue("Flags can appear anywhere; they are normalized before parsing."))
 	fmt.Println(colorViolet("Notes:"))
 	fmt.Println("  " + colorLightBlue("Concurrency per play can be controlled via 'serial' in the playbook."))
 	fmt.Println("  " + colorLightBlue("Facts are gathered automatically and available as 'facts' in templates/when."))
+	fmt.Println("  " + colorLightBlue("Handlers run once per host at the end of a play, or at a 'meta: flush_handlers' task."))
+	fmt.Println("  " + colorLightBlue("Play-level 'tags' are inherited by tasks; 'always' and 'never' are special tags."))
+	fmt.Println("  " + colorLightBlue("A failed or unreachable host is dropped from the rest of the run; other hosts carry on unless 'any_errors_fatal' or 'max_fail_percentage' aborts the play."))
+	fmt.Println("  " + colorLightBlue("The run ends with a PLAY RECAP (ok/changed/unreachable/failed/skipped/rescued/ignored per host) and the slowest tasks."))
+	fmt.Println(colorViolet("Exit codes:"))
+	fmt.Println("  " + colorLightYellow("0") + "  " + colorLightGreen("All hosts succeeded"))
+	fmt.Println("  " + colorLightYellow("1") + "  " + colorLightGreen("Error before or outside the hosts (playbook, inventory, host pattern)"))
+	fmt.Println("  " + colorLightYellow("2") + "  " + colorLightGreen("Bad usage"))
+	fmt.Println("  " + colorLightYellow("3") + "  " + colorLightGreen("Some hosts failed, or the run was aborted"))
+	fmt.Println("  " + colorLightYellow("4") + "  " + colorLightGreen("No host failed but some were unreachable"))
 }
 
 func usageInventory() {
-	fmt.Println(colorViolet("Usage:") + " " + colorLightYellow("gopsi inventory --list -i <inventory>"))
+	fmt.Println(colorViolet("Usage:") + " " + colorLightYellow("gopsi inventory (--list [--limit pattern] | --graph | --host <name> [--yaml] | --export) -i <inventory>"))
 	fmt.Println(colorViolet("Description:"))
-	fmt.Println("  " + colorLightBlue("Lists resolved hostnames from the inventory."))
+	fmt.Println("  " + colorLightBlue("Lists resolved hostnames, shows the group tree, explains a host's vars or exports the inventory."))
 	fmt.Println(colorViolet("Flags:"))
 	fmt.Println("  " + colorLightYellow("--list") + "  " + colorLightGreen("List all hosts in the inventory"))
-	fmt.Println("  " + colorLightYellow("-i string") + "  " + colorLightGreen("Path to inventory YAML file (default 'inventory.yml')"))
+	fmt.Println("  " + colorLightYellow("--graph") + "  " + colorLightGreen("Print the tree of groups and hosts"))
+	fmt.Println("  " + colorLightYellow("--host string") + "  " + colorLightGreen("Print a host's merged vars as JSON with the file and group or host each value came from"))
+	fmt.Println("  " + colorLightYellow("--yaml") + "  " + colorLightGreen("Print --host as YAML"))
+	fmt.Println("  " + colorLightYellow("--export") + "  " + colorLightGreen("Print the merged inventory as dynamic inventory JSON (groups, vars, children, _meta.hostvars)"))
+	fmt.Println("  " + colorLightYellow("-i path") + "  " + colorLightGreen("Inventory file (YAML, INI or executable script) or directory; repeatable, merged in order (default 'inventory.yml')"))
+	fmt.Println("  " + colorLightYellow("--inventory-cache-ttl duration") + "  " + colorLightGreen("Reuse inventory script output cached under GOPSI_HOME for this long, e.g. 10m (default 0, always run)"))
+	fmt.Println("  " + colorLightYellow("--limit string") + "  " + colorLightGreen("Only list hosts matching a host pattern"))
 	fmt.Println(colorViolet("Inventory keys:"))
 	fmt.Println("  " + colorLightYellow("host") + "  " + colorLightBlue("IP/DNS of the host"))
+	fmt.Println("  " + colorLightYellow("connection") + "  " + colorLightBlue("Transport: ssh (default), local, docker or podman"))
+	fmt.Println("  " + colorLightYellow("container") + "  " + colorLightBlue("Container name/id for docker/podman (default host, then inventory name)"))
 	fmt.Println("  " + colorLightYellow("user") + "  " + colorLightBlue("SSH username"))
+	fmt.Println("  " + colorLightYellow("port") + "  " + colorLightBlue("SSH port (default 22)"))
+	fmt.Println("  " + colorLightYellow("proxy_jump") + "  " + colorLightBlue("Jump host(s): \"user@bastion:2222,host2\" or a list of strings/maps (host, port, user, ssh_private_key_file)"))
+	fmt.Println("  " + colorLightYellow("ssh_config_file") + "  " + colorLightBlue("OpenSSH client config for HostName/Port/User/IdentityFile/ProxyJump (default ~/.ssh/config, 'none' to skip)"))
 	fmt.Println("  " + colorLightYellow("ssh_private_key_file") + "  " + colorLightBlue("Path to SSH private key"))
+	fmt.Println("  " + colorLightYellow("ssh_private_key_passphrase") + "  " + colorLightBlue("Passphrase for encrypted keys (vault string recommended; prompted otherwise)"))
+	fmt.Println("  " + colorLightYellow("ssh_password") + "  " + colorLightBlue("Password / keyboard-interactive auth (vault string recommended)"))
+	fmt.Println("  " + colorLightYellow("ssh_agent") + "  " + colorLightBlue("Use SSH_AUTH_SOCK agent keys (default true)"))
+	fmt.Println("  " + colorLightYellow("ssh_keepalive") + "  " + colorLightBlue("Keepalive interval in seconds (default 30, 0 disables)"))
+	fmt.Println("  " + colorLightYellow("ssh_max_sessions") + "  " + colorLightBlue("Concurrent sessions per connection (default 8)"))
+	fmt.Println("  " + colorLightYellow("ssh_known_hosts_file") + "  " + colorLightBlue("known_hosts file (default ~/.ssh/known_hosts)"))
+	fmt.Println("  " + colorLightYellow("become_method") + "  " + colorLightBlue("sudo (default), su or doas"))
+	fmt.Println("  " + colorLightYellow("become_user") + "  " + colorLightBlue("User to become (default root)"))
+	fmt.Println("  " + colorLightYellow("become_password") + "  " + colorLightBlue("Become password typed through a pty (vault string recommended)"))
+	fmt.Println("  " + colorLightYellow("ssh_host_key_checking") + "  " + colorLightBlue("strict|accept-new|off"))
+	fmt.Println(colorViolet("Vars files:"))
+	fmt.Println("  " + colorLightBlue("group_vars/<group>[.yml] and host_vars/<host>[.yml] (or directories of files) next to the inventory"))
+	fmt.Println("  " + colorLightBlue("and the playbook override inline vars; vault-encrypted files use AT_VAULT_PASSWORD"))
 }
 
 func usageVault() {
@@ -523,12 +763,15 @@ func usageVault() {
 	fmt.Println(colorViolet("Description:"))
 	fmt.Println("  " + colorLightBlue("Encrypts or decrypts YAML variable files using AES-GCM."))
 	fmt.Println(colorViolet("Flags:"))
-	fmt.Println("  " + colorLightYellow("--mode string") + "  " + colorLightGreen("Operation: encrypt or decrypt (default 'encrypt')"))
+	fmt.Println("  " + colorLightYellow("--mode string") + "  " + colorLightGreen("Operation: encrypt, decrypt or encrypt-string (default 'encrypt')"))
 	fmt.Println("  " + colorLightYellow("--in string") + "  " + colorLightGreen("Input file path or '-' for stdin (default '-')"))
 	fmt.Println("  " + colorLightYellow("--out string") + "  " + colorLightGreen("Output file path or '-' for stdout (default '-')"))
 	fmt.Println("  " + colorLightYellow("--pass string") + "  " + colorLightGreen("Passphrase; if omitted, uses AT_VAULT_PASSWORD env var"))
 	fmt.Println(colorViolet("Environment:"))
 	fmt.Println("  " + colorLightYellow("AT_VAULT_PASSWORD") + "  " + colorLightBlue("Optional passphrase source for non-interactive use"))
+	fmt.Println(colorViolet("Inline values:"))
+	fmt.Println("  " + colorLightBlue("'encrypt-string' prints a single $GOPSI_VAULT;... value to paste into inventory vars"))
+	fmt.Println("  " + colorLightBlue("such as ssh_password; 'gopsi run' decrypts it with --vault-password-file or AT_VAULT_PASSWORD."))
 }
 
 func usageVersion() {
@@ -541,10 +784,26 @@ func usagePing() {
 	fmt.Println(colorViolet("Usage:") + " " + colorLightYellow("gopsi ping [flags]"))
 	fmt.Println(colorViolet("Description:"))
 	fmt.Println("  " + colorLightBlue("Checks TCP port reachability for hosts defined in the inventory."))
+	fmt.Println("  " + colorLightBlue("Hosts with connection local/docker/podman are checked by running a no-op command instead."))
+	fmt.Println(colorViolet("Flags:"))
+	fmt.Println("  " + colorLightYellow("-i path") + "  " + colorLightGreen("Inventory file (YAML, INI or executable script) or directory; repeatable, merged in order (default 'inventory.yml')"))
+	fmt.Println("  " + colorLightYellow("--inventory-cache-ttl duration") + "  " + colorLightGreen("Reuse inventory script output cached under GOPSI_HOME for this long, e.g. 10m (default 0, always run)"))
+	fmt.Println("  " + colorLightYellow("--limit string") + "  " + colorLightGreen("Host pattern: group, host, glob (web*), ~regex, web:db, web:&prod, all:!canary, @retry-file"))
+	fmt.Println("  " + colorLightYellow("--port int") + "  " + colorLightGreen("TCP port to check (default: inventory port, ~/.ssh/config Port, or 22)"))
+	fmt.Println("  " + colorLightYellow("--timeout int") + "  " + colorLightGreen("Connection timeout in seconds (default 5)"))
+}
+
+func usageKnownHosts() {
+	fmt.Println(colorViolet("Usage:") + " " + colorLightYellow("gopsi known-hosts scan [flags]"))
+	fmt.Println(colorViolet("Description:"))
+	fmt.Println("  " + colorLightBlue("Fetches each inventory host's SSH key and appends unknown ones to known_hosts."))
+	fmt.Println("  " + colorLightBlue("Hosts whose key differs from the recorded one are reported as MISMATCH and left unchanged."))
 	fmt.Println(colorViolet("Flags:"))
-	fmt.Println("  " + colorLightYellow("-i string") + "  " + colorLightGreen("Path to inventory file (default 'inventory.yml')"))
-	fmt.Println("  " + colorLightYellow("--limit string") + "  " + colorLightGreen("Limit execution to a host or group name"))
-	fmt.Println("  " + colorLightYellow("--port int") + "  " + colorLightGreen("TCP port to check (default 22)"))
+	fmt.Println("  " + colorLightYellow("-i path") + "  " + colorLightGreen("Inventory file (YAML, INI or executable script) or directory; repeatable, merged in order (default 'inventory.yml')"))
+	fmt.Println("  " + colorLightYellow("--inventory-cache-ttl duration") + "  " + colorLightGreen("Reuse inventory script output cached under GOPSI_HOME for this long, e.g. 10m (default 0, always run)"))
+	fmt.Println("  " + colorLightYellow("--limit string") + "  " + colorLightGreen("Host pattern: group, host, glob (web*), ~regex, web:db, web:&prod, all:!canary, @retry-file"))
+	fmt.Println("  " + colorLightYellow("--file string") + "  " + colorLightGreen("known_hosts file (default ssh_known_hosts_file or ~/.ssh/known_hosts)"))
+	fmt.Println("  " + colorLightYellow("--port int") + "  " + colorLightGreen("SSH port (default: inventory port, ~/.ssh/config Port, or 22)"))
 	fmt.Println("  " + colorLightYellow("--timeout int") + "  " + colorLightGreen("Connection timeout in seconds (default 5)"))
 }
 
@@ -621,19 +880,22 @@ _gopsi()
 {
     local cur prev words cword
     _init_completion || return
-    local cmds="run inventory vault version help ping modules completion"
+    local cmds="run inventory vault version help ping known-hosts modules completion"
     case ${COMP_WORDS[1]} in
         run)
-            COMPREPLY=( $(compgen -W "-i --limit --forks --check --json -v -vv -vvv" -- "$cur") )
+            COMPREPLY=( $(compgen -W "-i --inventory-cache-ttl --limit --forks --check --json --vault-password-file --host-key-checking --force-handlers --ask-become-pass --fact-cache-ttl --tags --skip-tags --list-tags --list-tasks -v -vv -vvv" -- "$cur") )
             ;;
         inventory)
-            COMPREPLY=( $(compgen -W "--list -i" -- "$cur") )
+            COMPREPLY=( $(compgen -W "--list --graph --host --yaml --export -i --inventory-cache-ttl --limit" -- "$cur") )
             ;;
         vault)
             COMPREPLY=( $(compgen -W "--mode --in --out --pass" -- "$cur") )
             ;;
         ping)
-            COMPREPLY=( $(compgen -W "-i --limit --port --timeout" -- "$cur") )
+            COMPREPLY=( $(compgen -W "-i --inventory-cache-ttl --limit --port --timeout" -- "$cur") )
+            ;;
+        known-hosts)
+            COMPREPLY=( $(compgen -W "scan -i --inventory-cache-ttl --limit --file --port --timeout" -- "$cur") )
             ;;
         modules)
             COMPREPLY=()
@@ -653,7 +915,7 @@ func printZshCompletion() {
 	fmt.Println(`# zsh completion for gopsi
 _gopsi() {
   local -a cmds
-  cmds=(run inventory vault version help ping modules completion)
+  cmds=(run inventory vault version help ping known-hosts modules completion)
   local state
   _arguments \
     '1: :->cmd' \
@@ -665,16 +927,19 @@ _gopsi() {
     args)
       case $words[2] in
         run)
-          _arguments '-i[Inventory file]' '--limit[Limit hosts/group]' '--forks[Parallel]' '--check[Check mode]' '--json[JSON output]' '(-v -vv -vvv)-v[Verbose]' '(-v -vv -vvv)-vv[More verbose]' '(-v -vv -vvv)-vvv[Max verbose]'
+          _arguments '*-i[Inventory file, directory or script]:inventory:_files' '--inventory-cache-ttl[Inventory script cache TTL]' '--limit[Limit hosts/group]' '--forks[Parallel]' '--check[Check mode]' '--json[JSON output]' '--vault-password-file[Vault password file]' '--host-key-checking[strict|accept-new|off]' '--force-handlers[Run handlers on failure]' '--ask-become-pass[Prompt for become password]' '--fact-cache-ttl[Fact cache TTL]' '--tags[Only tags]' '--skip-tags[Skip tags]' '--list-tags[List tags]' '--list-tasks[List tasks]' '(-v -vv -vvv)-v[Verbose]' '(-v -vv -vvv)-vv[More verbose]' '(-v -vv -vvv)-vvv[Max verbose]'
           ;;
         inventory)
-          _arguments '--list[List hosts]' '-i[Inventory file]'
+          _arguments '--list[List hosts]' '--graph[Group tree]' '--host[Host vars and sources]' '--yaml[YAML output]' '--export[Dynamic inventory JSON]' '*-i[Inventory file, directory or script]:inventory:_files' '--inventory-cache-ttl[Inventory script cache TTL]' '--limit[Host pattern]'
           ;;
         vault)
-          _arguments '--mode[encrypt|decrypt]' '--in[input]' '--out[output]' '--pass[passphrase]'
+          _arguments '--mode[encrypt|decrypt|encrypt-string]' '--in[input]' '--out[output]' '--pass[passphrase]'
           ;;
         ping)
-          _arguments '-i[Inventory file]' '--limit[Limit hosts/group]' '--port[TCP port]' '--timeout[Seconds]'
+          _arguments '*-i[Inventory file, directory or script]:inventory:_files' '--inventory-cache-ttl[Inventory script cache TTL]' '--limit[Limit hosts/group]' '--port[TCP port]' '--timeout[Seconds]'
+          ;;
+        known-hosts)
+          _arguments '1: :(scan)' '*-i[Inventory file, directory or script]:inventory:_files' '--inventory-cache-ttl[Inventory script cache TTL]' '--limit[Limit hosts/group]' '--file[known_hosts file]' '--port[SSH port]' '--timeout[Seconds]'
           ;;
         completion)
           _arguments '1: :(bash zsh)'
@@ -686,6 +951,128 @@ _gopsi() {
 compdef _gopsi gopsi`)
 }
 
+// listPlaybook prints the tasks (or tags) each play would run under the
+// given tag selection, without contacting any host.
+func listPlaybook(pb play.Playbook, only, skip []string, tasks bool) {
+	for i, pl := range pb.Plays {
+		fmt.Printf("%s #%d (%s):\n", colorViolet("play"), i+1, pl.Hosts)
+		var seen []string
+		for _, t := range play.Flatten(pl.Tasks) {
+			// meta tasks run whatever the tag selection
+			if t.Module != "meta" && !play.Selected(t.Tags, only, skip) {
+				continue
+			}
+			if tasks {
+				fmt.Printf("  %s\tTAGS: [%s]\n", colorLightYellow(t.DisplayName()), strings.Join(t.Tags, ", "))
+			}
+			for _, tag := range t.Tags {
+				if !containsString(seen, tag) {
+					seen = append(seen, tag)
+				}
+			}
+		}
+		if !tasks {
+			sort.Strings(seen)
+			fmt.Printf("  TASK TAGS: [%s]\n", strings.Join(seen, ", "))
+		}
+	}
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+func containsString(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+// isBoolFlag reports whether tok names a boolean flag in fs, so the
+// argument reordering in `run` does not swallow the following positional.
+func isBoolFlag(fs *flag.FlagSet, tok string) bool {
+	f := fs.Lookup(strings.TrimLeft(tok, "-"))
+	if f == nil {
+		return false
+	}
+	bf, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && bf.IsBoolFlag()
+}
+
+// inventoryFlag collects repeated -i values; none means inventory.yml.
+type inventoryFlag []string
+
+func (f *inventoryFlag) String() string { return strings.Join(*f, ",") }
+
+func (f *inventoryFlag) Set(v string) error {
+	*f = append(*f, v)
+	return nil
+}
+
+func (f inventoryFlag) paths() []string {
+	if len(f) == 0 {
+		return []string{"inventory.yml"}
+	}
+	return f
+}
+
+// loadInventory merges the inventory sources and the group_vars/ and
+// host_vars/ next to each, then those in each of dirs (the playbook
+// directory), which take precedence. Inventory script output is cached
+// under gopsiHome() for cacheTTL.
+func loadInventory(sources inventoryFlag, cacheTTL time.Duration, vaultPass string, dirs ...string) (*inventory.Inventory, error) {
+	opts := inventory.Options{CacheDir: filepath.Join(gopsiHome(), "cache", "inventory"), CacheTTL: cacheTTL}
+	inv, err := inventory.LoadWith(opts, sources.paths()...)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	for _, dir := range append(inv.Dirs(), dirs...) {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return nil, err
+		}
+		if seen[abs] {
+			continue
+		}
+		seen[abs] = true
+		if err := inv.LoadVarsDir(dir, []byte(vaultPass)); err != nil {
+			return nil, err
+		}
+	}
+	return inv, nil
+}
+
+// readVaultPassword reads the vault password from file, falling back to the
+// AT_VAULT_PASSWORD environment variable used by `gopsi vault`.
+func readVaultPassword(file string) (string, error) {
+	if file == "" {
+		return os.Getenv("AT_VAULT_PASSWORD"), nil
+	}
+	b, err := os.ReadFile(expandHome(file))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+// promptSecret reads a secret from the terminal without echo.
+func promptSecret(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	b, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	return string(b), err
+}
+
 func stringVar(vars map[string]any, key string) string {
 	v, ok := vars[key]
 	if !ok || v == nil {
@@ -698,6 +1085,56 @@ func stringVar(vars map[string]any, key string) string {
 	return os.ExpandEnv(s)
 }
 
+// pingTransport opens a non-SSH connection and runs a no-op command.
+func pingTransport(h inventory.Host, kind string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	c, err := conn.Open(ctx, kind, conn.Target{Name: h.Name, Addr: h.Addr, Vars: h.Vars})
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	_, errOut, exit, err := c.Exec(ctx, "true", nil, false)
+	if err == nil && exit != 0 {
+		err = fmt.Errorf("exit %d: %s", exit, strings.TrimSpace(errOut))
+	}
+	return err
+}
+
+// sshTarget resolves the host:port gopsi run would dial for h: the port
+// flag, else the inventory port var, else the ~/.ssh/config Port (whose
+// HostName also applies), else 22. Jump hosts are not followed.
+func sshTarget(h inventory.Host, port int) string {
+	addr := h.Addr
+	if addr == "" {
+		addr = h.Name
+	}
+	var hc conn.HostConfig
+	if file := expandHome(stringVar(h.Vars, "ssh_config_file")); file != "none" {
+		if file == "" {
+			file = conn.DefaultSSHConfigFile()
+		}
+		if c, err := conn.LoadSSHConfig(file); err == nil {
+			hc = c.Lookup(addr)
+		}
+	}
+	if hc.HostName != "" {
+		addr = hc.HostName
+	}
+	if port == 0 {
+		switch v := h.Vars["port"].(type) {
+		case int:
+			port = v
+		case string:
+			port, _ = strconv.Atoi(v)
+		}
+	}
+	if port == 0 {
+		port = hc.Port
+	}
+	return conn.Config{Addr: addr, Port: port}.HostPort()
+}
+
 func expandHome(path string) string {
 	if path == "" {
 		return path
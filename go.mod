@@ -5,6 +5,7 @@ go 1.21
 require (
 	github.com/pkg/sftp v1.13.6
 	golang.org/x/crypto v0.26.0
+	golang.org/x/term v0.23.0
 	gopkg.in/yaml.v3 v3.0.1
 )
 
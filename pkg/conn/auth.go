@@ -0,0 +1,158 @@
+package conn
+
+import (
+    "errors"
+    "fmt"
+    "io"
+    "net"
+    "os"
+    "strings"
+    "sync"
+
+    "golang.org/x/crypto/ssh"
+    "golang.org/x/crypto/ssh/agent"
+)
+
+// Identity is a private key file offered for public key authentication.
+// When <Path>-cert.pub exists, the OpenSSH certificate is offered first.
+type Identity struct {
+    Path       string
+    Passphrase []byte
+}
+
+// authUsed records which credential the server accepted, for logging.
+type authUsed struct {
+    mu     sync.Mutex
+    method string
+}
+
+func (a *authUsed) set(m string) { a.mu.Lock(); a.method = m; a.mu.Unlock() }
+func (a *authUsed) get() string  { a.mu.Lock(); defer a.mu.Unlock(); return a.method }
+
+// labeledSigner notes its label when asked to sign; the client only signs
+// after the server has accepted the public key.
+type labeledSigner struct {
+    ssh.Signer
+    label string
+    used  *authUsed
+}
+
+func (s labeledSigner) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+    s.used.set(s.label)
+    return s.Signer.Sign(rand, data)
+}
+
+// authMethods builds the client auth chain: identity files (certificates
+// first), then agent keys, then password and keyboard-interactive. The
+// returned closer releases the agent connection once the handshake is done.
+func authMethods(cfg Config, used *authUsed) ([]ssh.AuthMethod, io.Closer, []string, error) {
+    var signers []ssh.Signer
+    var notes []string
+    for _, id := range cfg.Identities {
+        ss, err := loadIdentity(id, cfg.Passphrase)
+        if err != nil {
+            notes = append(notes, fmt.Sprintf("%s: %v", id.Path, err))
+            continue
+        }
+        for _, s := range ss {
+            signers = append(signers, labeledSigner{Signer: s.signer, label: s.label, used: used})
+        }
+    }
+    var closer io.Closer
+    if cfg.Agent {
+        if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+            ac, err := net.Dial("unix", sock)
+            if err != nil {
+                notes = append(notes, fmt.Sprintf("agent: %v", err))
+            } else {
+                closer = ac
+                as, err := agent.NewClient(ac).Signers()
+                if err != nil {
+                    notes = append(notes, fmt.Sprintf("agent: %v", err))
+                }
+                for _, s := range as {
+                    label := "agent:" + s.PublicKey().Type() + " " + ssh.FingerprintSHA256(s.PublicKey())
+                    signers = append(signers, labeledSigner{Signer: s, label: label, used: used})
+                }
+            }
+        }
+    }
+    var methods []ssh.AuthMethod
+    if len(signers) > 0 {
+        methods = append(methods, ssh.PublicKeys(signers...))
+    }
+    if cfg.Password != "" {
+        pw := cfg.Password
+        methods = append(methods,
+            ssh.PasswordCallback(func() (string, error) { used.set("password"); return pw, nil }),
+            ssh.KeyboardInteractive(func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+                used.set("keyboard-interactive")
+                answers := make([]string, len(questions))
+                for i := range questions {
+                    if !echos[i] {
+                        answers[i] = pw
+                    }
+                }
+                return answers, nil
+            }),
+        )
+    }
+    if len(methods) == 0 {
+        if closer != nil {
+            _ = closer.Close()
+        }
+        msg := "no usable SSH credentials (no identity files, agent keys or password)"
+        if len(notes) > 0 {
+            msg += ": " + strings.Join(notes, "; ")
+        }
+        return nil, nil, notes, errors.New(msg)
+    }
+    return methods, closer, notes, nil
+}
+
+type namedSigner struct {
+    signer ssh.Signer
+    label  string
+}
+
+func loadIdentity(id Identity, ask func(path string) ([]byte, error)) ([]namedSigner, error) {
+    b, err := os.ReadFile(id.Path)
+    if err != nil {
+        return nil, err
+    }
+    signer, err := ssh.ParsePrivateKey(b)
+    var missing *ssh.PassphraseMissingError
+    if errors.As(err, &missing) {
+        pass := id.Passphrase
+        if len(pass) == 0 && ask != nil {
+            if pass, err = ask(id.Path); err != nil {
+                return nil, fmt.Errorf("passphrase: %w", err)
+            }
+        }
+        if len(pass) == 0 {
+            return nil, errors.New("key is passphrase-protected and no passphrase is available")
+        }
+        signer, err = ssh.ParsePrivateKeyWithPassphrase(b, pass)
+    }
+    if err != nil {
+        return nil, err
+    }
+    var out []namedSigner
+    if cb, err := os.ReadFile(id.Path + "-cert.pub"); err == nil {
+        pub, _, _, _, err := ssh.ParseAuthorizedKey(cb)
+        if err != nil {
+            return nil, fmt.Errorf("%s-cert.pub: %w", id.Path, err)
+        }
+        cert, ok := pub.(*ssh.Certificate)
+        if !ok {
+            return nil, fmt.Errorf("%s-cert.pub: not a certificate", id.Path)
+        }
+        cs, err := ssh.NewCertSigner(cert, signer)
+        if err != nil {
+            return nil, fmt.Errorf("%s-cert.pub: %w", id.Path, err)
+        }
+        out = append(out, namedSigner{cs, "cert:" + id.Path + "-cert.pub"})
+    }
+    out = append(out, namedSigner{signer, "publickey:" + id.Path})
+    return out, nil
+}
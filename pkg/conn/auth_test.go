@@ -0,0 +1,61 @@
+package conn
+
+import (
+    "crypto/ed25519"
+    "crypto/rand"
+    "encoding/pem"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+
+    "golang.org/x/crypto/ssh"
+)
+
+func writeKey(t *testing.T, dir, name string, pass []byte) (string, ed25519.PrivateKey) {
+    _, priv, err := ed25519.GenerateKey(rand.Reader)
+    if err != nil { t.Fatal(err) }
+    var block *pem.Block
+    if pass != nil {
+        block, err = ssh.MarshalPrivateKeyWithPassphrase(priv, "", pass)
+    } else {
+        block, err = ssh.MarshalPrivateKey(priv, "")
+    }
+    if err != nil { t.Fatal(err) }
+    p := filepath.Join(dir, name)
+    if err := os.WriteFile(p, pem.EncodeToMemory(block), 0600); err != nil { t.Fatal(err) }
+    return p, priv
+}
+
+func TestAuthMethodsIdentities(t *testing.T) {
+    dir := t.TempDir()
+    plain, priv := writeKey(t, dir, "id_plain", nil)
+    locked, _ := writeKey(t, dir, "id_locked", []byte("s3cret"))
+
+    // certificate next to the plain key is offered before the key itself
+    ca := newSigner(t)
+    pub, err := ssh.NewPublicKey(priv.Public())
+    if err != nil { t.Fatal(err) }
+    cert := &ssh.Certificate{Key: pub, CertType: ssh.UserCert, ValidPrincipals: []string{"deploy"}, ValidBefore: ssh.CertTimeInfinity}
+    if err := cert.SignCert(rand.Reader, ca); err != nil { t.Fatal(err) }
+    if err := os.WriteFile(plain+"-cert.pub", ssh.MarshalAuthorizedKey(cert), 0644); err != nil { t.Fatal(err) }
+
+    signers, err := loadIdentity(Identity{Path: plain}, nil)
+    if err != nil { t.Fatal(err) }
+    if len(signers) != 2 || !strings.HasPrefix(signers[0].label, "cert:") { t.Fatalf("expected cert then key, got %v", signers) }
+
+    if _, err := loadIdentity(Identity{Path: locked}, nil); err == nil { t.Fatal("expected error for locked key without passphrase") }
+    asked := ""
+    ask := func(path string) ([]byte, error) { asked = path; return []byte("s3cret"), nil }
+    if _, err := loadIdentity(Identity{Path: locked}, ask); err != nil || asked != locked { t.Fatalf("prompted unlock failed: err=%v asked=%q", err, asked) }
+    if _, err := loadIdentity(Identity{Path: locked, Passphrase: []byte("s3cret")}, nil); err != nil { t.Fatalf("configured passphrase failed: %v", err) }
+
+    used := &authUsed{}
+    methods, closer, notes, err := authMethods(Config{Identities: []Identity{{Path: locked}, {Path: plain}}, Password: "pw"}, used)
+    if err != nil { t.Fatal(err) }
+    if closer != nil { closer.Close() }
+    if len(methods) != 3 { t.Fatalf("expected publickey, password and keyboard-interactive, got %d", len(methods)) }
+    if len(notes) != 1 || !strings.Contains(notes[0], "id_locked") { t.Fatalf("locked key should be noted as skipped: %v", notes) }
+
+    if _, _, _, err := authMethods(Config{}, used); err == nil { t.Fatal("expected error without credentials") }
+}
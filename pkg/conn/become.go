@@ -0,0 +1,193 @@
+package conn
+
+import (
+    "bytes"
+    "context"
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "regexp"
+    "strings"
+    "sync"
+)
+
+// Become describes how privileged commands are run: Exec with sudo=true on
+// a connection returned by WithBecome goes through Method as User. Modules
+// only pass the flag; they never embed sudo themselves.
+type Become struct {
+    Method   string // sudo (default), su or doas
+    User     string // default root
+    Password string // typed at the prompt through a pty when set
+}
+
+// ErrBecomePassword is returned when the become password is rejected.
+var ErrBecomePassword = errors.New("become password rejected")
+
+// becomePrompt is the sudo prompt Gopsi asks for, so it can be told apart
+// from command output.
+const becomePrompt = "[gopsi-become-password]:"
+
+// otherPrompt matches su and doas password prompts.
+var otherPrompt = regexp.MustCompile(`(?i)(password|passwort|mot de passe|contraseña)[^\n]*:\s*$`)
+
+func ParseBecomeMethod(s string) (string, error) {
+    switch s {
+    case "", "sudo":
+        return "sudo", nil
+    case "su", "doas":
+        return s, nil
+    }
+    return "", fmt.Errorf("unknown become_method %q (want sudo, su or doas)", s)
+}
+
+func (b Become) user() string {
+    if b.User == "" {
+        return "root"
+    }
+    return b.User
+}
+
+// Command wraps cmd for the become method. With prompt set the method may
+// ask for a password; otherwise it must fail instead of waiting for one.
+func (b Become) Command(cmd string, prompt bool) string {
+    inner := "bash -lc " + ShellQuote(cmd)
+    switch b.Method {
+    case "su":
+        return "su " + ShellQuote(b.user()) + " -c " + ShellQuote(inner)
+    case "doas":
+        if prompt {
+            return "doas -u " + ShellQuote(b.user()) + " " + inner
+        }
+        return "doas -n -u " + ShellQuote(b.user()) + " " + inner
+    }
+    if prompt {
+        return "sudo -H -p " + ShellQuote(becomePrompt) + " -u " + ShellQuote(b.user()) + " -- " + inner
+    }
+    return "sudo -H -n -u " + ShellQuote(b.user()) + " -- " + inner
+}
+
+// ShellQuote quotes s for POSIX shells.
+func ShellQuote(s string) string {
+    return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// becomeExecer is implemented by transports that run privileged commands
+// natively, e.g. typing the password through a pty.
+type becomeExecer interface {
+    ExecBecome(ctx context.Context, cmd string, env map[string]string, b Become) (string, string, int, error)
+}
+
+// ExecBecome runs cmd on c through b. Transports without native support get
+// the wrapped command, which then must not need a password.
+func ExecBecome(ctx context.Context, c Conn, cmd string, env map[string]string, b Become) (string, string, int, error) {
+    if be, ok := c.(becomeExecer); ok {
+        return be.ExecBecome(ctx, cmd, env, b)
+    }
+    if b.Password != "" {
+        return "", "", -1, fmt.Errorf("become password is not supported by this connection")
+    }
+    return c.Exec(ctx, b.Command(cmd, false), env, false)
+}
+
+// WithBecome returns a view of c whose privileged Execs go through b. With
+// files set, Put writes as b's user too: transports upload as the login
+// user, so the file goes to a temporary path first and is installed at dst
+// through b.
+func WithBecome(c Conn, b Become, files bool) Conn { return becomeConn{Conn: c, b: b, files: files} }
+
+type becomeConn struct {
+    Conn
+    b     Become
+    files bool
+}
+
+func (c becomeConn) Exec(ctx context.Context, cmd string, env map[string]string, sudo bool) (string, string, int, error) {
+    if !sudo {
+        return c.Conn.Exec(ctx, cmd, env, false)
+    }
+    return ExecBecome(ctx, c.Conn, cmd, env, c.b)
+}
+
+func (c becomeConn) Put(ctx context.Context, src io.Reader, dst string, mode os.FileMode) error {
+    if !c.files {
+        return c.Conn.Put(ctx, src, dst, mode)
+    }
+    out, errOut, exit, err := c.Conn.Exec(ctx, "mktemp /tmp/.gopsi-XXXXXXXX", nil, false)
+    if err != nil { return err }
+    if exit != 0 { return fmt.Errorf("mktemp: %s", strings.TrimSpace(errOut)) }
+    tmp := strings.TrimSpace(out)
+    defer c.Conn.Exec(ctx, "rm -f "+ShellQuote(tmp), nil, false)
+    // root reads anything; other become users need the upload readable
+    perm := os.FileMode(0600)
+    if c.b.user() != "root" { perm = 0644 }
+    if err := c.Conn.Put(ctx, src, tmp, perm); err != nil { return err }
+    cmd := fmt.Sprintf("install -m %04o %s %s", mode.Perm(), ShellQuote(tmp), ShellQuote(dst))
+    out, errOut, exit, err = ExecBecome(ctx, c.Conn, cmd, nil, c.b)
+    if err != nil { return err }
+    if exit != 0 { return fmt.Errorf("install %s as %s: %s", dst, c.b.user(), strings.TrimSpace(out+errOut)) }
+    return nil
+}
+
+// passwordFeeder watches a command's output for the become prompt, types the
+// password once, and keeps the output that follows. A second prompt means
+// the password was rejected.
+type passwordFeeder struct {
+    mu       sync.Mutex
+    stdin    io.Writer
+    password string
+    method   string
+    out      bytes.Buffer
+    sent     bool
+    rejected bool
+    onReject func()
+}
+
+func (f *passwordFeeder) Write(p []byte) (int, error) {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    f.out.Write(p)
+    if f.rejected {
+        return len(p), nil
+    }
+    b := f.out.Bytes()
+    end := -1
+    if f.method == "sudo" || f.method == "" {
+        if i := bytes.Index(b, []byte(becomePrompt)); i >= 0 {
+            end = i + len(becomePrompt)
+        }
+    } else if loc := otherPrompt.FindIndex(b); loc != nil {
+        end = loc[1]
+    }
+    if end < 0 {
+        return len(p), nil
+    }
+    if f.sent {
+        f.rejected = true
+        if f.onReject != nil {
+            f.onReject()
+        }
+        return len(p), nil
+    }
+    f.sent = true
+    rest := append([]byte(nil), b[end:]...)
+    f.out.Reset()
+    f.out.Write(bytes.TrimLeft(rest, "\r\n"))
+    _, err := io.WriteString(f.stdin, f.password+"\n")
+    return len(p), err
+}
+
+func (f *passwordFeeder) String() string {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    return strings.ReplaceAll(f.out.String(), "\r\n", "\n")
+}
+
+// hostEnv is os.Environ plus env, for local commands.
+func hostEnv(env map[string]string) []string {
+    out := os.Environ()
+    for k, v := range env {
+        out = append(out, k+"="+v)
+    }
+    return out
+}
@@ -0,0 +1,82 @@
+package conn
+
+import (
+    "bytes"
+    "context"
+    "io"
+    "os"
+    "strings"
+    "testing"
+)
+
+func TestBecomeCommand(t *testing.T) {
+    cmd := `echo "$HOME" 'x'`
+    got := Become{}.Command(cmd, false)
+    want := `sudo -H -n -u 'root' -- bash -lc 'echo "$HOME" '\''x'\'''`
+    if got != want { t.Fatalf("sudo:\n got %s\nwant %s", got, want) }
+    if got := (Become{Method: "doas", User: "app"}).Command("id", false); got != `doas -n -u 'app' bash -lc 'id'` { t.Fatalf("doas: %s", got) }
+    if got := (Become{Method: "su", User: "app"}).Command("id", true); got != `su 'app' -c 'bash -lc '\''id'\'''` { t.Fatalf("su: %s", got) }
+    if got := (Become{}).Command("id", true); !strings.Contains(got, becomePrompt) { t.Fatalf("sudo prompt missing: %s", got) }
+    if _, err := ParseBecomeMethod("pbrun"); err == nil { t.Fatal("expected error for unknown method") }
+}
+
+func TestPasswordFeeder(t *testing.T) {
+    var stdin bytes.Buffer
+    f := &passwordFeeder{stdin: &stdin, password: "pw", method: "sudo"}
+    f.Write([]byte("[gopsi-become-"))
+    f.Write([]byte("password]:\r\nhello\r\n"))
+    if stdin.String() != "pw\n" || f.String() != "hello\n" || f.rejected { t.Fatalf("stdin=%q out=%q rejected=%v", stdin.String(), f.String(), f.rejected) }
+
+    rejected := false
+    f = &passwordFeeder{stdin: &stdin, password: "bad", method: "sudo", onReject: func() { rejected = true }}
+    f.Write([]byte(becomePrompt))
+    f.Write([]byte("\r\nSorry, try again.\r\n" + becomePrompt))
+    if !f.rejected || !rejected { t.Fatal("second prompt should reject the password") }
+
+    stdin.Reset()
+    f = &passwordFeeder{stdin: &stdin, password: "pw", method: "su"}
+    f.Write([]byte("Password: "))
+    if stdin.String() != "pw\n" { t.Fatalf("su prompt not answered: %q", stdin.String()) }
+}
+
+type recordConn struct{ fakeConn; cmds []string }
+
+func (r *recordConn) Exec(ctx context.Context, cmd string, env map[string]string, sudo bool) (string, string, int, error) {
+    r.cmds = append(r.cmds, cmd)
+    return "", "", 0, nil
+}
+
+func TestWithBecome(t *testing.T) {
+    rc := &recordConn{}
+    c := WithBecome(rc, Become{Method: "doas", User: "app"}, false)
+    c.Exec(context.Background(), "id", nil, false)
+    c.Exec(context.Background(), "id", nil, true)
+    if rc.cmds[0] != "id" || rc.cmds[1] != `doas -n -u 'app' bash -lc 'id'` { t.Fatalf("cmds: %v", rc.cmds) }
+    if _, _, _, err := WithBecome(rc, Become{Password: "pw"}, false).Exec(context.Background(), "id", nil, true); err == nil { t.Fatal("plain connections cannot type a password") }
+}
+
+// putConn records commands and uploads; mktemp prints a fixed path.
+type putConn struct{ recordConn; puts map[string]os.FileMode }
+
+func (p *putConn) Exec(ctx context.Context, cmd string, env map[string]string, sudo bool) (string, string, int, error) {
+    p.cmds = append(p.cmds, cmd)
+    if strings.HasPrefix(cmd, "mktemp ") { return "/tmp/.gopsi-abc\n", "", 0, nil }
+    return "", "", 0, nil
+}
+
+func (p *putConn) Put(ctx context.Context, src io.Reader, dst string, mode os.FileMode) error {
+    p.puts[dst] = mode
+    return nil
+}
+
+func TestWithBecomePut(t *testing.T) {
+    pc := &putConn{puts: map[string]os.FileMode{}}
+    if err := WithBecome(pc, Become{}, true).Put(context.Background(), strings.NewReader("x"), "/etc/app.conf", 0640); err != nil { t.Fatal(err) }
+    want := []string{"mktemp /tmp/.gopsi-XXXXXXXX", `sudo -H -n -u 'root' -- bash -lc 'install -m 0640 '\''/tmp/.gopsi-abc'\'' '\''/etc/app.conf'\'''`, "rm -f '/tmp/.gopsi-abc'"}
+    if strings.Join(pc.cmds, "\n") != strings.Join(want, "\n") { t.Fatalf("cmds:\n%s", strings.Join(pc.cmds, "\n")) }
+    if len(pc.puts) != 1 || pc.puts["/tmp/.gopsi-abc"] != 0600 { t.Fatalf("puts: %v", pc.puts) }
+
+    pc = &putConn{puts: map[string]os.FileMode{}}
+    if err := WithBecome(pc, Become{User: "app"}, false).Put(context.Background(), strings.NewReader("x"), "/srv/app.conf", 0644); err != nil { t.Fatal(err) }
+    if len(pc.cmds) != 0 || pc.puts["/srv/app.conf"] != 0644 { t.Fatalf("plain put: cmds=%v puts=%v", pc.cmds, pc.puts) }
+}
@@ -0,0 +1,135 @@
+package conn
+
+import (
+    "archive/tar"
+    "bytes"
+    "context"
+    "fmt"
+    "io"
+    "os"
+    "os/exec"
+    "path"
+    "strings"
+    "time"
+)
+
+// ContainerConn execs into a running container through the docker or podman
+// CLI and copies files as tar streams with `cp`.
+type ContainerConn struct {
+    runtime   string
+    container string
+}
+
+// OpenContainer checks that container is running under runtime (docker or podman).
+func OpenContainer(ctx context.Context, runtime, container string) (*ContainerConn, error) {
+    if _, err := exec.LookPath(runtime); err != nil {
+        return nil, fmt.Errorf("%s connection: %w", runtime, err)
+    }
+    out, errOut, exit, err := runCmd(ctx, exec.CommandContext(ctx, runtime, "inspect", "-f", "{{.State.Running}}", container))
+    if err != nil {
+        return nil, err
+    }
+    if exit != 0 {
+        return nil, fmt.Errorf("%s container %s: %s", runtime, container, strings.TrimSpace(errOut))
+    }
+    if strings.TrimSpace(out) != "true" {
+        return nil, fmt.Errorf("%s container %s is not running", runtime, container)
+    }
+    return &ContainerConn{runtime: runtime, container: container}, nil
+}
+
+// Exec runs cmd with `bash -lc` inside the container, like the other
+// transports, or with `sh -c` on images without bash; sudo runs it as root instead
+// of the image's default user, since images rarely ship sudo.
+func (c *ContainerConn) Exec(ctx context.Context, cmd string, env map[string]string, sudo bool) (string, string, int, error) {
+    user := ""
+    if sudo {
+        user = "root"
+    }
+    return c.exec(ctx, cmd, env, user)
+}
+
+// ExecBecome runs cmd as b.User via the runtime's --user; method and
+// password do not apply inside containers.
+func (c *ContainerConn) ExecBecome(ctx context.Context, cmd string, env map[string]string, b Become) (string, string, int, error) {
+    return c.exec(ctx, cmd, env, b.user())
+}
+
+func (c *ContainerConn) exec(ctx context.Context, cmd string, env map[string]string, user string) (string, string, int, error) {
+    argv := []string{"exec", "-i"}
+    if user != "" {
+        argv = append(argv, "-u", user)
+    }
+    for k, v := range env {
+        argv = append(argv, "-e", k+"="+v)
+    }
+    argv = append(argv, c.container, "sh", "-c", containerShell(cmd))
+    return runCmd(ctx, exec.CommandContext(ctx, c.runtime, argv...))
+}
+
+// containerShell wraps cmd for `sh -c` so it runs under bash when the image
+// has it: slim images (alpine, distroless shells) often ship only sh.
+func containerShell(cmd string) string {
+    q := ShellQuote(cmd)
+    return "if command -v bash >/dev/null 2>&1; then exec bash -lc " + q + "; else exec sh -c " + q + "; fi"
+}
+
+// Put streams a single-entry tar archive into the destination directory.
+func (c *ContainerConn) Put(ctx context.Context, src io.Reader, dst string, mode os.FileMode) error {
+    data, err := io.ReadAll(src)
+    if err != nil {
+        return err
+    }
+    var buf bytes.Buffer
+    tw := tar.NewWriter(&buf)
+    hdr := &tar.Header{Name: path.Base(dst), Mode: int64(mode.Perm()), Size: int64(len(data)), ModTime: time.Now(), Typeflag: tar.TypeReg}
+    if err := tw.WriteHeader(hdr); err != nil {
+        return err
+    }
+    if _, err := tw.Write(data); err != nil {
+        return err
+    }
+    if err := tw.Close(); err != nil {
+        return err
+    }
+    cmd := exec.CommandContext(ctx, c.runtime, "cp", "-", c.container+":"+path.Dir(dst))
+    cmd.Stdin = &buf
+    _, errOut, exit, err := runCmd(ctx, cmd)
+    if err != nil {
+        return err
+    }
+    if exit != 0 {
+        return fmt.Errorf("%s cp %s: %s", c.runtime, dst, strings.TrimSpace(errOut))
+    }
+    return nil
+}
+
+// Get reads the tar stream of `cp container:src -` and returns the file's content.
+func (c *ContainerConn) Get(ctx context.Context, src string) (io.ReadCloser, error) {
+    out, errOut, exit, err := runCmd(ctx, exec.CommandContext(ctx, c.runtime, "cp", c.container+":"+src, "-"))
+    if err != nil {
+        return nil, err
+    }
+    if exit != 0 {
+        return nil, fmt.Errorf("%s cp %s: %s", c.runtime, src, strings.TrimSpace(errOut))
+    }
+    tr := tar.NewReader(strings.NewReader(out))
+    for {
+        hdr, err := tr.Next()
+        if err == io.EOF {
+            return nil, fmt.Errorf("%s: not a regular file", src)
+        }
+        if err != nil {
+            return nil, err
+        }
+        if hdr.Typeflag == tar.TypeReg {
+            b, err := io.ReadAll(tr)
+            if err != nil {
+                return nil, err
+            }
+            return io.NopCloser(bytes.NewReader(b)), nil
+        }
+    }
+}
+
+func (c *ContainerConn) Close() error { return nil }
@@ -0,0 +1,28 @@
+package conn
+
+import (
+    "os"
+    "os/exec"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+func TestContainerShell(t *testing.T) {
+    sh, err := exec.LookPath("sh")
+    if err != nil { t.Skip(err) }
+    // bash-only syntax under bash; plain sh when the image has no bash
+    run := func(path, cmd string) string {
+        c := exec.Command(sh, "-c", containerShell(cmd))
+        c.Env = []string{"PATH=" + path}
+        out, err := c.CombinedOutput()
+        if err != nil { t.Fatalf("%s: %v: %s", cmd, err, out) }
+        return strings.TrimSpace(string(out))
+    }
+    if _, err := exec.LookPath("bash"); err == nil {
+        if got := run(os.Getenv("PATH"), `[[ -n x ]] && echo "$BASH_VERSION" | cut -c1`); got == "" { t.Fatal("not run by bash") }
+    }
+    dir := t.TempDir()
+    if err := os.Symlink(sh, filepath.Join(dir, "sh")); err != nil { t.Fatal(err) }
+    if got := run(dir, `echo "it's ${0##*/}"`); got != "it's sh" { t.Fatalf("sh fallback: %q", got) }
+}
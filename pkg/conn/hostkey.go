@@ -0,0 +1,157 @@
+package conn
+
+import (
+    "errors"
+    "fmt"
+    "net"
+    "os"
+    "path/filepath"
+    "sync"
+    "time"
+
+    "golang.org/x/crypto/ssh"
+    "golang.org/x/crypto/ssh/knownhosts"
+)
+
+// HostKeyMode selects how server host keys are verified against known_hosts.
+// The zero value behaves as HostKeyStrict; only the CLI defaults to
+// HostKeyAcceptNew.
+type HostKeyMode string
+
+const (
+    // HostKeyStrict rejects hosts that are missing from known_hosts or whose key changed.
+    HostKeyStrict HostKeyMode = "strict"
+    // HostKeyAcceptNew trusts and records unknown hosts on first use but still rejects changed keys.
+    HostKeyAcceptNew HostKeyMode = "accept-new"
+    // HostKeyOff disables verification entirely.
+    HostKeyOff HostKeyMode = "off"
+)
+
+// ParseHostKeyMode parses a --host-key-checking or ssh_host_key_checking
+// value. Ansible/OpenSSH spellings (yes, no, tofu) are accepted; "" is
+// strict, like the zero HostKeyMode.
+func ParseHostKeyMode(s string) (HostKeyMode, error) {
+    switch s {
+    case "", "strict", "yes", "true":
+        return HostKeyStrict, nil
+    case "accept-new", "tofu":
+        return HostKeyAcceptNew, nil
+    case "off", "no", "false":
+        return HostKeyOff, nil
+    }
+    return "", fmt.Errorf("unknown host key checking mode %q (want strict, accept-new or off)", s)
+}
+
+// DefaultKnownHostsFile is ~/.ssh/known_hosts.
+func DefaultKnownHostsFile() string {
+    return filepath.Join(os.Getenv("HOME"), ".ssh", "known_hosts")
+}
+
+// knownHostsMu serializes appends so parallel forks do not interleave lines
+// or record the same host twice.
+var knownHostsMu sync.Mutex
+
+// HostKeyCallback verifies host keys against file according to mode.
+// The file is read at call time, so keys recorded by earlier dials are seen.
+func HostKeyCallback(mode HostKeyMode, file string) (ssh.HostKeyCallback, error) {
+    if mode == HostKeyOff {
+        return ssh.InsecureIgnoreHostKey(), nil
+    }
+    if file == "" {
+        file = DefaultKnownHostsFile()
+    }
+    return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+        err := checkKnownHost(file, hostname, remote, key)
+        var ke *knownhosts.KeyError
+        if err == nil || !errors.As(err, &ke) {
+            return err
+        }
+        if len(ke.Want) > 0 {
+            return fmt.Errorf("host key verification failed for %s: the %s key does not match %s:%d (possible man-in-the-middle); remove the stale entry if the host was rebuilt", hostname, key.Type(), ke.Want[0].Filename, ke.Want[0].Line)
+        }
+        if mode != HostKeyAcceptNew {
+            return fmt.Errorf("host key verification failed for %s: host not in %s (run 'gopsi known-hosts scan' or set ssh_host_key_checking=accept-new)", hostname, file)
+        }
+        return AddKnownHost(file, hostname, key)
+    }, nil
+}
+
+func checkKnownHost(file, hostname string, remote net.Addr, key ssh.PublicKey) error {
+    var files []string
+    if _, err := os.Stat(file); err == nil {
+        files = append(files, file)
+    }
+    cb, err := knownhosts.New(files...)
+    if err != nil {
+        return err
+    }
+    if remote == nil {
+        remote = &net.TCPAddr{}
+    }
+    return cb(hostname, remote, key)
+}
+
+// CheckKnownHost reports whether file already trusts key for addr. A changed
+// key is returned as an error.
+func CheckKnownHost(file, addr string, key ssh.PublicKey) (bool, error) {
+    err := checkKnownHost(file, addr, nil, key)
+    var ke *knownhosts.KeyError
+    if errors.As(err, &ke) {
+        if len(ke.Want) > 0 {
+            return false, fmt.Errorf("key mismatch with %s:%d", ke.Want[0].Filename, ke.Want[0].Line)
+        }
+        return false, nil
+    }
+    return err == nil, err
+}
+
+// AddKnownHost appends a known_hosts line for addr (host:port) unless an
+// identical entry was added meanwhile.
+func AddKnownHost(file, addr string, key ssh.PublicKey) error {
+    knownHostsMu.Lock()
+    defer knownHostsMu.Unlock()
+    if ok, err := CheckKnownHost(file, addr, key); ok || err != nil {
+        return err
+    }
+    if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+        return err
+    }
+    f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+    if err != nil {
+        return err
+    }
+    defer f.Close()
+    _, err = fmt.Fprintln(f, knownhosts.Line([]string{knownhosts.Normalize(addr)}, key))
+    return err
+}
+
+// errKeyCaptured aborts the handshake once ScanHostKey has the key.
+var errKeyCaptured = errors.New("host key captured")
+
+// ScanHostKey performs an SSH handshake with addr (host:port) only far enough
+// to learn the server's host key, like ssh-keyscan.
+func ScanHostKey(addr string, timeout time.Duration) (ssh.PublicKey, error) {
+    var hostKey ssh.PublicKey
+    cfg := &ssh.ClientConfig{
+        User: "gopsi-keyscan",
+        HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+            hostKey = key
+            return errKeyCaptured
+        },
+        Timeout: timeout,
+    }
+    c, err := net.DialTimeout("tcp", addr, timeout)
+    if err != nil {
+        return nil, err
+    }
+    defer c.Close()
+    _ = c.SetDeadline(time.Now().Add(timeout))
+    _, _, _, err = ssh.NewClientConn(c, addr, cfg)
+    if hostKey != nil {
+        return hostKey, nil
+    }
+    if err == nil {
+        err = errors.New("no host key received")
+    }
+    return nil, err
+}
@@ -0,0 +1,68 @@
+package conn
+
+import (
+    "crypto/ed25519"
+    "crypto/rand"
+    "net"
+    "path/filepath"
+    "testing"
+    "time"
+
+    "golang.org/x/crypto/ssh"
+)
+
+func newSigner(t *testing.T) ssh.Signer {
+    _, priv, err := ed25519.GenerateKey(rand.Reader)
+    if err != nil { t.Fatal(err) }
+    s, err := ssh.NewSignerFromKey(priv)
+    if err != nil { t.Fatal(err) }
+    return s
+}
+
+func TestHostKeyModes(t *testing.T) {
+    file := filepath.Join(t.TempDir(), "known_hosts")
+    key := newSigner(t).PublicKey()
+    other := newSigner(t).PublicKey()
+    remote := &net.TCPAddr{IP: net.ParseIP("10.0.0.5"), Port: 22}
+
+    strict, err := HostKeyCallback(HostKeyStrict, file)
+    if err != nil { t.Fatal(err) }
+    if err := strict("web1:22", remote, key); err == nil { t.Fatal("strict accepted unknown host") }
+
+    tofu, err := HostKeyCallback(HostKeyAcceptNew, file)
+    if err != nil { t.Fatal(err) }
+    if err := tofu("web1:22", remote, key); err != nil { t.Fatalf("accept-new rejected unknown host: %v", err) }
+    if err := strict("web1:22", remote, key); err != nil { t.Fatalf("strict rejected recorded host: %v", err) }
+    if err := tofu("web1:22", remote, other); err == nil { t.Fatal("accept-new accepted changed key") }
+    if known, err := CheckKnownHost(file, "web1:22", other); known || err == nil { t.Fatalf("mismatch not reported: known=%v err=%v", known, err) }
+    if known, err := CheckKnownHost(file, "web2:2222", key); known || err != nil { t.Fatalf("unknown host: known=%v err=%v", known, err) }
+
+    off, err := HostKeyCallback(HostKeyOff, file)
+    if err != nil { t.Fatal(err) }
+    if err := off("web1:22", remote, other); err != nil { t.Fatalf("off mode rejected key: %v", err) }
+}
+
+func TestScanHostKey(t *testing.T) {
+    hostKey := newSigner(t)
+    ln, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil { t.Fatal(err) }
+    defer ln.Close()
+    go func() {
+        c, err := ln.Accept()
+        if err != nil { return }
+        defer c.Close()
+        cfg := &ssh.ServerConfig{NoClientAuth: true}
+        cfg.AddHostKey(hostKey)
+        _, _, _, _ = ssh.NewServerConn(c, cfg)
+    }()
+    key, err := ScanHostKey(ln.Addr().String(), 5*time.Second)
+    if err != nil { t.Fatal(err) }
+    if string(key.Marshal()) != string(hostKey.PublicKey().Marshal()) { t.Fatal("scanned key does not match server key") }
+}
+
+func TestParseHostKeyMode(t *testing.T) {
+    for in, want := range map[string]HostKeyMode{"": HostKeyStrict, "yes": HostKeyStrict, "strict": HostKeyStrict, "tofu": HostKeyAcceptNew, "no": HostKeyOff} {
+        if got, err := ParseHostKeyMode(in); err != nil || got != want { t.Errorf("%q: got %q, %v; want %q", in, got, err, want) }
+    }
+    if _, err := ParseHostKeyMode("maybe"); err == nil { t.Error("expected error for unknown mode") }
+}
@@ -0,0 +1,87 @@
+package conn
+
+import (
+    "bytes"
+    "context"
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "os/exec"
+    "strings"
+)
+
+// LocalConn runs commands and copies files on the control node itself.
+type LocalConn struct{}
+
+func (l *LocalConn) Exec(ctx context.Context, cmd string, env map[string]string, sudo bool) (string, string, int, error) {
+    if sudo {
+        cmd = Become{}.Command(cmd, false)
+    }
+    c := exec.CommandContext(ctx, "bash", "-lc", cmd)
+    c.Env = hostEnv(env)
+    return runCmd(ctx, c)
+}
+
+// ExecBecome runs cmd through b. Without a terminal only sudo can take a
+// password, which is written to its stdin (sudo -S).
+func (l *LocalConn) ExecBecome(ctx context.Context, cmd string, env map[string]string, b Become) (string, string, int, error) {
+    if b.Password == "" {
+        return l.Exec(ctx, b.Command(cmd, false), env, false)
+    }
+    if b.Method != "" && b.Method != "sudo" {
+        return "", "", -1, fmt.Errorf("become_method %s with a password needs a terminal; use an ssh connection", b.Method)
+    }
+    c := exec.CommandContext(ctx, "sudo", "-S", "-H", "-p", becomePrompt, "-u", b.user(), "--", "bash", "-lc", cmd)
+    c.Env = hostEnv(env)
+    c.Stdin = strings.NewReader(b.Password + "\n")
+    out, errOut, exit, err := runCmd(ctx, c)
+    if err != nil {
+        return out, errOut, exit, err
+    }
+    if strings.Count(errOut, becomePrompt) > 1 {
+        return out, errOut, exit, ErrBecomePassword
+    }
+    return out, strings.Replace(errOut, becomePrompt, "", 1), exit, nil
+}
+
+func (l *LocalConn) Put(ctx context.Context, src io.Reader, dst string, mode os.FileMode) error {
+    f, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+    if err != nil {
+        return err
+    }
+    if _, err := io.Copy(f, src); err != nil {
+        f.Close()
+        return err
+    }
+    if err := f.Close(); err != nil {
+        return err
+    }
+    return os.Chmod(dst, mode)
+}
+
+func (l *LocalConn) Get(ctx context.Context, src string) (io.ReadCloser, error) {
+    return os.Open(src)
+}
+
+func (l *LocalConn) Close() error { return nil }
+
+// runCmd runs c and maps its outcome to Exec's (stdout, stderr, exit, err)
+// convention: a non-zero exit is not an error, a failure to start is.
+func runCmd(ctx context.Context, c *exec.Cmd) (string, string, int, error) {
+    var stdout, stderr bytes.Buffer
+    c.Stdout = &stdout
+    c.Stderr = &stderr
+    err := c.Run()
+    if ctx.Err() != nil {
+        return "", "", -1, ctx.Err()
+    }
+    var ee *exec.ExitError
+    if errors.As(err, &ee) {
+        return stdout.String(), stderr.String(), ee.ExitCode(), nil
+    }
+    if err != nil {
+        return "", "", -1, err
+    }
+    return stdout.String(), stderr.String(), 0, nil
+}
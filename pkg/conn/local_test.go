@@ -0,0 +1,31 @@
+package conn
+
+import (
+    "context"
+    "io"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+func TestLocalTransport(t *testing.T) {
+    ctx := context.Background()
+    c, err := Open(ctx, "local", Target{Name: "localhost"})
+    if err != nil { t.Fatal(err) }
+    defer c.Close()
+
+    out, _, exit, err := c.Exec(ctx, "echo $GOPSI_T; exit 3", map[string]string{"GOPSI_T": "hi"}, false)
+    if err != nil || exit != 3 || strings.TrimSpace(out) != "hi" { t.Fatalf("exec: out=%q exit=%d err=%v", out, exit, err) }
+
+    dst := filepath.Join(t.TempDir(), "f.txt")
+    if err := c.Put(ctx, strings.NewReader("data"), dst, 0640); err != nil { t.Fatal(err) }
+    if st, err := os.Stat(dst); err != nil || st.Mode().Perm() != 0640 { t.Fatalf("put: %v %v", st, err) }
+    rc, err := c.Get(ctx, dst)
+    if err != nil { t.Fatal(err) }
+    b, _ := io.ReadAll(rc)
+    rc.Close()
+    if string(b) != "data" { t.Fatalf("get: %q", b) }
+
+    if _, err := Open(ctx, "telnet", Target{Name: "x"}); err == nil { t.Fatal("expected unknown connection error") }
+}
@@ -0,0 +1,207 @@
+package conn
+
+import (
+    "context"
+    "errors"
+    "io"
+    "net"
+    "os"
+    "sort"
+    "sync"
+    "syscall"
+
+    "github.com/pkg/sftp"
+)
+
+// Pool shares one connection per key for the lifetime of a run, so every
+// play against a host reuses the same transport, and transparently reopens
+// connections that broke.
+type Pool struct {
+    // Logf, when set, is told about reconnects.
+    Logf  func(format string, a ...any)
+    mu    sync.Mutex
+    conns map[string]*Pooled
+}
+
+func NewPool() *Pool { return &Pool{conns: map[string]*Pooled{}} }
+
+// Get returns the pooled connection for key, opening it with open on first
+// use or after it broke. Callers for the same key share one dial.
+func (p *Pool) Get(ctx context.Context, key string, open func(ctx context.Context) (Closer, error)) (*Pooled, error) {
+    p.mu.Lock()
+    pc, ok := p.conns[key]
+    if !ok {
+        pc = &Pooled{key: key, open: open, logf: p.Logf}
+        p.conns[key] = pc
+    }
+    p.mu.Unlock()
+    if _, err := pc.conn(ctx); err != nil {
+        return nil, err
+    }
+    return pc, nil
+}
+
+// Close closes every pooled connection.
+func (p *Pool) Close() error {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    keys := make([]string, 0, len(p.conns))
+    for k := range p.conns { keys = append(keys, k) }
+    sort.Strings(keys)
+    var first error
+    for _, k := range keys {
+        if err := p.conns[k].close(); err != nil && first == nil {
+            first = err
+        }
+    }
+    p.conns = map[string]*Pooled{}
+    return first
+}
+
+// Pooled is a pool-owned connection. Exec and Get are retried once on a
+// fresh connection when the transport broke before the command started;
+// Put is not, as its source cannot be replayed.
+type Pooled struct {
+    key  string
+    open func(ctx context.Context) (Closer, error)
+    logf func(format string, a ...any)
+    mu   sync.Mutex
+    c    Closer
+}
+
+// Conn returns the current underlying connection, e.g. to log SSH details.
+func (p *Pooled) Conn() Closer {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    return p.c
+}
+
+func (p *Pooled) conn(ctx context.Context) (Closer, error) {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    if p.c != nil {
+        if a, ok := p.c.(interface{ Alive() bool }); !ok || a.Alive() {
+            return p.c, nil
+        }
+        _ = p.c.Close()
+        p.c = nil
+        if p.logf != nil {
+            p.logf("CONN %s lost, reconnecting", p.key)
+        }
+    }
+    c, err := p.open(ctx)
+    if err != nil {
+        return nil, dialError{err}
+    }
+    p.c = c
+    return c, nil
+}
+
+// dialError marks a failure to (re)open the pooled connection.
+type dialError struct{ err error }
+
+func (e dialError) Error() string { return e.err.Error() }
+func (e dialError) Unwrap() error { return e.err }
+
+// drop discards c after it failed, unless another caller already replaced it.
+func (p *Pooled) drop(c Closer, err error) {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    if p.c != c {
+        return
+    }
+    _ = c.Close()
+    p.c = nil
+    if p.logf != nil {
+        p.logf("CONN %s broken (%v), reconnecting", p.key, err)
+    }
+}
+
+func (p *Pooled) close() error {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    if p.c == nil {
+        return nil
+    }
+    err := p.c.Close()
+    p.c = nil
+    return err
+}
+
+func (p *Pooled) Exec(ctx context.Context, cmd string, env map[string]string, sudo bool) (string, string, int, error) {
+    c, err := p.conn(ctx)
+    if err != nil {
+        return "", "", -1, err
+    }
+    out, errOut, exit, err := c.Exec(ctx, cmd, env, sudo)
+    if err == nil || !broken(err) {
+        return out, errOut, exit, err
+    }
+    p.drop(c, err)
+    if c, err = p.conn(ctx); err != nil {
+        return "", "", -1, err
+    }
+    return c.Exec(ctx, cmd, env, sudo)
+}
+
+func (p *Pooled) ExecBecome(ctx context.Context, cmd string, env map[string]string, b Become) (string, string, int, error) {
+    c, err := p.conn(ctx)
+    if err != nil {
+        return "", "", -1, err
+    }
+    out, errOut, exit, err := ExecBecome(ctx, c, cmd, env, b)
+    if err == nil || !broken(err) {
+        return out, errOut, exit, err
+    }
+    p.drop(c, err)
+    if c, err = p.conn(ctx); err != nil {
+        return "", "", -1, err
+    }
+    return ExecBecome(ctx, c, cmd, env, b)
+}
+
+func (p *Pooled) Put(ctx context.Context, src io.Reader, dst string, mode os.FileMode) error {
+    c, err := p.conn(ctx)
+    if err != nil {
+        return err
+    }
+    if err := c.Put(ctx, src, dst, mode); err != nil {
+        if broken(err) {
+            p.drop(c, err)
+        }
+        return err
+    }
+    return nil
+}
+
+func (p *Pooled) Get(ctx context.Context, src string) (io.ReadCloser, error) {
+    c, err := p.conn(ctx)
+    if err != nil {
+        return nil, err
+    }
+    rc, err := c.Get(ctx, src)
+    if err == nil || !broken(err) {
+        return rc, err
+    }
+    p.drop(c, err)
+    if c, err = p.conn(ctx); err != nil {
+        return nil, err
+    }
+    return c.Get(ctx, src)
+}
+
+// Close is a no-op: the pool owns the connection until Pool.Close.
+func (p *Pooled) Close() error { return nil }
+
+// IsTransport reports whether err comes from the connection rather than
+// the command run over it: the link dropped and could not be reopened.
+func IsTransport(err error) bool {
+    return broken(err) || errors.As(err, new(dialError))
+}
+
+// broken reports errors that mean the transport itself is gone.
+func broken(err error) bool {
+    return errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) ||
+        errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ECONNRESET) ||
+        errors.Is(err, sftp.ErrSSHFxConnectionLost)
+}
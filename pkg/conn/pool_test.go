@@ -0,0 +1,66 @@
+package conn
+
+import (
+    "context"
+    "errors"
+    "io"
+    "os"
+    "testing"
+)
+
+type fakeConn struct {
+    id     int
+    alive  bool
+    failed bool
+    closed bool
+}
+
+func (f *fakeConn) Exec(ctx context.Context, cmd string, env map[string]string, sudo bool) (string, string, int, error) {
+    if f.failed {
+        return "", "", -1, io.EOF
+    }
+    return cmd, "", 0, nil
+}
+func (f *fakeConn) Put(ctx context.Context, src io.Reader, dst string, mode os.FileMode) error { return nil }
+func (f *fakeConn) Get(ctx context.Context, src string) (io.ReadCloser, error)               { return nil, nil }
+func (f *fakeConn) Close() error                                                             { f.closed = true; return nil }
+func (f *fakeConn) Alive() bool                                                              { return f.alive }
+
+func TestPoolReuseAndReconnect(t *testing.T) {
+    ctx := context.Background()
+    var opened []*fakeConn
+    var dialErr error
+    open := func(ctx context.Context) (Closer, error) {
+        if dialErr != nil { return nil, dialErr }
+        f := &fakeConn{id: len(opened), alive: true}
+        opened = append(opened, f)
+        return f, nil
+    }
+    p := NewPool()
+    a, err := p.Get(ctx, "ssh://u@h:22", open)
+    if err != nil { t.Fatal(err) }
+    b, _ := p.Get(ctx, "ssh://u@h:22", open)
+    if a != b || len(opened) != 1 { t.Fatalf("expected one shared connection, opened %d", len(opened)) }
+
+    // a transport error before the command ran is retried on a new connection
+    opened[0].failed = true
+    out, _, exit, err := a.Exec(ctx, "true", nil, false)
+    if err != nil || exit != 0 || out != "true" || len(opened) != 2 || !opened[0].closed { t.Fatalf("retry: out=%q err=%v opened=%d", out, err, len(opened)) }
+
+    // a connection that died in the background is replaced before use
+    opened[1].alive = false
+    if _, _, _, err := a.Exec(ctx, "true", nil, false); err != nil || len(opened) != 3 { t.Fatalf("reconnect: err=%v opened=%d", err, len(opened)) }
+
+    // a dropped link that cannot be reopened is a transport error
+    opened[2].failed = true
+    dialErr = errors.New("dial tcp: connection refused")
+    if _, _, _, err := a.Exec(ctx, "true", nil, false); err == nil || !IsTransport(err) { t.Fatalf("dropped: err=%v", err) }
+    if IsTransport(errors.New("exit status 1")) { t.Fatal("command errors are not transport errors") }
+    dialErr = nil
+    if _, _, _, err := a.Exec(ctx, "true", nil, false); err != nil || len(opened) != 4 { t.Fatalf("redial: err=%v opened=%d", err, len(opened)) }
+
+    a.Close()
+    if opened[3].closed { t.Fatal("Pooled.Close must not close the shared connection") }
+    p.Close()
+    if !opened[3].closed { t.Fatal("Pool.Close should close connections") }
+}
@@ -6,6 +6,8 @@ import (
     "io"
     "net"
     "os"
+    "strconv"
+    "strings"
     "time"
 
     "github.com/pkg/sftp"
@@ -21,32 +23,216 @@ type Conn interface {
 type SSHConn struct {
     client *ssh.Client
     sftp   *sftp.Client
+    auth   string
+    hops   []*ssh.Client
+    // sessions bounds concurrent Exec sessions on the multiplexed connection.
+    sessions chan struct{}
+    // done is closed once the transport has gone away.
+    done chan struct{}
 }
 
-func Dial(user, addr string, key []byte, timeout time.Duration) (*SSHConn, error) {
-    signer, err := ssh.ParsePrivateKey(key)
-    if err != nil {
-        return nil, err
+// DefaultMaxSessions stays below OpenSSH's default MaxSessions of 10,
+// leaving room for the SFTP subsystem channel.
+const DefaultMaxSessions = 8
+
+// keepAliveMax is how many unanswered keepalives close the connection,
+// like ServerAliveCountMax.
+const keepAliveMax = 3
+
+// Config describes how to reach and authenticate to a host.
+type Config struct {
+    User    string
+    Addr    string
+    Port    int // 0 means 22
+    Timeout time.Duration
+    // Authentication is tried in order: Identities (certificates first),
+    // agent keys when Agent is set and SSH_AUTH_SOCK is available, then
+    // Password via password and keyboard-interactive auth.
+    Identities []Identity
+    Agent      bool
+    Password   string
+    // Passphrase is asked for encrypted identities without a Passphrase;
+    // nil means such identities are skipped.
+    Passphrase func(path string) ([]byte, error)
+    // HostKeyMode and KnownHostsFile control host key verification;
+    // the zero values mean strict checking against ~/.ssh/known_hosts
+    // (the gopsi CLI passes accept-new unless told otherwise).
+    HostKeyMode    HostKeyMode
+    KnownHostsFile string
+    // KeepAlive sends keepalive@openssh.com requests at this interval and
+    // drops the connection after keepAliveMax misses; 0 disables them.
+    KeepAlive time.Duration
+    // MaxSessions bounds concurrent Exec sessions; 0 means DefaultMaxSessions.
+    MaxSessions int
+    // Jump lists bastion hops dialed in order before the target, like
+    // ssh -J; each hop authenticates with its own settings and its Jump
+    // field is ignored.
+    Jump []Config
+}
+
+// HostPort returns the host:port the config dials.
+func (cfg Config) HostPort() string {
+    port := cfg.Port
+    if port == 0 {
+        port = 22
     }
-    cfg := &ssh.ClientConfig{
-        User:            user,
-        Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
-        HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-        Timeout:         timeout,
+    return net.JoinHostPort(cfg.Addr, strconv.Itoa(port))
+}
+
+func Dial(cfg Config) (*SSHConn, error) {
+    var hops []*ssh.Client
+    closeHops := func() {
+        for i := len(hops) - 1; i >= 0; i-- {
+            _ = hops[i].Close()
+        }
+    }
+    var via *ssh.Client
+    for _, hop := range cfg.Jump {
+        c, _, err := dialClient(hop, via)
+        if err != nil {
+            closeHops()
+            return nil, fmt.Errorf("jump host %s: %w", hop.HostPort(), err)
+        }
+        hops = append(hops, c)
+        via = c
     }
-    c, err := ssh.Dial("tcp", net.JoinHostPort(addr, "22"), cfg)
+    c, auth, err := dialClient(cfg, via)
     if err != nil {
+        closeHops()
         return nil, err
     }
     s, err := sftp.NewClient(c)
     if err != nil {
         _ = c.Close()
+        closeHops()
+        return nil, err
+    }
+    max := cfg.MaxSessions
+    if max <= 0 {
+        max = DefaultMaxSessions
+    }
+    sc := &SSHConn{client: c, sftp: s, auth: auth, hops: hops, sessions: make(chan struct{}, max), done: make(chan struct{})}
+    go func() { _ = c.Wait(); close(sc.done) }()
+    if cfg.KeepAlive > 0 {
+        go sc.keepAlive(cfg.KeepAlive)
+    }
+    return sc, nil
+}
+
+// keepAlive pings the server until the connection ends and closes it when
+// keepAliveMax consecutive requests go unanswered.
+func (s *SSHConn) keepAlive(every time.Duration) {
+    t := time.NewTicker(every)
+    defer t.Stop()
+    missed := 0
+    for {
+        select {
+        case <-s.done:
+            return
+        case <-t.C:
+        }
+        reply := make(chan error, 1)
+        go func() {
+            _, _, err := s.client.SendRequest("keepalive@openssh.com", true, nil)
+            reply <- err
+        }()
+        select {
+        case err := <-reply:
+            if err != nil {
+                missed = keepAliveMax
+            } else {
+                missed = 0
+            }
+        case <-time.After(every):
+            missed++
+        case <-s.done:
+            return
+        }
+        if missed >= keepAliveMax {
+            _ = s.Close()
+            return
+        }
+    }
+}
+
+// Alive reports whether the SSH transport is still up.
+func (s *SSHConn) Alive() bool {
+    select {
+    case <-s.done:
+        return false
+    default:
+        return true
+    }
+}
+
+// dialClient connects and authenticates to cfg directly, or through the
+// already established via client when it is non-nil.
+func dialClient(cfg Config, via *ssh.Client) (*ssh.Client, string, error) {
+    hostKey, err := HostKeyCallback(cfg.HostKeyMode, cfg.KnownHostsFile)
+    if err != nil {
+        return nil, "", err
+    }
+    used := &authUsed{}
+    auth, agentConn, notes, err := authMethods(cfg, used)
+    if err != nil {
+        return nil, "", err
+    }
+    if agentConn != nil {
+        defer agentConn.Close()
+    }
+    sc := &ssh.ClientConfig{
+        User:            cfg.User,
+        Auth:            auth,
+        HostKeyCallback: hostKey,
+        Timeout:         cfg.Timeout,
+    }
+    addr := cfg.HostPort()
+    var c *ssh.Client
+    if via == nil {
+        c, err = ssh.Dial("tcp", addr, sc)
+    } else {
+        c, err = dialVia(via, addr, sc)
+    }
+    if err != nil {
+        if len(notes) > 0 {
+            err = fmt.Errorf("%w (skipped: %s)", err, strings.Join(notes, "; "))
+        }
+        return nil, "", err
+    }
+    return c, used.get(), nil
+}
+
+// dialVia opens a direct-tcpip channel through via and runs the SSH
+// handshake over it. Channels have no deadlines, so the timeout is
+// enforced by closing the channel if the handshake stalls.
+func dialVia(via *ssh.Client, addr string, sc *ssh.ClientConfig) (*ssh.Client, error) {
+    nc, err := via.Dial("tcp", addr)
+    if err != nil {
+        return nil, err
+    }
+    if sc.Timeout > 0 {
+        t := time.AfterFunc(sc.Timeout, func() { _ = nc.Close() })
+        defer t.Stop()
+    }
+    cc, chans, reqs, err := ssh.NewClientConn(nc, addr, sc)
+    if err != nil {
+        _ = nc.Close()
         return nil, err
     }
-    return &SSHConn{client: c, sftp: s}, nil
+    return ssh.NewClient(cc, chans, reqs), nil
 }
 
+// AuthMethod names the credential the server accepted, e.g.
+// "publickey:/home/me/.ssh/id_ed25519", "agent:ssh-ed25519 SHA256:..." or "password".
+func (s *SSHConn) AuthMethod() string { return s.auth }
+
 func (s *SSHConn) Exec(ctx context.Context, cmd string, env map[string]string, sudo bool) (string, string, int, error) {
+    select {
+    case s.sessions <- struct{}{}:
+        defer func() { <-s.sessions }()
+    case <-ctx.Done():
+        return "", "", -1, ctx.Err()
+    }
     sess, err := s.client.NewSession()
     if err != nil {
         return "", "", -1, err
@@ -58,7 +244,7 @@ func (s *SSHConn) Exec(ctx context.Context, cmd string, env map[string]string, s
         }
     }
     if sudo {
-        cmd = fmt.Sprintf("sudo -n bash -lc %q", cmd)
+        cmd = Become{}.Command(cmd, false)
     }
     var stdout, stderr io.Reader
     stdout, err = sess.StdoutPipe()
@@ -93,6 +279,66 @@ func (s *SSHConn) Exec(ctx context.Context, cmd string, env map[string]string, s
     }
 }
 
+// ExecBecome runs cmd through b. With a password the command gets a pty so
+// su, doas and sudo can prompt; the password is typed at the prompt and
+// stderr is merged into stdout, as on any terminal.
+func (s *SSHConn) ExecBecome(ctx context.Context, cmd string, env map[string]string, b Become) (string, string, int, error) {
+    if b.Password == "" {
+        return s.Exec(ctx, b.Command(cmd, false), env, false)
+    }
+    select {
+    case s.sessions <- struct{}{}:
+        defer func() { <-s.sessions }()
+    case <-ctx.Done():
+        return "", "", -1, ctx.Err()
+    }
+    sess, err := s.client.NewSession()
+    if err != nil {
+        return "", "", -1, err
+    }
+    defer sess.Close()
+    for k, v := range env {
+        if err := sess.Setenv(k, v); err != nil {
+            return "", "", -1, err
+        }
+    }
+    modes := ssh.TerminalModes{ssh.ECHO: 0, ssh.TTY_OP_ISPEED: 14400, ssh.TTY_OP_OSPEED: 14400}
+    if err := sess.RequestPty("xterm", 24, 200, modes); err != nil {
+        return "", "", -1, err
+    }
+    stdin, err := sess.StdinPipe()
+    if err != nil {
+        return "", "", -1, err
+    }
+    f := &passwordFeeder{stdin: stdin, password: b.Password, method: b.Method}
+    f.onReject = func() { _ = sess.Close() }
+    sess.Stdout = f
+    sess.Stderr = f
+    if err := sess.Start(b.Command(cmd, true)); err != nil {
+        return "", "", -1, err
+    }
+    done := make(chan error, 1)
+    go func() { done <- sess.Wait() }()
+    select {
+    case <-ctx.Done():
+        _ = sess.Signal(ssh.SIGKILL)
+        return "", "", -1, ctx.Err()
+    case err := <-done:
+        if f.rejected {
+            return f.String(), "", -1, ErrBecomePassword
+        }
+        exit := 0
+        if err != nil {
+            if ee, ok := err.(*ssh.ExitError); ok {
+                exit = ee.ExitStatus()
+            } else {
+                exit = 1
+            }
+        }
+        return f.String(), "", exit, nil
+    }
+}
+
 func (s *SSHConn) Put(ctx context.Context, src io.Reader, dst string, mode os.FileMode) error {
     f, err := s.sftp.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
     if err != nil {
@@ -111,7 +357,11 @@ func (s *SSHConn) Get(ctx context.Context, src string) (io.ReadCloser, error) {
 
 func (s *SSHConn) Close() error {
     _ = s.sftp.Close()
-    return s.client.Close()
+    err := s.client.Close()
+    for i := len(s.hops) - 1; i >= 0; i-- {
+        _ = s.hops[i].Close()
+    }
+    return err
 }
 
 func osCreateTrunc() int {
@@ -0,0 +1,163 @@
+package conn
+
+import (
+    "bufio"
+    "io"
+    "os"
+    "path"
+    "path/filepath"
+    "strconv"
+    "strings"
+)
+
+// SSHConfig holds the Host blocks of an OpenSSH client config file.
+// Only the options Gopsi uses are interpreted: HostName, Port, User,
+// IdentityFile and ProxyJump. Match blocks and Include are ignored.
+type SSHConfig struct {
+    blocks []sshBlock
+}
+
+type sshBlock struct {
+    patterns []string
+    match    bool // a Match block; never applied
+    opts     [][2]string
+}
+
+// HostConfig is the result of looking up one host in an SSHConfig.
+type HostConfig struct {
+    HostName      string
+    Port          int
+    User          string
+    IdentityFiles []string
+    ProxyJump     string
+}
+
+// DefaultSSHConfigFile is ~/.ssh/config.
+func DefaultSSHConfigFile() string {
+    return filepath.Join(os.Getenv("HOME"), ".ssh", "config")
+}
+
+// LoadSSHConfig parses file; a missing file yields an empty config.
+func LoadSSHConfig(file string) (*SSHConfig, error) {
+    f, err := os.Open(file)
+    if os.IsNotExist(err) {
+        return &SSHConfig{}, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+    return ParseSSHConfig(f)
+}
+
+func ParseSSHConfig(r io.Reader) (*SSHConfig, error) {
+    cfg := &SSHConfig{}
+    // options before the first Host apply to every host
+    cur := &sshBlock{patterns: []string{"*"}}
+    sc := bufio.NewScanner(r)
+    for sc.Scan() {
+        line := strings.TrimSpace(sc.Text())
+        if line == "" || strings.HasPrefix(line, "#") {
+            continue
+        }
+        key, val := splitConfigLine(line)
+        if key == "" {
+            continue
+        }
+        switch strings.ToLower(key) {
+        case "host":
+            cfg.blocks = append(cfg.blocks, *cur)
+            cur = &sshBlock{patterns: strings.FieldsFunc(val, func(r rune) bool { return r == ' ' || r == '\t' || r == ',' })}
+        case "match":
+            cfg.blocks = append(cfg.blocks, *cur)
+            cur = &sshBlock{match: true}
+        default:
+            cur.opts = append(cur.opts, [2]string{strings.ToLower(key), strings.Trim(val, "\"")})
+        }
+    }
+    cfg.blocks = append(cfg.blocks, *cur)
+    return cfg, sc.Err()
+}
+
+func splitConfigLine(line string) (string, string) {
+    i := strings.IndexAny(line, " \t=")
+    if i < 0 {
+        return line, ""
+    }
+    key := line[:i]
+    val := strings.TrimLeft(line[i:], " \t")
+    val = strings.TrimPrefix(val, "=")
+    return key, strings.TrimSpace(val)
+}
+
+// Lookup applies every matching Host block in file order; as in OpenSSH the
+// first value obtained for an option wins, except IdentityFile which
+// accumulates.
+func (c *SSHConfig) Lookup(host string) HostConfig {
+    var hc HostConfig
+    seen := map[string]bool{}
+    for _, b := range c.blocks {
+        if b.match || !matchHost(b.patterns, host) {
+            continue
+        }
+        for _, o := range b.opts {
+            key, val := o[0], o[1]
+            if key == "identityfile" {
+                hc.IdentityFiles = append(hc.IdentityFiles, val)
+                continue
+            }
+            if seen[key] {
+                continue
+            }
+            seen[key] = true
+            switch key {
+            case "hostname":
+                hc.HostName = val
+            case "port":
+                hc.Port, _ = strconv.Atoi(val)
+            case "user":
+                hc.User = val
+            case "proxyjump":
+                hc.ProxyJump = val
+            }
+        }
+    }
+    name := host
+    if hc.HostName != "" {
+        hc.HostName = expandTokens(hc.HostName, host, hc.User)
+        name = hc.HostName
+    }
+    for i, f := range hc.IdentityFiles {
+        hc.IdentityFiles[i] = expandTokens(f, name, hc.User)
+    }
+    if strings.EqualFold(hc.ProxyJump, "none") {
+        hc.ProxyJump = ""
+    }
+    return hc
+}
+
+func matchHost(patterns []string, host string) bool {
+    matched := false
+    for _, p := range patterns {
+        neg := strings.HasPrefix(p, "!")
+        p = strings.TrimPrefix(p, "!")
+        ok, _ := path.Match(strings.ToLower(p), strings.ToLower(host))
+        if ok && neg {
+            return false
+        }
+        if ok {
+            matched = true
+        }
+    }
+    return matched
+}
+
+// expandTokens handles ~ and the %h, %r, %u, %d and %% tokens.
+func expandTokens(s, host, user string) string {
+    home := os.Getenv("HOME")
+    if strings.HasPrefix(s, "~/") || s == "~" {
+        s = home + s[1:]
+    }
+    r := strings.NewReplacer("%%", "%", "%h", host, "%r", user, "%u", os.Getenv("USER"), "%d", home)
+    return r.Replace(s)
+}
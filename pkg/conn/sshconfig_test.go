@@ -0,0 +1,41 @@
+package conn
+
+import (
+    "strings"
+    "testing"
+)
+
+func TestSSHConfigLookup(t *testing.T) {
+    src := `
+User fallback
+# bastions
+Host bastion
+    HostName 10.0.0.5
+    Port 2222
+    IdentityFile ~/.ssh/bastion_%h
+
+Host *.internal !db.internal
+    ProxyJump bastion
+    User=deploy
+
+Match user root
+    Port 9999
+
+Host *
+    Port 22
+    IdentityFile ~/.ssh/id_ed25519
+`
+    cfg, err := ParseSSHConfig(strings.NewReader(src))
+    if err != nil { t.Fatal(err) }
+
+    b := cfg.Lookup("bastion")
+    if b.HostName != "10.0.0.5" || b.Port != 2222 || b.User != "fallback" { t.Fatalf("bastion: %+v", b) }
+    if len(b.IdentityFiles) != 2 || !strings.HasSuffix(b.IdentityFiles[0], "/.ssh/bastion_10.0.0.5") { t.Fatalf("identity files: %v", b.IdentityFiles) }
+
+    // options before the first Host win over later blocks
+    w := cfg.Lookup("web.internal")
+    if w.ProxyJump != "bastion" || w.User != "fallback" || w.Port != 22 || w.HostName != "" { t.Fatalf("web: %+v", w) }
+
+    // negated pattern excludes the block
+    if d := cfg.Lookup("db.internal"); d.ProxyJump != "" { t.Fatalf("db should not jump: %+v", d) }
+}
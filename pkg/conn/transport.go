@@ -0,0 +1,79 @@
+package conn
+
+import (
+    "context"
+    "fmt"
+    "sort"
+)
+
+// Closer is a Conn that owns resources released by Close. Every transport
+// returns one.
+type Closer interface {
+    Conn
+    Close() error
+}
+
+// Target is the host a transport connects to.
+type Target struct {
+    Name string
+    Addr string
+    Vars map[string]any
+    // SSH builds the SSH dial settings from the inventory; only the ssh
+    // transport calls it.
+    SSH func() (Config, error)
+}
+
+// Opener connects to a target for one `connection` type.
+type Opener func(ctx context.Context, t Target) (Closer, error)
+
+var transports = map[string]Opener{}
+
+func Register(name string, o Opener) { transports[name] = o }
+
+func Transports() []string {
+    names := make([]string, 0, len(transports))
+    for n := range transports { names = append(names, n) }
+    sort.Strings(names)
+    return names
+}
+
+// Open connects using the transport named kind; "" means ssh.
+func Open(ctx context.Context, kind string, t Target) (Closer, error) {
+    if kind == "" {
+        kind = "ssh"
+    }
+    o, ok := transports[kind]
+    if !ok {
+        return nil, fmt.Errorf("unknown connection %q (available: %v)", kind, Transports())
+    }
+    return o(ctx, t)
+}
+
+func init() {
+    Register("ssh", func(ctx context.Context, t Target) (Closer, error) {
+        if t.SSH == nil {
+            return nil, fmt.Errorf("%s: no SSH settings", t.Name)
+        }
+        cfg, err := t.SSH()
+        if err != nil {
+            return nil, err
+        }
+        return Dial(cfg)
+    })
+    Register("local", func(ctx context.Context, t Target) (Closer, error) { return &LocalConn{}, nil })
+    for _, rt := range []string{"docker", "podman"} {
+        rt := rt
+        Register(rt, func(ctx context.Context, t Target) (Closer, error) { return OpenContainer(ctx, rt, containerName(t)) })
+    }
+}
+
+// containerName is the `container` var, else the inventory address or name.
+func containerName(t Target) string {
+    if s, ok := t.Vars["container"].(string); ok && s != "" {
+        return s
+    }
+    if t.Addr != "" {
+        return t.Addr
+    }
+    return t.Name
+}
@@ -2,31 +2,428 @@ package eval
 
 import (
     "fmt"
+    "reflect"
+    "strconv"
     "strings"
 )
 
+// Expr is a compiled expression. It is safe for concurrent use and can be
+// evaluated against many variable sets (per host, per loop item, ...).
+type Expr struct {
+    src  string
+    root node
+}
+
+// Compile parses an expression such as
+//   facts.os_family == "Debian" and (port > 1024 or "web" in group_names)
+// Syntax errors are returned as *SyntaxError with the column of the problem.
+func Compile(expr string) (*Expr, error) {
+    toks, err := lex(expr)
+    if err != nil { return nil, err }
+    p := &parser{src: expr, toks: toks}
+    if p.peek().kind == tEOF { return nil, p.errAt(p.peek(), "empty expression") }
+    root, err := p.parseOr()
+    if err != nil { return nil, err }
+    if t := p.peek(); t.kind != tEOF { return nil, p.errAt(t, "unexpected %s", describe(t)) }
+    return &Expr{src: expr, root: root}, nil
+}
+
+func (e *Expr) String() string { return e.src }
+
+// Eval returns the value of the expression. Referencing an undefined
+// variable is an error unless it is guarded by `is defined`.
+func (e *Expr) Eval(vars map[string]any) (any, error) {
+    v, err := e.root.eval(vars)
+    if err != nil { return nil, err }
+    if u, ok := v.(undefined); ok { return nil, u.err() }
+    return v, nil
+}
+
+// Bool evaluates the expression and reports its truthiness.
+func (e *Expr) Bool(vars map[string]any) (bool, error) {
+    v, err := e.Eval(vars)
+    if err != nil { return false, err }
+    return Truthy(v), nil
+}
+
+// Eval compiles and evaluates expr in one step.
+func Eval(expr string, vars map[string]any) (any, error) {
+    e, err := Compile(expr)
+    if err != nil { return nil, err }
+    return e.Eval(vars)
+}
+
+// When evaluates a single `when` condition; an empty condition is true.
 func When(expr string, vars map[string]any) (bool, error) {
-    e := strings.TrimSpace(expr)
-    if e == "" { return true, nil }
-    if strings.HasPrefix(e, "not ") {
-        ok, err := When(strings.TrimSpace(strings.TrimPrefix(e, "not ")), vars)
-        return !ok, err
-    }
-    parts := strings.SplitN(e, "==", 2)
-    if len(parts) != 2 { return false, fmt.Errorf("unsupported expression: %s", expr) }
-    left := strings.TrimSpace(parts[0])
-    right := strings.TrimSpace(parts[1])
-    right = strings.Trim(right, "\"'")
-    val := get(vars, left)
-    return fmt.Sprintf("%v", val) == right, nil
-}
-
-func get(m map[string]any, path string) any {
-    cur := any(m)
+    if strings.TrimSpace(expr) == "" { return true, nil }
+    e, err := Compile(expr)
+    if err != nil { return false, err }
+    return e.Bool(vars)
+}
+
+// All evaluates a list-valued `when`: every condition must hold.
+// Evaluation stops at the first false condition.
+func All(conds []string, vars map[string]any) (bool, error) {
+    for _, c := range conds {
+        ok, err := When(c, vars)
+        if err != nil || !ok { return false, err }
+    }
+    return true, nil
+}
+
+// Truthy follows YAML/Jinja conventions: false, nil, zero, and empty
+// strings, lists and maps are false.
+func Truthy(v any) bool {
+    switch x := v.(type) {
+    case nil:
+        return false
+    case bool:
+        return x
+    case string:
+        return x != ""
+    case undefined:
+        return false
+    }
+    if f, ok := toFloat(v); ok { return f != 0 }
+    rv := reflect.ValueOf(v)
+    switch rv.Kind() {
+    case reflect.Slice, reflect.Map, reflect.Array:
+        return rv.Len() > 0
+    }
+    return true
+}
+
+// Lookup resolves a dotted path such as "result.stdout" in vars.
+func Lookup(vars map[string]any, path string) (any, bool) {
+    var cur any = vars
     for _, p := range strings.Split(path, ".") {
-        mm, ok := cur.(map[string]any)
-        if !ok { return nil }
-        cur = mm[p]
+        v := index(cur, p)
+        if _, ok := v.(undefined); ok { return nil, false }
+        cur = v
+    }
+    return cur, true
+}
+
+type node interface {
+    eval(vars map[string]any) (any, error)
+}
+
+// undefined is the value of a missing variable or key. It only survives
+// `is defined`/`is undefined` tests; any other use is an error.
+type undefined struct {
+    name string
+    col  int
+}
+
+func (u undefined) err() error {
+    return fmt.Errorf("undefined variable %q at column %d", u.name, u.col)
+}
+
+type litNode struct{ v any }
+
+func (n *litNode) eval(map[string]any) (any, error) { return n.v, nil }
+
+type listNode struct{ items []node }
+
+func (n *listNode) eval(vars map[string]any) (any, error) {
+    out := make([]any, 0, len(n.items))
+    for _, it := range n.items {
+        v, err := defined(it, vars)
+        if err != nil { return nil, err }
+        out = append(out, v)
+    }
+    return out, nil
+}
+
+type varNode struct {
+    name string
+    col  int
+}
+
+func (n *varNode) eval(vars map[string]any) (any, error) {
+    v, ok := vars[n.name]
+    if !ok { return undefined{name: n.name, col: n.col}, nil }
+    return v, nil
+}
+
+type indexNode struct {
+    x, key node
+    col    int
+}
+
+func (n *indexNode) eval(vars map[string]any) (any, error) {
+    base, err := n.x.eval(vars)
+    if err != nil { return nil, err }
+    k, err := defined(n.key, vars)
+    if err != nil { return nil, err }
+    if u, ok := base.(undefined); ok { return undefined{name: fmt.Sprintf("%s.%v", u.name, k), col: u.col}, nil }
+    v := index(base, k)
+    if _, ok := v.(undefined); ok { return undefined{name: fmt.Sprintf("%s.%v", pathOf(n.x), k), col: n.col}, nil }
+    return v, nil
+}
+
+// pathOf renders a variable reference for error messages.
+func pathOf(n node) string {
+    switch x := n.(type) {
+    case *varNode:
+        return x.name
+    case *indexNode:
+        if l, ok := x.key.(*litNode); ok { return fmt.Sprintf("%s.%v", pathOf(x.x), l.v) }
+        return pathOf(x.x) + "[...]"
+    }
+    return "(expr)"
+}
+
+func index(base any, k any) any {
+    switch b := base.(type) {
+    case map[string]any:
+        if v, ok := b[fmt.Sprintf("%v", k)]; ok { return v }
+        return undefined{}
+    case map[any]any:
+        if v, ok := b[k]; ok { return v }
+        if v, ok := b[fmt.Sprintf("%v", k)]; ok { return v }
+        return undefined{}
+    }
+    rv := reflect.ValueOf(base)
+    if rv.Kind() == reflect.Map && rv.Type().Key().Kind() == reflect.String {
+        if v := rv.MapIndex(reflect.ValueOf(fmt.Sprintf("%v", k)).Convert(rv.Type().Key())); v.IsValid() { return v.Interface() }
+        return undefined{}
+    }
+    if rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array {
+        i, ok := toInt(k)
+        if !ok { return undefined{} }
+        if i < 0 { i += rv.Len() }
+        if i < 0 || i >= rv.Len() { return undefined{} }
+        return rv.Index(i).Interface()
+    }
+    return undefined{}
+}
+
+type notNode struct{ x node }
+
+func (n *notNode) eval(vars map[string]any) (any, error) {
+    v, err := defined(n.x, vars)
+    if err != nil { return nil, err }
+    return !Truthy(v), nil
+}
+
+// negNode is unary minus over a non-literal operand, e.g. `-offset`.
+type negNode struct {
+    x   node
+    col int
+}
+
+func (n *negNode) eval(vars map[string]any) (any, error) {
+    v, err := defined(n.x, vars)
+    if err != nil { return nil, err }
+    switch x := v.(type) {
+    case int:
+        return -x, nil
+    case int64:
+        return -x, nil
     }
-    return cur
+    f, ok := toFloat(v)
+    if !ok { return nil, fmt.Errorf("unary '-' requires a number, got %T at column %d", v, n.col) }
+    return -f, nil
+}
+
+type logicNode struct {
+    op          string
+    left, right node
+}
+
+func (n *logicNode) eval(vars map[string]any) (any, error) {
+    l, err := defined(n.left, vars)
+    if err != nil { return nil, err }
+    if n.op == "and" && !Truthy(l) { return false, nil }
+    if n.op == "or" && Truthy(l) { return true, nil }
+    r, err := defined(n.right, vars)
+    if err != nil { return nil, err }
+    return Truthy(r), nil
+}
+
+type cmpNode struct {
+    op          string
+    left, right node
+    col         int
+}
+
+func (n *cmpNode) eval(vars map[string]any) (any, error) {
+    l, err := defined(n.left, vars)
+    if err != nil { return nil, err }
+    r, err := defined(n.right, vars)
+    if err != nil { return nil, err }
+    switch n.op {
+    case "==":
+        return Equal(l, r), nil
+    case "!=":
+        return !Equal(l, r), nil
+    }
+    c, err := Compare(l, r)
+    if err != nil { return nil, fmt.Errorf("%v at column %d", err, n.col) }
+    switch n.op {
+    case "<":
+        return c < 0, nil
+    case ">":
+        return c > 0, nil
+    case "<=":
+        return c <= 0, nil
+    default:
+        return c >= 0, nil
+    }
+}
+
+type inNode struct {
+    left, right node
+    col         int
+}
+
+func (n *inNode) eval(vars map[string]any) (any, error) {
+    l, err := defined(n.left, vars)
+    if err != nil { return nil, err }
+    r, err := defined(n.right, vars)
+    if err != nil { return nil, err }
+    if s, ok := r.(string); ok {
+        ls, ok := l.(string)
+        if !ok { return nil, fmt.Errorf("'in <string>' requires a string on the left, got %T at column %d", l, n.col) }
+        return strings.Contains(s, ls), nil
+    }
+    rv := reflect.ValueOf(r)
+    switch rv.Kind() {
+    case reflect.Slice, reflect.Array:
+        for i := 0; i < rv.Len(); i++ {
+            if Equal(l, rv.Index(i).Interface()) { return true, nil }
+        }
+        return false, nil
+    case reflect.Map:
+        _, isU := index(r, l).(undefined)
+        return !isU, nil
+    }
+    return nil, fmt.Errorf("'in' requires a list, map or string on the right, got %T at column %d", r, n.col)
+}
+
+type isNode struct {
+    x    node
+    test string
+}
+
+// tests are the names accepted after `is` / `is not`.
+var tests = map[string]func(v any) bool{
+    "defined":   func(v any) bool { _, u := v.(undefined); return !u },
+    "undefined": func(v any) bool { _, u := v.(undefined); return u },
+    "none":      func(v any) bool { return v == nil },
+    "string":    func(v any) bool { _, ok := v.(string); return ok },
+    "number":    func(v any) bool { _, ok := toFloat(v); return ok },
+    "mapping":   func(v any) bool { return v != nil && reflect.ValueOf(v).Kind() == reflect.Map },
+    "sequence":  func(v any) bool { k := reflect.ValueOf(v).Kind(); return v != nil && (k == reflect.Slice || k == reflect.Array) },
+    // registered results
+    "changed":   func(v any) bool { return resultFlag(v, "changed") },
+    "failed":    func(v any) bool { return resultFlag(v, "failed") },
+    "skipped":   func(v any) bool { return resultFlag(v, "skipped") },
+    "succeeded": func(v any) bool { _, u := v.(undefined); return !u && !resultFlag(v, "failed") },
+}
+
+func resultFlag(v any, key string) bool {
+    m, ok := v.(map[string]any)
+    if !ok { return false }
+    return Truthy(m[key])
+}
+
+func (n *isNode) eval(vars map[string]any) (any, error) {
+    v, err := n.x.eval(vars)
+    if err != nil { return nil, err }
+    return tests[n.test](v), nil
+}
+
+// defined evaluates n and turns an undefined value into an error.
+func defined(n node, vars map[string]any) (any, error) {
+    v, err := n.eval(vars)
+    if err != nil { return nil, err }
+    if u, ok := v.(undefined); ok { return nil, u.err() }
+    return v, nil
+}
+
+// Equal compares numbers numerically and falls back to comparing the
+// printed form of mixed scalars, so `rc == "0"` matches an int rc.
+func Equal(a, b any) bool {
+    if fa, ok := toFloat(a); ok {
+        if fb, ok := toFloat(b); ok { return fa == fb }
+    }
+    if a == nil || b == nil { return a == nil && b == nil }
+    if isScalar(a) && isScalar(b) { return fmt.Sprintf("%v", a) == fmt.Sprintf("%v", b) }
+    return reflect.DeepEqual(a, b)
+}
+
+// Compare orders two values: numbers numerically (numeric strings are
+// converted when compared with a number) and strings lexically.
+func Compare(a, b any) (int, error) {
+    fa, na := toFloat(a)
+    fb, nb := toFloat(b)
+    if na && !nb { fb, nb = parseNumber(b) }
+    if nb && !na { fa, na = parseNumber(a) }
+    if na && nb {
+        switch {
+        case fa < fb:
+            return -1, nil
+        case fa > fb:
+            return 1, nil
+        }
+        return 0, nil
+    }
+    sa, oka := a.(string)
+    sb, okb := b.(string)
+    if oka && okb { return strings.Compare(sa, sb), nil }
+    return 0, fmt.Errorf("cannot compare %T with %T", a, b)
+}
+
+func isScalar(v any) bool {
+    switch v.(type) {
+    case string, bool:
+        return true
+    }
+    _, ok := toFloat(v)
+    return ok
+}
+
+func toFloat(v any) (float64, bool) {
+    switch x := v.(type) {
+    case int:
+        return float64(x), true
+    case int8:
+        return float64(x), true
+    case int16:
+        return float64(x), true
+    case int32:
+        return float64(x), true
+    case int64:
+        return float64(x), true
+    case uint:
+        return float64(x), true
+    case uint8:
+        return float64(x), true
+    case uint16:
+        return float64(x), true
+    case uint32:
+        return float64(x), true
+    case uint64:
+        return float64(x), true
+    case float32:
+        return float64(x), true
+    case float64:
+        return x, true
+    }
+    return 0, false
+}
+
+func parseNumber(v any) (float64, bool) {
+    s, ok := v.(string)
+    if !ok { return 0, false }
+    f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+    return f, err == nil
+}
+
+func toInt(v any) (int, bool) {
+    if f, ok := toFloat(v); ok { return int(f), true }
+    if f, ok := parseNumber(v); ok { return int(f), true }
+    return 0, false
 }
@@ -1,6 +1,9 @@
 package eval
 
-import "testing"
+import (
+    "errors"
+    "testing"
+)
 
 func TestWhenEquals(t *testing.T) {
     ok, err := When("facts.os_family == \"Linux\"", map[string]any{"facts": map[string]any{"os_family": "Linux"}})
@@ -8,3 +11,100 @@ func TestWhenEquals(t *testing.T) {
     if !ok { t.Fatalf("expected true") }
 }
 
+func TestWhenExpressions(t *testing.T) {
+    vars := map[string]any{
+        "facts":   map[string]any{"os_family": "Linux", "distro": "Ubuntu", "distro_version": "22.04", "cpus": 4},
+        "port":    8080,
+        "enabled": true,
+        "groups":  []any{"web", "prod"},
+        "result":  map[string]any{"rc": 0, "stdout": "service is running", "lines": []any{"a", "b"}},
+        "empty":   "",
+        "reg":     map[string]any{"changed": true, "failed": false, "skipped": false, "rc": 0},
+    }
+    cases := []struct {
+        expr string
+        want bool
+    }{
+        {`facts.distro != "Debian"`, true},
+        {`port > 1024 and port < 65536`, true},
+        {`port >= 8080 and port <= 8080`, true},
+        {`facts.cpus > 2 or missing.key == 1`, true},
+        {`not (facts.os_family == "Linux")`, false},
+        {`!enabled`, false},
+        {`enabled == true`, true},
+        {`"web" in groups`, true},
+        {`"db" not in groups`, true},
+        {`"running" in result.stdout`, true},
+        {`"os_family" in facts`, true},
+        {`result.lines[1] == "b"`, true},
+        {`result.lines.0 == "a"`, true},
+        {`result["rc"] == 0`, true},
+        {`result.rc == "0"`, true},
+        {`facts.distro_version >= 20.04`, true},
+        {`missing is defined`, false},
+        {`missing is undefined`, true},
+        {`result.nope is not defined`, true},
+        {`missing.deep.path is undefined`, true},
+        {`empty`, false},
+        {`groups`, true},
+        {`facts.distro in ["Ubuntu", "Debian"]`, true},
+        {`(port == 1 or port == 8080) and enabled`, true},
+        {`true and not false`, true},
+        {`1.5 < 2`, true},
+        {`reg is changed`, true},
+        {`reg is succeeded and reg is not failed`, true},
+        {`reg is skipped`, false},
+        {`result.rc != -15`, true},
+        {`-1 < 0`, true},
+        {`-1.5 < -1`, true},
+        {`-port == -8080`, true},
+    }
+    for _, c := range cases {
+        got, err := When(c.expr, vars)
+        if err != nil { t.Errorf("%s: %v", c.expr, err); continue }
+        if got != c.want { t.Errorf("%s: got %v want %v", c.expr, got, c.want) }
+    }
+}
+
+func TestAllImplicitAnd(t *testing.T) {
+    vars := map[string]any{"a": 1, "b": 2}
+    if ok, err := All([]string{"a == 1", "b == 2"}, vars); err != nil || !ok { t.Fatalf("expected true, err=%v", err) }
+    if ok, err := All([]string{"a == 1", "b == 3", "undefined_var"}, vars); err != nil || ok { t.Fatalf("expected false without evaluating later conditions, err=%v", err) }
+}
+
+func TestSyntaxErrorColumn(t *testing.T) {
+    cases := []struct {
+        expr string
+        col  int
+    }{
+        {`a == `, 6},
+        {`(a == 1`, 8},
+        {`a == "x`, 6},
+        {`a is bogus`, 6},
+        {`a == 1 b`, 8},
+        {`a # 1`, 3},
+        {`größe == `, 10},
+        {`größe # 1`, 7},
+        {`"é" == x y`, 10},
+    }
+    for _, c := range cases {
+        _, err := Compile(c.expr)
+        var se *SyntaxError
+        if !errors.As(err, &se) { t.Errorf("%s: expected SyntaxError, got %v", c.expr, err); continue }
+        if se.Col != c.col { t.Errorf("%s: column %d want %d (%v)", c.expr, se.Col, c.col, err) }
+    }
+}
+
+func TestNegativeNumbersAndUnicodeIdents(t *testing.T) {
+    vars := map[string]any{"rc": -1, "out": map[string]any{"rc": -15}, "größe": 3, "名前": "x"}
+    for _, expr := range []string{`rc == -1`, `out.rc == -15`, `not (out.rc != -15)`, `-rc == 1`, `größe == 3`, `名前 == "x"`} {
+        ok, err := When(expr, vars)
+        if err != nil || !ok { t.Errorf("%s: got %v, err=%v", expr, ok, err) }
+    }
+    if _, err := When(`-名前`, vars); err == nil { t.Error("expected error negating a string") }
+}
+
+func TestUndefinedVariableIsError(t *testing.T) {
+    if _, err := When(`nope == 1`, map[string]any{}); err == nil { t.Fatal("expected error for undefined variable") }
+    if _, err := When(`port < "abc"`, map[string]any{"port": 1}); err == nil { t.Fatal("expected comparison error") }
+}
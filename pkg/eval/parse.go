@@ -0,0 +1,290 @@
+package eval
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+    "unicode"
+    "unicode/utf8"
+)
+
+// SyntaxError reports where an expression failed to parse. Col is 1-based.
+type SyntaxError struct {
+    Expr string
+    Col  int
+    Msg  string
+}
+
+func (e *SyntaxError) Error() string {
+    return fmt.Sprintf("%s at column %d in %q", e.Msg, e.Col, e.Expr)
+}
+
+type tokKind int
+
+const (
+    tEOF tokKind = iota
+    tIdent
+    tString
+    tNumber
+    tOp
+)
+
+type token struct {
+    kind tokKind
+    text string
+    col  int
+}
+
+func lex(src string) ([]token, error) {
+    var toks []token
+    i := 0
+    for i < len(src) {
+        c := src[i]
+        // columns count characters, not bytes, so `größe` is five wide
+        col := utf8.RuneCountInString(src[:i]) + 1
+        switch {
+        case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+            i++
+        case c == '"' || c == '\'':
+            var sb strings.Builder
+            j := i + 1
+            for ; j < len(src) && src[j] != c; j++ {
+                if src[j] == '\\' && j+1 < len(src) { j++ }
+                sb.WriteByte(src[j])
+            }
+            if j >= len(src) { return nil, &SyntaxError{Expr: src, Col: col, Msg: "unterminated string"} }
+            toks = append(toks, token{tString, sb.String(), col})
+            i = j + 1
+        case c >= '0' && c <= '9':
+            j := i
+            for j < len(src) && (src[j] >= '0' && src[j] <= '9' || src[j] == '.' && j+1 < len(src) && src[j+1] >= '0' && src[j+1] <= '9') { j++ }
+            toks = append(toks, token{tNumber, src[i:j], col})
+            i = j
+        case c == '_' || isLetter(src[i:]):
+            j := i
+            for j < len(src) {
+                r, n := utf8.DecodeRuneInString(src[j:])
+                if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) { break }
+                j += n
+            }
+            toks = append(toks, token{tIdent, src[i:j], col})
+            i = j
+        default:
+            if i+1 < len(src) {
+                switch src[i : i+2] {
+                case "==", "!=", "<=", ">=", "&&", "||":
+                    toks = append(toks, token{tOp, src[i : i+2], col})
+                    i += 2
+                    continue
+                }
+            }
+            switch c {
+            case '<', '>', '(', ')', '[', ']', ',', '.', '!', '-':
+                toks = append(toks, token{tOp, string(c), col})
+                i++
+            default:
+                r, _ := utf8.DecodeRuneInString(src[i:])
+                return nil, &SyntaxError{Expr: src, Col: col, Msg: fmt.Sprintf("unexpected character %q", r)}
+            }
+        }
+    }
+    toks = append(toks, token{tEOF, "", utf8.RuneCountInString(src) + 1})
+    return toks, nil
+}
+
+// isLetter reports whether s starts with a letter, decoding multi-byte
+// UTF-8 so identifiers like `größe` lex as one token.
+func isLetter(s string) bool {
+    r, _ := utf8.DecodeRuneInString(s)
+    return unicode.IsLetter(r)
+}
+
+type parser struct {
+    src  string
+    toks []token
+    pos  int
+}
+
+func (p *parser) peek() token { return p.toks[p.pos] }
+func (p *parser) next() token { t := p.toks[p.pos]; if t.kind != tEOF { p.pos++ }; return t }
+
+func (p *parser) errAt(t token, format string, a ...any) error {
+    return &SyntaxError{Expr: p.src, Col: t.col, Msg: fmt.Sprintf(format, a...)}
+}
+
+// isWord reports whether the next token is the keyword w.
+func (p *parser) isWord(w string) bool { t := p.peek(); return t.kind == tIdent && t.text == w }
+func (p *parser) isOp(op string) bool  { t := p.peek(); return t.kind == tOp && t.text == op }
+
+func (p *parser) expectOp(op string) error {
+    t := p.next()
+    if t.kind != tOp || t.text != op { return p.errAt(t, "expected %q, found %s", op, describe(t)) }
+    return nil
+}
+
+func describe(t token) string {
+    switch t.kind {
+    case tEOF:
+        return "end of expression"
+    case tString:
+        return fmt.Sprintf("string %q", t.text)
+    default:
+        return fmt.Sprintf("%q", t.text)
+    }
+}
+
+// Grammar, lowest precedence first:
+//   or     := and { ("or" | "||") and }
+//   and    := not { ("and" | "&&") not }
+//   not    := ("not" | "!") not | cmp
+//   cmp    := postfix [ cmpop postfix | ["not"] "in" postfix | "is" ["not"] test ]
+//   postfix:= primary { "." ident | "[" or "]" }
+//   primary:= literal | ident | "-" postfix | "(" or ")" | "[" [or {"," or}] "]"
+func (p *parser) parseOr() (node, error) {
+    left, err := p.parseAnd()
+    if err != nil { return nil, err }
+    for p.isWord("or") || p.isOp("||") {
+        p.next()
+        right, err := p.parseAnd()
+        if err != nil { return nil, err }
+        left = &logicNode{op: "or", left: left, right: right}
+    }
+    return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+    left, err := p.parseNot()
+    if err != nil { return nil, err }
+    for p.isWord("and") || p.isOp("&&") {
+        p.next()
+        right, err := p.parseNot()
+        if err != nil { return nil, err }
+        left = &logicNode{op: "and", left: left, right: right}
+    }
+    return left, nil
+}
+
+func (p *parser) parseNot() (node, error) {
+    if p.isWord("not") || p.isOp("!") {
+        p.next()
+        x, err := p.parseNot()
+        if err != nil { return nil, err }
+        return &notNode{x: x}, nil
+    }
+    return p.parseCmp()
+}
+
+func (p *parser) parseCmp() (node, error) {
+    left, err := p.parsePostfix()
+    if err != nil { return nil, err }
+    t := p.peek()
+    switch {
+    case t.kind == tOp && (t.text == "==" || t.text == "!=" || t.text == "<" || t.text == ">" || t.text == "<=" || t.text == ">="):
+        p.next()
+        right, err := p.parsePostfix()
+        if err != nil { return nil, err }
+        return &cmpNode{op: t.text, left: left, right: right, col: t.col}, nil
+    case t.kind == tIdent && t.text == "in":
+        p.next()
+        right, err := p.parsePostfix()
+        if err != nil { return nil, err }
+        return &inNode{left: left, right: right, col: t.col}, nil
+    case t.kind == tIdent && t.text == "not" && p.toks[p.pos+1].kind == tIdent && p.toks[p.pos+1].text == "in":
+        p.next()
+        p.next()
+        right, err := p.parsePostfix()
+        if err != nil { return nil, err }
+        return &notNode{x: &inNode{left: left, right: right, col: t.col}}, nil
+    case t.kind == tIdent && t.text == "is":
+        p.next()
+        negate := false
+        if p.isWord("not") { p.next(); negate = true }
+        nt := p.next()
+        if nt.kind != tIdent { return nil, p.errAt(nt, "expected test name after 'is', found %s", describe(nt)) }
+        if _, ok := tests[nt.text]; !ok { return nil, p.errAt(nt, "unknown test %q", nt.text) }
+        var n node = &isNode{x: left, test: nt.text}
+        if negate { n = &notNode{x: n} }
+        return n, nil
+    }
+    return left, nil
+}
+
+func (p *parser) parsePostfix() (node, error) {
+    x, err := p.parsePrimary()
+    if err != nil { return nil, err }
+    for {
+        switch {
+        case p.isOp("."):
+            p.next()
+            t := p.next()
+            if t.kind != tIdent && t.kind != tNumber { return nil, p.errAt(t, "expected attribute name after '.', found %s", describe(t)) }
+            x = &indexNode{x: x, key: &litNode{v: t.text}, col: t.col}
+        case p.isOp("["):
+            t := p.next()
+            key, err := p.parseOr()
+            if err != nil { return nil, err }
+            if err := p.expectOp("]"); err != nil { return nil, err }
+            x = &indexNode{x: x, key: key, col: t.col}
+        default:
+            return x, nil
+        }
+    }
+}
+
+func (p *parser) parsePrimary() (node, error) {
+    t := p.next()
+    switch t.kind {
+    case tString:
+        return &litNode{v: t.text}, nil
+    case tNumber:
+        if n, err := strconv.ParseInt(t.text, 10, 64); err == nil { return &litNode{v: int(n)}, nil }
+        f, err := strconv.ParseFloat(t.text, 64)
+        if err != nil { return nil, p.errAt(t, "invalid number %q", t.text) }
+        return &litNode{v: f}, nil
+    case tIdent:
+        switch t.text {
+        case "true", "True":
+            return &litNode{v: true}, nil
+        case "false", "False":
+            return &litNode{v: false}, nil
+        case "none", "None", "null":
+            return &litNode{v: nil}, nil
+        case "and", "or", "not", "in", "is":
+            return nil, p.errAt(t, "unexpected keyword %q", t.text)
+        }
+        return &varNode{name: t.text, col: t.col}, nil
+    case tOp:
+        switch t.text {
+        case "-":
+            x, err := p.parsePostfix()
+            if err != nil { return nil, err }
+            if l, ok := x.(*litNode); ok {
+                switch v := l.v.(type) {
+                case int:
+                    return &litNode{v: -v}, nil
+                case float64:
+                    return &litNode{v: -v}, nil
+                }
+            }
+            return &negNode{x: x, col: t.col}, nil
+        case "(":
+            x, err := p.parseOr()
+            if err != nil { return nil, err }
+            if err := p.expectOp(")"); err != nil { return nil, err }
+            return x, nil
+        case "[":
+            ln := &listNode{}
+            if p.isOp("]") { p.next(); return ln, nil }
+            for {
+                x, err := p.parseOr()
+                if err != nil { return nil, err }
+                ln.items = append(ln.items, x)
+                if p.isOp(",") { p.next(); continue }
+                if err := p.expectOp("]"); err != nil { return nil, err }
+                return ln, nil
+            }
+        }
+    }
+    if t.kind == tEOF { return nil, p.errAt(t, "unexpected end of expression") }
+    return nil, p.errAt(t, "unexpected %s", describe(t))
+}
@@ -0,0 +1,78 @@
+package facts
+
+import (
+    "encoding/json"
+    "net/url"
+    "os"
+    "path/filepath"
+    "sort"
+    "time"
+
+    "gopkg.in/yaml.v3"
+)
+
+// Cache keeps gathered facts as one JSON file per host so later runs can
+// skip gathering. The zero Cache is disabled.
+type Cache struct {
+    Dir string
+    TTL time.Duration
+}
+
+type cacheEntry struct {
+    Time  time.Time       `json:"time"`
+    Facts json.RawMessage `json:"facts"`
+}
+
+func (c Cache) enabled() bool { return c.Dir != "" && c.TTL > 0 }
+
+func (c Cache) path(host string) string {
+    return filepath.Join(c.Dir, url.PathEscape(host)+".json")
+}
+
+// Load returns the cached facts of host when they are younger than the TTL
+// and cover subset.
+func (c Cache) Load(host string, subset []string) (Facts, bool) {
+    if !c.enabled() { return nil, false }
+    b, err := os.ReadFile(c.path(host))
+    if err != nil { return nil, false }
+    var e cacheEntry
+    if err := json.Unmarshal(b, &e); err != nil || time.Since(e.Time) > c.TTL { return nil, false }
+    // YAML keeps whole numbers as ints, as in freshly gathered facts
+    var f Facts
+    if err := yaml.Unmarshal(e.Facts, &f); err != nil || !f.Covers(subset) { return nil, false }
+    return f, true
+}
+
+// Save writes the facts of host.
+func (c Cache) Save(host string, f Facts) error {
+    if !c.enabled() { return nil }
+    raw, err := json.Marshal(f)
+    if err != nil { return err }
+    b, err := json.Marshal(cacheEntry{Time: time.Now(), Facts: raw})
+    if err != nil { return err }
+    if err := os.MkdirAll(c.Dir, 0700); err != nil { return err }
+    tmp := c.path(host) + ".tmp"
+    if err := os.WriteFile(tmp, b, 0600); err != nil { return err }
+    return os.Rename(tmp, c.path(host))
+}
+
+// Merge overlays newer facts on older ones, e.g. a `setup` run with a
+// narrower subset; gather_subset becomes the union.
+func Merge(old, newer Facts) Facts {
+    out := Facts{}
+    for k, v := range old { out[k] = v }
+    for k, v := range newer { out[k] = v }
+    seen := map[string]bool{}
+    var subset []string
+    for _, f := range []Facts{old, newer} {
+        list, _ := f["gather_subset"].([]any)
+        for _, s := range list {
+            if n, ok := s.(string); ok && !seen[n] { seen[n] = true; subset = append(subset, n) }
+        }
+    }
+    sort.Strings(subset)
+    union := make([]any, len(subset))
+    for i, s := range subset { union[i] = s }
+    out["gather_subset"] = union
+    return out
+}
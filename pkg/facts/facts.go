@@ -1,23 +1,405 @@
 package facts
 
 import (
+    "bufio"
     "context"
+    "encoding/json"
+    "fmt"
+    "sort"
+    "strconv"
     "strings"
 
+    "gopkg.in/yaml.v3"
+
     "gopsi/pkg/module"
 )
 
+// Facts is what tasks see under `facts`.
 type Facts map[string]any
 
+// System is the typed result of fact gathering. The json names are the
+// keys under `facts` (facts.distro_version, facts.default_ipv4.address).
+type System struct {
+    OSFamily       string               `json:"os_family"`
+    Distro         string               `json:"distro"`
+    DistroID       string               `json:"distro_id"`
+    DistroVersion  string               `json:"distro_version"`
+    DistroCodename string               `json:"distro_codename"`
+    Architecture   string               `json:"architecture"`
+    Kernel         string               `json:"kernel"`
+    Hostname       string               `json:"hostname"`
+    FQDN           string               `json:"fqdn"`
+    CPUs           int                  `json:"processor_count"`
+    MemTotalMB     int                  `json:"memtotal_mb"`
+    MemFreeMB      int                  `json:"memfree_mb"`
+    DefaultIPv4    Route                `json:"default_ipv4"`
+    DefaultIPv6    Route                `json:"default_ipv6"`
+    Interfaces     map[string]Interface `json:"interfaces"`
+    Mounts         []Mount              `json:"mounts"`
+    InitSystem     string               `json:"init_system"`
+    PkgMgr         string               `json:"pkg_mgr"`
+    Python         string               `json:"python"` // interpreter path, "" when absent
+    PythonVersion  string               `json:"python_version"`
+    Local          map[string]any       `json:"local"` // see FactPath
+    Subset         []string             `json:"gather_subset"` // resolved subsets gathered
+}
+
+// Route is the source address and next hop used for the default route.
+type Route struct {
+    Address   string `json:"address"`
+    Interface string `json:"interface"`
+    Gateway   string `json:"gateway"`
+}
+
+type Interface struct {
+    MAC  string   `json:"macaddress"`
+    MTU  int      `json:"mtu"`
+    Up   bool     `json:"active"`
+    IPv4 []string `json:"ipv4"` // CIDR, e.g. 10.0.0.5/24
+    IPv6 []string `json:"ipv6"`
+}
+
+type Mount struct {
+    Device  string `json:"device"`
+    Mount   string `json:"mount"`
+    FSType  string `json:"fstype"`
+    Options string `json:"options"`
+    SizeMB  int    `json:"size_total_mb"`
+    AvailMB int    `json:"size_available_mb"`
+}
+
+// probes print one section each, introduced by an @@section line, so
+// every fact comes back in a single round-trip. Probes that are not
+// available print nothing.
+var probes = []struct{ subset, section, cmd string }{
+    {"min", "uname", "uname -s; uname -m; uname -r"},
+    {"min", "os-release", "cat /etc/os-release"},
+    {"min", "sw_vers", "sw_vers -productVersion"},
+    {"min", "hostname", "hostname; hostname -f"},
+    {"min", "init", "if [ -d /run/systemd/system ]; then echo systemd; else ps -p 1 -o comm= || cat /proc/1/comm; fi"},
+    {"min", "pkg", "for m in apt-get dnf yum zypper apk pacman brew; do command -v $m >/dev/null && { echo $m; break; }; done"},
+    {"min", "python", `for p in python3 python; do command -v $p && $p -c 'import sys; print("%d.%d.%d" % sys.version_info[:3])' && break; done`},
+    {"min", "local", localProbe},
+    {"hardware", "cpus", "getconf _NPROCESSORS_ONLN || nproc || sysctl -n hw.ncpu"},
+    {"hardware", "meminfo", "cat /proc/meminfo"},
+    {"hardware", "memsize", "sysctl -n hw.memsize"},
+    {"hardware", "mounts", "cat /proc/mounts"},
+    {"hardware", "df", "df -kP"},
+    {"network", "route4", "ip -4 route get 1.0.0.1"},
+    {"network", "route6", "ip -6 route get 2606:4700:4700::1111"},
+    {"network", "addr", "ip -o addr show"},
+    {"network", "link", "ip -o link show"},
+}
+
+// subsetKeys lists the facts each optional subset provides; they are left
+// out of Map when the subset was not gathered.
+var subsetKeys = map[string][]string{
+    "hardware": {"processor_count", "memtotal_mb", "memfree_mb", "mounts"},
+    "network":  {"default_ipv4", "default_ipv6", "interfaces"},
+}
+
+// ResolveSubset turns gather_subset entries ("all", "network,hardware",
+// "!hardware") into the sorted subsets to gather. "min" is always
+// included; no entries means all.
+func ResolveSubset(spec []string) ([]string, error) {
+    on := map[string]bool{"min": true}
+    var terms []string
+    for _, s := range spec {
+        for _, t := range strings.Split(s, ",") {
+            if t = strings.TrimSpace(t); t != "" { terms = append(terms, t) }
+        }
+    }
+    if len(terms) == 0 { terms = []string{"all"} }
+    for _, t := range terms {
+        neg := strings.HasPrefix(t, "!")
+        name := strings.TrimPrefix(t, "!")
+        var names []string
+        switch {
+        case name == "all":
+            names = sortedSubsets()
+        case name == "min":
+            continue
+        case subsetKeys[name] != nil:
+            names = []string{name}
+        default:
+            return nil, fmt.Errorf("unknown gather_subset %q (want all, min, %s)", t, strings.Join(sortedSubsets(), ", "))
+        }
+        for _, n := range names { on[n] = !neg }
+    }
+    var out []string
+    for n, ok := range on {
+        if ok { out = append(out, n) }
+    }
+    sort.Strings(out)
+    return out, nil
+}
+
+func sortedSubsets() []string {
+    var out []string
+    for n := range subsetKeys { out = append(out, n) }
+    sort.Strings(out)
+    return out
+}
+
+// Script returns the gathering command for the resolved subsets.
+func Script(subset []string) string {
+    lines := []string{"exec 2>/dev/null"}
+    for _, p := range probes {
+        if containsString(subset, p.subset) { lines = append(lines, "echo @@"+p.section+"; "+p.cmd) }
+    }
+    return strings.Join(append(lines, "true"), "\n")
+}
+
+// Gather probes the host for every subset and returns its facts as a map
+// for `when` and templates.
 func Gather(ctx context.Context, c module.Conn) (Facts, error) {
-    out, _, _, err := c.Exec(ctx, "uname -s", nil, false)
+    return GatherSubset(ctx, c, nil)
+}
+
+// GatherSubset gathers the gather_subset entries in spec.
+func GatherSubset(ctx context.Context, c module.Conn, spec []string) (Facts, error) {
+    subset, err := ResolveSubset(spec)
+    if err != nil { return nil, err }
+    s, err := GatherSystem(ctx, c, subset)
+    if err != nil { return nil, err }
+    return s.Map()
+}
+
+// GatherSystem probes the host in one command and parses the result.
+func GatherSystem(ctx context.Context, c module.Conn, subset []string) (*System, error) {
+    out, errOut, exit, err := c.Exec(ctx, Script(subset), nil, false)
+    if err != nil { return nil, err }
+    if exit != 0 { return nil, fmt.Errorf("gathering facts: exit %d: %s", exit, strings.TrimSpace(errOut)) }
+    return Parse(out), nil
+}
+
+// Map converts s to the generic form tasks see, without the facts of
+// subsets that were not gathered. Decoding through YAML keeps whole
+// numbers as ints.
+func (s *System) Map() (Facts, error) {
+    b, err := json.Marshal(s)
     if err != nil { return nil, err }
-    osfam := "Linux"
-    if strings.Contains(out, "Darwin") { osfam = "Darwin" }
-    facts := Facts{"os_family": osfam}
-    etc, _, _, _ := c.Exec(ctx, "cat /etc/os-release || true", nil, false)
-    if strings.Contains(etc, "Ubuntu") { facts["distro"] = "Ubuntu" }
-    if strings.Contains(etc, "Debian") { facts["distro"] = "Debian" }
-    if strings.Contains(etc, "CentOS") || strings.Contains(etc, "Red Hat") { facts["distro"] = "RHEL" }
-    return facts, nil
+    var m map[string]any
+    if err := yaml.Unmarshal(b, &m); err != nil { return nil, err }
+    for name, keys := range subsetKeys {
+        if containsString(s.Subset, name) { continue }
+        for _, k := range keys { delete(m, k) }
+    }
+    return Facts(m), nil
+}
+
+// Covers reports whether f was gathered with every subset in subset.
+func (f Facts) Covers(subset []string) bool {
+    have, _ := f["gather_subset"].([]any)
+    for _, want := range subset {
+        found := false
+        for _, h := range have {
+            if h == want { found = true }
+        }
+        if !found { return false }
+    }
+    return true
+}
+
+func containsString(list []string, s string) bool {
+    for _, e := range list {
+        if e == s { return true }
+    }
+    return false
+}
+
+// Parse reads the output of the gathering script; Subset lists the
+// subsets whose sections are present.
+func Parse(out string) *System {
+    sec, seen := sections(out)
+    s := &System{Interfaces: map[string]Interface{}, Subset: []string{"min"}}
+    for _, p := range probes {
+        if seen[p.section] && !containsString(s.Subset, p.subset) { s.Subset = append(s.Subset, p.subset) }
+    }
+    sort.Strings(s.Subset)
+
+    uname := sec["uname"]
+    s.OSFamily = "Linux"
+    if len(uname) > 0 && uname[0] == "Darwin" { s.OSFamily = "Darwin" }
+    if len(uname) > 1 { s.Architecture = uname[1] }
+    if len(uname) > 2 { s.Kernel = uname[2] }
+
+    rel := keyValues(sec["os-release"])
+    s.DistroID = rel["ID"]
+    s.DistroVersion = rel["VERSION_ID"]
+    s.DistroCodename = firstOf(rel["VERSION_CODENAME"], rel["UBUNTU_CODENAME"])
+    switch s.DistroID {
+    case "ubuntu":
+        s.Distro = "Ubuntu"
+    case "debian":
+        s.Distro = "Debian"
+    case "rhel", "centos":
+        s.Distro = "RHEL"
+    default:
+        s.Distro = rel["NAME"]
+    }
+    if s.OSFamily == "Darwin" {
+        s.Distro, s.DistroID = "macOS", "macos"
+        if v := sec["sw_vers"]; len(v) > 0 { s.DistroVersion = v[0] }
+    }
+
+    if h := sec["hostname"]; len(h) > 0 {
+        s.Hostname = strings.SplitN(h[0], ".", 2)[0]
+        s.FQDN = h[0]
+        if len(h) > 1 && strings.Contains(h[1], ".") { s.FQDN = h[1] }
+    }
+    if c := sec["cpus"]; len(c) > 0 { s.CPUs, _ = strconv.Atoi(c[0]) }
+
+    mem := map[string]int{}
+    for _, l := range sec["meminfo"] {
+        f := strings.Fields(l)
+        if len(f) >= 2 {
+            n, _ := strconv.Atoi(f[1])
+            mem[strings.TrimSuffix(f[0], ":")] = n / 1024
+        }
+    }
+    s.MemTotalMB = mem["MemTotal"]
+    s.MemFreeMB = mem["MemAvailable"]
+    if s.MemFreeMB == 0 { s.MemFreeMB = mem["MemFree"] }
+    if m := sec["memsize"]; s.MemTotalMB == 0 && len(m) > 0 {
+        n, _ := strconv.Atoi(m[0])
+        s.MemTotalMB = n / (1024 * 1024)
+    }
+
+    s.DefaultIPv4 = parseRoute(sec["route4"])
+    s.DefaultIPv6 = parseRoute(sec["route6"])
+    parseLinks(sec["link"], s.Interfaces)
+    parseAddrs(sec["addr"], s.Interfaces)
+    for name, it := range s.Interfaces {
+        if it.IPv4 == nil { it.IPv4 = []string{} }
+        if it.IPv6 == nil { it.IPv6 = []string{} }
+        s.Interfaces[name] = it
+    }
+    s.Mounts = parseMounts(sec["mounts"], sec["df"])
+
+    if v := sec["init"]; len(v) > 0 {
+        s.InitSystem = strings.TrimPrefix(v[0], "/sbin/")
+        if s.InitSystem == "init" { s.InitSystem = "sysvinit" }
+    }
+    if v := sec["pkg"]; len(v) > 0 { s.PkgMgr = strings.TrimSuffix(v[0], "-get") }
+    if v := sec["python"]; len(v) > 1 { s.Python, s.PythonVersion = v[0], v[1] }
+    s.Local = parseLocal(sec)
+    return s
+}
+
+// sections splits script output into its @@name sections, dropping blank
+// lines, and reports which sections were printed.
+func sections(out string) (map[string][]string, map[string]bool) {
+    sec := map[string][]string{}
+    seen := map[string]bool{}
+    cur := ""
+    sc := bufio.NewScanner(strings.NewReader(out))
+    sc.Buffer(make([]byte, 64*1024), 1024*1024)
+    for sc.Scan() {
+        l := strings.TrimSpace(sc.Text())
+        if strings.HasPrefix(l, "@@") { cur = l[2:]; seen[cur] = true; continue }
+        if l != "" && cur != "" { sec[cur] = append(sec[cur], l) }
+    }
+    return sec, seen
+}
+
+func keyValues(lines []string) map[string]string {
+    kv := map[string]string{}
+    for _, l := range lines {
+        if k, v, ok := strings.Cut(l, "="); ok { kv[k] = strings.Trim(v, `"'`) }
+    }
+    return kv
+}
+
+// parseRoute reads `ip route get`: "1.0.0.1 via 10.0.0.1 dev eth0 src 10.0.0.5 uid 0".
+func parseRoute(lines []string) Route {
+    var r Route
+    if len(lines) == 0 { return r }
+    f := strings.Fields(lines[0])
+    for i := 0; i+1 < len(f); i++ {
+        switch f[i] {
+        case "via":
+            r.Gateway = f[i+1]
+        case "dev":
+            r.Interface = f[i+1]
+        case "src":
+            r.Address = f[i+1]
+        }
+    }
+    return r
+}
+
+// parseLinks reads `ip -o link show`:
+// "2: eth0@if5: <BROADCAST,UP,LOWER_UP> mtu 1500 ... link/ether 02:42:ac:11:00:02 brd ...".
+func parseLinks(lines []string, ifs map[string]Interface) {
+    for _, l := range lines {
+        f := strings.Fields(l)
+        if len(f) < 3 { continue }
+        name := strings.TrimSuffix(f[1], ":")
+        if at := strings.Index(name, "@"); at > 0 { name = name[:at] }
+        it := ifs[name]
+        it.Up = strings.Contains(f[2], ",UP") || strings.Contains(f[2], "<UP")
+        for i := 3; i+1 < len(f); i++ {
+            switch {
+            case f[i] == "mtu":
+                it.MTU, _ = strconv.Atoi(f[i+1])
+            case strings.HasPrefix(f[i], "link/") && f[i] != "link/none":
+                it.MAC = f[i+1]
+            }
+        }
+        ifs[name] = it
+    }
+}
+
+// parseAddrs reads `ip -o addr show`: "2: eth0    inet 10.0.0.5/24 brd ...".
+func parseAddrs(lines []string, ifs map[string]Interface) {
+    for _, l := range lines {
+        f := strings.Fields(l)
+        if len(f) < 4 { continue }
+        it := ifs[f[1]]
+        switch f[2] {
+        case "inet":
+            it.IPv4 = append(it.IPv4, f[3])
+        case "inet6":
+            it.IPv6 = append(it.IPv6, f[3])
+        }
+        ifs[f[1]] = it
+    }
+}
+
+// parseMounts keeps block device and network mounts from /proc/mounts and
+// adds their sizes from `df -kP`.
+func parseMounts(mounts, df []string) []Mount {
+    size := map[string][2]int{}
+    for _, l := range df {
+        f := strings.Fields(l)
+        if len(f) < 6 || f[0] == "Filesystem" { continue }
+        total, _ := strconv.Atoi(f[1])
+        avail, _ := strconv.Atoi(f[3])
+        size[f[len(f)-1]] = [2]int{total / 1024, avail / 1024}
+    }
+    out := []Mount{}
+    for _, l := range mounts {
+        f := strings.Fields(l)
+        if len(f) < 4 { continue }
+        dev, fs := f[0], f[2]
+        if !strings.HasPrefix(dev, "/") && !strings.Contains(dev, ":") && fs != "zfs" { continue }
+        m := Mount{Device: dev, Mount: unescapeMount(f[1]), FSType: fs, Options: f[3]}
+        sz := size[m.Mount]
+        m.SizeMB, m.AvailMB = sz[0], sz[1]
+        out = append(out, m)
+    }
+    return out
+}
+
+// unescapeMount decodes the octal escapes /proc/mounts uses for spaces.
+func unescapeMount(s string) string {
+    return strings.NewReplacer(`\040`, " ", `\011`, "\t", `\134`, `\`).Replace(s)
+}
+
+func firstOf(ss ...string) string {
+    for _, s := range ss {
+        if s != "" { return s }
+    }
+    return ""
 }
@@ -0,0 +1,139 @@
+package facts
+
+import (
+    "os"
+    "os/exec"
+    "path/filepath"
+    "reflect"
+    "strings"
+    "testing"
+    "time"
+)
+
+const sample = `@@uname
+Linux
+aarch64
+6.1.0-18-arm64
+@@os-release
+NAME="Ubuntu"
+VERSION_ID="22.04"
+ID=ubuntu
+UBUNTU_CODENAME=jammy
+@@sw_vers
+@@hostname
+web1
+web1.example.com
+@@cpus
+4
+@@meminfo
+MemTotal:        8048100 kB
+MemFree:          300000 kB
+MemAvailable:    4096000 kB
+@@memsize
+@@route4
+1.0.0.1 via 10.0.0.1 dev eth0 src 10.0.0.5 uid 0
+    cache
+@@route6
+@@addr
+1: lo    inet 127.0.0.1/8 scope host lo\       valid_lft forever preferred_lft forever
+2: eth0    inet 10.0.0.5/24 brd 10.0.0.255 scope global eth0\       valid_lft forever preferred_lft forever
+2: eth0    inet6 fe80::1/64 scope link \       valid_lft forever preferred_lft forever
+@@link
+1: lo: <LOOPBACK,UP,LOWER_UP> mtu 65536 qdisc noqueue state UNKNOWN mode DEFAULT group default qlen 1000\    link/loopback 00:00:00:00:00:00 brd 00:00:00:00:00:00
+2: eth0@if7: <BROADCAST,MULTICAST,UP,LOWER_UP> mtu 1500 qdisc noqueue state UP mode DEFAULT group default\    link/ether 02:42:ac:11:00:02 brd ff:ff:ff:ff:ff:ff
+3: wg0: <POINTOPOINT,NOARP> mtu 1420 qdisc noop state DOWN mode DEFAULT group default qlen 1000\    link/none
+@@mounts
+/dev/sda1 / ext4 rw,relatime 0 0
+proc /proc proc rw,nosuid 0 0
+nas:/export /mnt/my\040share nfs4 rw 0 0
+@@df
+Filesystem     1024-blocks    Used Available Capacity Mounted on
+/dev/sda1         20480000 1024000  19456000       6% /
+@@init
+systemd
+@@pkg
+apt-get
+@@python
+/usr/bin/python3
+3.10.12
+`
+
+func TestParse(t *testing.T) {
+    s := Parse(sample)
+    if s.Distro != "Ubuntu" || s.DistroVersion != "22.04" || s.DistroCodename != "jammy" || s.Architecture != "aarch64" || s.Kernel != "6.1.0-18-arm64" { t.Fatalf("os: %+v", s) }
+    if s.Hostname != "web1" || s.FQDN != "web1.example.com" || s.CPUs != 4 || s.MemTotalMB != 7859 || s.MemFreeMB != 4000 { t.Fatalf("host: %+v", s) }
+    if s.DefaultIPv4 != (Route{Address: "10.0.0.5", Interface: "eth0", Gateway: "10.0.0.1"}) || s.DefaultIPv6 != (Route{}) { t.Fatalf("routes: %+v %+v", s.DefaultIPv4, s.DefaultIPv6) }
+    eth0 := Interface{MAC: "02:42:ac:11:00:02", MTU: 1500, Up: true, IPv4: []string{"10.0.0.5/24"}, IPv6: []string{"fe80::1/64"}}
+    if !reflect.DeepEqual(s.Interfaces["eth0"], eth0) { t.Fatalf("eth0: %+v", s.Interfaces["eth0"]) }
+    if wg := s.Interfaces["wg0"]; wg.Up || wg.MAC != "" || wg.MTU != 1420 { t.Fatalf("wg0: %+v", wg) }
+    mounts := []Mount{
+        {Device: "/dev/sda1", Mount: "/", FSType: "ext4", Options: "rw,relatime", SizeMB: 20000, AvailMB: 19000},
+        {Device: "nas:/export", Mount: "/mnt/my share", FSType: "nfs4", Options: "rw"},
+    }
+    if !reflect.DeepEqual(s.Mounts, mounts) { t.Fatalf("mounts: %+v", s.Mounts) }
+    if s.InitSystem != "systemd" || s.PkgMgr != "apt" || s.Python != "/usr/bin/python3" || s.PythonVersion != "3.10.12" { t.Fatalf("tools: %+v", s) }
+
+    m, err := s.Map()
+    if err != nil { t.Fatal(err) }
+    if m["processor_count"] != 4 || m["default_ipv4"].(map[string]any)["address"] != "10.0.0.5" { t.Fatalf("map: %v", m) }
+}
+
+func TestSubsetsAndCache(t *testing.T) {
+    for spec, want := range map[string][]string{
+        "":                  {"hardware", "min", "network"},
+        "min":               {"min"},
+        "network, hardware": {"hardware", "min", "network"},
+        "all,!hardware":     {"min", "network"},
+        "!all":              {"min"},
+    } {
+        got, err := ResolveSubset([]string{spec})
+        if err != nil || !reflect.DeepEqual(got, want) { t.Errorf("%q: %v %v", spec, got, err) }
+    }
+    if _, err := ResolveSubset([]string{"virtual"}); err == nil { t.Error("unknown subset should fail") }
+
+    min := Parse("@@uname\nLinux\nx86_64\n6.1\n@@cpus\n2\n")
+    if !reflect.DeepEqual(min.Subset, []string{"hardware", "min"}) { t.Fatalf("subset from sections: %v", min.Subset) }
+    min.Subset = []string{"min"}
+    f, err := min.Map()
+    if err != nil { t.Fatal(err) }
+    if _, ok := f["processor_count"]; ok { t.Fatalf("hardware facts should be left out: %v", f) }
+
+    c := Cache{Dir: t.TempDir(), TTL: time.Hour}
+    if err := c.Save("web/1", f); err != nil { t.Fatal(err) }
+    if _, ok := c.Load("web/1", []string{"min", "network"}); ok { t.Fatal("cache should not cover network") }
+    got, ok := c.Load("web/1", []string{"min"})
+    if !ok || got["architecture"] != "x86_64" { t.Fatalf("cache load: %v %v", got, ok) }
+    if _, ok := (Cache{Dir: c.Dir, TTL: time.Nanosecond}).Load("web/1", nil); ok { t.Fatal("expired entry should miss") }
+
+    net := Facts{"default_ipv4": map[string]any{"address": "10.0.0.5"}, "gather_subset": []any{"min", "network"}}
+    m := Merge(got, net)
+    if m["architecture"] != "x86_64" || !m.Covers([]string{"min", "network"}) { t.Fatalf("merge: %v", m) }
+}
+
+func TestLocalFacts(t *testing.T) {
+    dir := t.TempDir()
+    files := map[string]struct{ body string; mode os.FileMode }{
+        "app.json":  {`{"version": "1.4.2", "workers": 8}`, 0644},
+        "role.fact": {"[main]\nrole = api\ntier=\"backend\"\n", 0644},
+        "dyn":       {"#!/bin/sh\necho '{\"ok\": true}'\n", 0755},
+        "bad.json":  {"{oops", 0644},
+    }
+    for name, f := range files {
+        if err := os.WriteFile(filepath.Join(dir, name), []byte(f.body), f.mode); err != nil { t.Fatal(err) }
+    }
+    if err := os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil { t.Fatal(err) }
+    out, err := exec.Command("sh", "-c", "echo @@local; "+strings.ReplaceAll(localProbe, FactPath, dir)).Output()
+    if err != nil { t.Fatal(err) }
+    local := Parse(string(out)).Local
+    want := map[string]any{
+        "app":  map[string]any{"version": "1.4.2", "workers": 8},
+        "role": map[string]any{"main": map[string]any{"role": "api", "tier": "backend"}},
+        "dyn":  map[string]any{"ok": true},
+    }
+    for k, v := range want {
+        if !reflect.DeepEqual(local[k], v) { t.Errorf("local.%s = %#v, want %#v", k, local[k], v) }
+    }
+    if e, _ := local["bad"].(map[string]any); e == nil || e["error"] == nil { t.Errorf("bad file should report an error: %#v", local["bad"]) }
+    if len(local) != 4 { t.Errorf("unexpected local facts: %v", local) }
+    if l := Parse("@@uname\nLinux\n").Local; l == nil || len(l) != 0 { t.Errorf("no facts.d should give an empty map: %#v", l) }
+}
@@ -0,0 +1,70 @@
+package facts
+
+import (
+    "bufio"
+    "encoding/json"
+    "fmt"
+    "strings"
+
+    "gopkg.in/yaml.v3"
+)
+
+// FactPath is the remote directory holding local fact files. Each file
+// becomes facts.local.<name>, name being the file name without extension:
+// executables are run and their output parsed, other files are read.
+// Content is JSON or INI ([section] then key=value lines).
+const FactPath = "/etc/gopsi/facts.d"
+
+// localProbe prints one @@local.<name> section per fact file; the extra
+// echo keeps a missing final newline from swallowing the next marker.
+const localProbe = `for f in ` + FactPath + `/*; do [ -f "$f" ] || continue; n=${f##*/}; n=${n%.*}; echo "@@local.$n"; if [ -x "$f" ]; then "$f"; else cat "$f"; fi; echo; done`
+
+// parseLocal decodes the @@local.<name> sections. A file that is neither
+// JSON nor INI yields {"error": ...} so playbooks can tell it apart from a
+// missing file.
+func parseLocal(sec map[string][]string) map[string]any {
+    out := map[string]any{}
+    for name, lines := range sec {
+        n, ok := strings.CutPrefix(name, "local.")
+        if !ok { continue }
+        v, err := decodeLocal(strings.Join(lines, "\n"))
+        if err != nil { v = map[string]any{"error": fmt.Sprintf("%s/%s: %v", FactPath, n, err)} }
+        out[n] = v
+    }
+    return out
+}
+
+func decodeLocal(text string) (any, error) {
+    text = strings.TrimSpace(text)
+    if text == "" { return map[string]any{}, nil }
+    if json.Valid([]byte(text)) {
+        // YAML keeps whole numbers as ints
+        var v any
+        if err := yaml.Unmarshal([]byte(text), &v); err != nil { return nil, err }
+        return v, nil
+    }
+    // an INI file may start with [section], JSON objects never do
+    if text[0] == '{' { return nil, fmt.Errorf("invalid JSON") }
+    return decodeLocalINI(text)
+}
+
+// decodeLocalINI reads [section] headers and key=value lines into
+// section -> key -> value; values stay strings.
+func decodeLocalINI(text string) (map[string]any, error) {
+    out := map[string]any{}
+    var cur map[string]any
+    sc := bufio.NewScanner(strings.NewReader(text))
+    for n := 1; sc.Scan(); n++ {
+        l := strings.TrimSpace(sc.Text())
+        if l == "" || l[0] == '#' || l[0] == ';' { continue }
+        if strings.HasPrefix(l, "[") && strings.HasSuffix(l, "]") {
+            cur = map[string]any{}
+            out[strings.TrimSpace(l[1:len(l)-1])] = cur
+            continue
+        }
+        k, v, ok := strings.Cut(l, "=")
+        if !ok || cur == nil { return nil, fmt.Errorf("line %d: expected [section] or key=value, got %q", n, l) }
+        cur[strings.TrimSpace(k)] = strings.Trim(strings.TrimSpace(v), `"'`)
+    }
+    return out, sc.Err()
+}
@@ -0,0 +1,257 @@
+package inventory
+
+import (
+    "bufio"
+    "fmt"
+    "io"
+    "strconv"
+    "strings"
+
+    "gopkg.in/yaml.v3"
+)
+
+// ansibleAliases maps Ansible connection vars to their Gopsi names so
+// legacy INI and dynamic inventories work unchanged.
+var ansibleAliases = map[string]string{
+    "ansible_host":                 "host",
+    "ansible_port":                 "port",
+    "ansible_user":                 "user",
+    "ansible_connection":           "connection",
+    "ansible_password":             "ssh_password",
+    "ansible_ssh_pass":             "ssh_password",
+    "ansible_ssh_private_key_file": "ssh_private_key_file",
+    "ansible_become_method":        "become_method",
+    "ansible_become_user":          "become_user",
+    "ansible_become_password":      "become_password",
+    "ansible_become_pass":          "become_password",
+}
+
+// addINI adds an Ansible-style INI inventory:
+//
+//  web1 host=10.0.0.1           # ungrouped host
+//  [web]
+//  web[01:20].example.com user=deploy
+//  db1:2222
+//  [web:vars]
+//  ntp=pool.ntp.org
+//  [prod:children]
+//  web
+//
+// Groups without a parent become children of `all`.
+func (i *Inventory) addINI(file string, r io.Reader) error {
+    group, kind := "ungrouped", "hosts"
+    declared := []string{}
+    sc := bufio.NewScanner(r)
+    n := 0
+    for sc.Scan() {
+        n++
+        line := strings.TrimSpace(sc.Text())
+        if line == "" || line[0] == '#' || line[0] == ';' {
+            continue
+        }
+        if line[0] == '[' {
+            if !strings.HasSuffix(line, "]") {
+                return fmt.Errorf("%s:%d: bad section %q", file, n, line)
+            }
+            group, kind = line[1:len(line)-1], "hosts"
+            if k := strings.LastIndex(group, ":"); k >= 0 {
+                group, kind = group[:k], group[k+1:]
+            }
+            if kind != "hosts" && kind != "vars" && kind != "children" {
+                return fmt.Errorf("%s:%d: unknown section type %q", file, n, kind)
+            }
+            if group == "" {
+                return fmt.Errorf("%s:%d: empty group name", file, n)
+            }
+            i.group(group)
+            declared = append(declared, group)
+            continue
+        }
+        switch kind {
+        case "vars":
+            k, v, ok := strings.Cut(line, "=")
+            if !ok {
+                return fmt.Errorf("%s:%d: expected key=value, got %q", file, n, line)
+            }
+            i.group(group).setVar(ansibleVar(strings.TrimSpace(k)), iniValue(strings.TrimSpace(v)), file)
+        case "children":
+            i.addChild(group, line)
+            declared = append(declared, line)
+        default:
+            fields, err := splitINIFields(line)
+            if err != nil {
+                return fmt.Errorf("%s:%d: %w", file, n, err)
+            }
+            vars := map[string]any{}
+            for _, f := range fields[1:] {
+                k, v, ok := strings.Cut(f, "=")
+                if !ok {
+                    return fmt.Errorf("%s:%d: expected key=value, got %q", file, n, f)
+                }
+                vars[ansibleVar(k)] = iniValue(v)
+            }
+            name := fields[0]
+            // host:port, ignoring range colons and bare IPv6 addresses
+            rb := strings.LastIndex(name, "]") + 1
+            if c := strings.LastIndex(name[rb:], ":"); c >= 0 && rb+c > 0 && !strings.Contains(name[rb:rb+c], ":") {
+                k := rb + c
+                port, err := strconv.Atoi(name[k+1:])
+                if err != nil {
+                    return fmt.Errorf("%s:%d: bad port in %q", file, n, name)
+                }
+                if _, ok := vars["port"]; !ok {
+                    vars["port"] = port
+                }
+                name = name[:k]
+            }
+            names, err := expandHostRange(name)
+            if err != nil {
+                return fmt.Errorf("%s:%d: %w", file, n, err)
+            }
+            if group == "ungrouped" {
+                declared = append(declared, group)
+            }
+            for _, h := range names {
+                i.addHost(file, group, h, vars)
+            }
+        }
+    }
+    if err := sc.Err(); err != nil {
+        return err
+    }
+    i.adoptOrphans(declared)
+    return nil
+}
+
+func ansibleVar(k string) string {
+    if a, ok := ansibleAliases[k]; ok {
+        return a
+    }
+    return k
+}
+
+// adoptOrphans makes the groups that have no parent children of `all`.
+func (i *Inventory) adoptOrphans(groups []string) {
+    parent := map[string]bool{}
+    for _, g := range i.groups {
+        for _, c := range g.Children {
+            parent[c] = true
+        }
+    }
+    for _, g := range groups {
+        if g != "all" && !parent[g] {
+            i.addChild("all", g)
+        }
+    }
+}
+
+// iniValue decodes a value as a YAML scalar so numbers and booleans keep
+// their type and quotes are removed.
+func iniValue(s string) any {
+    var v any
+    if err := yaml.Unmarshal([]byte(s), &v); err != nil || v == nil {
+        return strings.Trim(s, `"'`)
+    }
+    return v
+}
+
+// splitINIFields splits on whitespace, keeping quoted runs together
+// (key="a b") and stopping at a # comment.
+func splitINIFields(line string) ([]string, error) {
+    var fields []string
+    var cur strings.Builder
+    var quote rune
+    inField := false
+    for _, r := range line {
+        switch {
+        case quote != 0:
+            cur.WriteRune(r)
+            if r == quote {
+                quote = 0
+            }
+        case r == '"' || r == '\'':
+            quote = r
+            inField = true
+            cur.WriteRune(r)
+        case r == '#' && !inField:
+            return fields, nil
+        case r == ' ' || r == '\t':
+            if inField {
+                fields = append(fields, cur.String())
+                cur.Reset()
+                inField = false
+            }
+        default:
+            inField = true
+            cur.WriteRune(r)
+        }
+    }
+    if quote != 0 {
+        return nil, fmt.Errorf("unterminated quote in %q", line)
+    }
+    if inField {
+        fields = append(fields, cur.String())
+    }
+    return fields, nil
+}
+
+// expandHostRange expands [start:end] and [start:end:step] ranges, numeric
+// (zero padding is kept) or single letters; several ranges multiply.
+func expandHostRange(name string) ([]string, error) {
+    open := strings.Index(name, "[")
+    if open < 0 {
+        return []string{name}, nil
+    }
+    end := strings.Index(name[open:], "]")
+    if end < 0 {
+        return nil, fmt.Errorf("unterminated range in %q", name)
+    }
+    end += open
+    parts := strings.Split(name[open+1:end], ":")
+    if len(parts) < 2 || len(parts) > 3 {
+        return nil, fmt.Errorf("bad range in %q", name)
+    }
+    step := 1
+    if len(parts) == 3 {
+        var err error
+        if step, err = strconv.Atoi(parts[2]); err != nil || step < 1 {
+            return nil, fmt.Errorf("bad range step in %q", name)
+        }
+    }
+    var items []string
+    lo, errLo := strconv.Atoi(parts[0])
+    hi, errHi := strconv.Atoi(parts[1])
+    switch {
+    case errLo == nil && errHi == nil:
+        width := 0
+        if len(parts[0]) > 1 && parts[0][0] == '0' {
+            width = len(parts[0])
+        }
+        for n := lo; n <= hi; n += step {
+            items = append(items, fmt.Sprintf("%0*d", width, n))
+        }
+    case len(parts[0]) == 1 && len(parts[1]) == 1:
+        for c := parts[0][0]; c <= parts[1][0]; c += byte(step) {
+            items = append(items, string(c))
+            if int(c)+step > 255 {
+                break
+            }
+        }
+    default:
+        return nil, fmt.Errorf("bad range in %q", name)
+    }
+    if len(items) == 0 {
+        return nil, fmt.Errorf("empty range in %q", name)
+    }
+    rest, err := expandHostRange(name[end+1:])
+    if err != nil {
+        return nil, err
+    }
+    var out []string
+    for _, it := range items {
+        for _, r := range rest {
+            out = append(out, name[:open]+it+r)
+        }
+    }
+    return out, nil
+}
@@ -0,0 +1,65 @@
+package inventory
+
+import (
+    "os"
+    "path/filepath"
+    "reflect"
+    "testing"
+)
+
+func TestINIAndMultipleSources(t *testing.T) {
+    dir := t.TempDir()
+    write := func(name, data string) string {
+        p := filepath.Join(dir, name)
+        if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil { t.Fatal(err) }
+        if err := os.WriteFile(p, []byte(data), 0644); err != nil { t.Fatal(err) }
+        return p
+    }
+    write("legacy/hosts", `
+# old fleet
+bastion ansible_host=192.0.2.1
+
+[web]
+web[01:03].example.com user=deploy
+db[a:b]:2222 note="two words"
+
+[web:vars]
+ntp=pool.ntp.org
+workers=4
+
+[prod:children]
+web
+`)
+    write("legacy/README.md", "not an inventory")
+    yml := write("extra.yml", `
+all:
+  children:
+    web:
+      vars: { ntp: time.example.com }
+      hosts:
+        web04.example.com: {}
+`)
+    inv, err := Load(filepath.Join(dir, "legacy"), yml)
+    if err != nil { t.Fatal(err) }
+    hs, err := inv.Hosts("")
+    if err != nil { t.Fatal(err) }
+    var names []string
+    byName := map[string]Host{}
+    for _, h := range hs {
+        names = append(names, h.Name)
+        byName[h.Name] = h
+    }
+    want := []string{"bastion", "web01.example.com", "web02.example.com", "web03.example.com", "dba", "dbb", "web04.example.com"}
+    if !reflect.DeepEqual(names, want) { t.Fatalf("hosts: %v", names) }
+    if byName["bastion"].Addr != "192.0.2.1" || !reflect.DeepEqual(byName["bastion"].Groups, []string{"ungrouped"}) { t.Fatalf("bastion: %+v", byName["bastion"]) }
+    db := byName["dbb"]
+    if db.Vars["port"] != 2222 || db.Vars["note"] != "two words" || db.Vars["workers"] != 4 { t.Fatalf("db vars: %v", db.Vars) }
+    if db.Vars["ntp"] != "time.example.com" { t.Fatalf("later source should override group vars: %v", db.Vars) }
+    if !reflect.DeepEqual(db.Groups, []string{"prod", "web"}) { t.Fatalf("groups: %v", db.Groups) }
+    if !reflect.DeepEqual(inv.Dirs(), []string{filepath.Join(dir, "legacy"), dir}) { t.Fatalf("dirs: %v", inv.Dirs()) }
+
+    for _, bad := range []string{"web[1:", "web[x:10]", "web[3:1]"} {
+        if _, err := expandHostRange(bad); err == nil { t.Errorf("%q should fail", bad) }
+    }
+    if got, _ := expandHostRange("r[1:2]-n[0:4:2]"); !reflect.DeepEqual(got, []string{"r1-n0", "r1-n2", "r1-n4", "r2-n0", "r2-n2", "r2-n4"}) { t.Errorf("ranges: %v", got) }
+}
@@ -1,8 +1,12 @@
 package inventory
 
 import (
+    "bytes"
+    "fmt"
     "os"
     "path/filepath"
+    "sort"
+    "strings"
 
     "gopkg.in/yaml.v3"
 )
@@ -11,74 +15,397 @@ type Host struct {
     Name string
     Addr string
     Vars map[string]any
+    // Groups lists every group the host belongs to, directly or through a
+    // child group, sorted and without the implicit `all`.
+    Groups []string
 }
 
-type group struct {
-    Children map[string]group       `yaml:"children"`
+// Group is a node of the inventory hierarchy. A group listed in several
+// places (e.g. as a child of two parents) is one node.
+type Group struct {
+    Name     string
+    Vars     map[string]any
+    Hosts    []string // hosts listed directly under the group
+    Children []string
+    src      map[string]string // file each var was last set from
+}
+
+func (g *Group) setVar(k string, v any, src string) {
+    g.Vars[k] = v
+    g.src[k] = src
+}
+
+type yamlGroup struct {
+    Children map[string]yamlGroup       `yaml:"children"`
     Hosts    map[string]map[string]any `yaml:"hosts"`
     Vars     map[string]any         `yaml:"vars"`
 }
 
 type root struct {
-    All group `yaml:"all"`
+    All yamlGroup `yaml:"all"`
     SchemaVersion int `yaml:"schema_version"`
 }
 
 type Inventory struct {
-    file string
-    r    root
+    opts          Options
+    sources       []string
+    schemaVersion int
+    groups        map[string]*Group
+    hostVars      map[string]map[string]any // vars set on the host entries themselves
+    hostSrc       map[string]map[string]string
+    order         []string                  // host names in first-seen order
+}
+
+func newInventory(opts Options) *Inventory {
+    i := &Inventory{opts: opts, groups: map[string]*Group{}, hostVars: map[string]map[string]any{}, hostSrc: map[string]map[string]string{}}
+    i.group("all")
+    return i
 }
 
 func LoadFromFile(path string) (*Inventory, error) {
+    return Load(path)
+}
+
+// Load reads and merges inventory sources in order. A source is a YAML or
+// INI file, an executable inventory script, or a directory whose files
+// are each loaded in name order. Later sources add hosts and groups and
+// override vars of earlier ones.
+func Load(paths ...string) (*Inventory, error) {
+    return LoadWith(Options{}, paths...)
+}
+
+// LoadWith is Load with options for inventory scripts.
+func LoadWith(opts Options, paths ...string) (*Inventory, error) {
+    i := newInventory(opts)
+    for _, p := range paths {
+        if err := i.addSource(p); err != nil {
+            return nil, err
+        }
+    }
+    if i.schemaVersion == 0 { i.schemaVersion = 1 }
+    return i, nil
+}
+
+func (i *Inventory) addSource(path string) error {
+    st, err := os.Stat(path)
+    if err != nil {
+        return err
+    }
+    i.sources = append(i.sources, path)
+    if !st.IsDir() {
+        return i.addFile(path, st.Mode())
+    }
+    entries, err := os.ReadDir(path)
+    if err != nil {
+        return err
+    }
+    for _, e := range entries {
+        if e.IsDir() || skipInventoryFile(e.Name()) {
+            continue
+        }
+        info, err := e.Info()
+        if err != nil {
+            return err
+        }
+        if err := i.addFile(filepath.Join(path, e.Name()), info.Mode()); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// skipInventoryFile ignores hidden, backup and non-inventory files found in
+// an inventory directory.
+func skipInventoryFile(name string) bool {
+    if strings.HasPrefix(name, ".") || strings.HasSuffix(name, "~") {
+        return true
+    }
+    switch strings.ToLower(filepath.Ext(name)) {
+    case ".bak", ".orig", ".retry", ".md", ".txt", ".cfg":
+        return true
+    }
+    return false
+}
+
+// addFile loads YAML for .yml/.yaml, Gopsi or Ansible JSON for .json and
+// INI for .ini, and runs other executables as inventory scripts;
+// remaining files are YAML when they have an `all` root and INI otherwise.
+func (i *Inventory) addFile(path string, mode os.FileMode) error {
+    if isScript(path, mode) {
+        return i.addScript(path)
+    }
     b, err := os.ReadFile(path)
     if err != nil {
-        return nil, err
+        return err
+    }
+    switch strings.ToLower(filepath.Ext(path)) {
+    case ".yml", ".yaml":
+        return i.addYAMLFile(path, b)
+    case ".json":
+        // saved script output in either schema
+        if isNative(b) {
+            return i.addYAMLFile(path, b)
+        }
+        return i.addAnsible(path, b)
+    case ".ini":
+        return i.addINI(path, bytes.NewReader(b))
+    }
+    var probe map[string]any
+    if yaml.Unmarshal(b, &probe) == nil {
+        if _, ok := probe["all"]; ok {
+            return i.addYAMLFile(path, b)
+        }
     }
+    return i.addINI(path, bytes.NewReader(b))
+}
+
+func (i *Inventory) addYAMLFile(path string, b []byte) error {
     var r root
     if err := yaml.Unmarshal(b, &r); err != nil {
-        return nil, err
+        return fmt.Errorf("%s: %w", path, err)
+    }
+    if i.schemaVersion == 0 {
+        i.schemaVersion = r.SchemaVersion
     }
-    if r.SchemaVersion == 0 { r.SchemaVersion = 1 }
-    return &Inventory{file: path, r: r}, nil
+    i.addYAML(path, "all", r.All)
+    return nil
 }
 
+// addYAML adds a YAML group subtree, walking keys in sorted order so host
+// order and var merging are deterministic.
+func (i *Inventory) addYAML(src, name string, g yamlGroup) {
+    grp := i.group(name)
+    for k, v := range g.Vars {
+        grp.setVar(k, v, src)
+    }
+    for _, h := range sortedKeys(g.Hosts) {
+        i.addHost(src, name, h, g.Hosts[h])
+    }
+    for _, cn := range sortedKeys(g.Children) {
+        i.addChild(name, cn)
+        i.addYAML(src, cn, g.Children[cn])
+    }
+}
+
+// group returns the named group, creating it if needed.
+func (i *Inventory) group(name string) *Group {
+    g, ok := i.groups[name]
+    if !ok {
+        g = &Group{Name: name, Vars: map[string]any{}, src: map[string]string{}}
+        i.groups[name] = g
+    }
+    return g
+}
+
+// addHost lists host under group; vars given on repeated listings merge,
+// later ones winning. src names the file the listing came from.
+func (i *Inventory) addHost(src, group, host string, vars map[string]any) {
+    g := i.group(group)
+    if !containsString(g.Hosts, host) {
+        g.Hosts = append(g.Hosts, host)
+    }
+    if _, ok := i.hostVars[host]; !ok {
+        i.hostVars[host] = map[string]any{}
+        i.hostSrc[host] = map[string]string{}
+        i.order = append(i.order, host)
+    }
+    for k, v := range vars {
+        i.setHostVar(host, k, v, src)
+    }
+}
+
+func (i *Inventory) setHostVar(host, k string, v any, src string) {
+    i.hostVars[host][k] = v
+    i.hostSrc[host][k] = src
+}
+
+func (i *Inventory) addChild(parent, child string) {
+    p := i.group(parent)
+    i.group(child)
+    if !containsString(p.Children, child) {
+        p.Children = append(p.Children, child)
+    }
+}
+
+// AllHosts returns the hosts matching limit, a host pattern ("" means all).
+// An invalid pattern matches nothing; use Hosts to get the error.
 func (i *Inventory) AllHosts(limit string) []Host {
-    var out []Host
-    var walk func(name string, g group)
-    walk = func(name string, g group) {
-        for h, vs := range g.Hosts {
-            host := Host{Name: h, Vars: map[string]any{}}
-            if v, ok := vs["host"]; ok {
-                host.Addr, _ = v.(string)
+    hs, _ := i.Hosts(limit)
+    return hs
+}
+
+// Hosts returns the hosts matching pattern ("" means all), see Select.
+// Each host appears once, however many groups list it.
+func (i *Inventory) Hosts(pattern string) ([]Host, error) {
+    all := i.hosts()
+    if pattern == "" {
+        return all, nil
+    }
+    return Select(all, pattern, i.Groups())
+}
+
+func (i *Inventory) hosts() []Host {
+    member := i.memberships()
+    depth := i.depths()
+    out := make([]Host, 0, len(i.order))
+    for _, name := range i.order {
+        h := Host{Name: name, Vars: i.mergeVars(name, member[name], depth, nil)}
+        h.Addr, _ = h.Vars["host"].(string)
+        for _, g := range member[name] {
+            if g != "all" {
+                h.Groups = append(h.Groups, g)
             }
-            for k, v := range g.Vars {
-                host.Vars[k] = v
+        }
+        sort.Strings(h.Groups)
+        out = append(out, h)
+    }
+    return out
+}
+
+// mergeVars applies vars from least to most specific: `all`, then groups
+// by increasing depth (ties by name), then the host's own vars. A child
+// group is always deeper than its parents, so it overrides them. When src
+// is non-nil it receives where each winning value was set.
+func (i *Inventory) mergeVars(host string, groups []string, depth map[string]int, src map[string]string) map[string]any {
+    gs := append([]string(nil), groups...)
+    sort.Slice(gs, func(a, b int) bool {
+        if depth[gs[a]] != depth[gs[b]] {
+            return depth[gs[a]] < depth[gs[b]]
+        }
+        return gs[a] < gs[b]
+    })
+    out := map[string]any{}
+    for _, g := range gs {
+        grp := i.groups[g]
+        for k, v := range grp.Vars {
+            out[k] = v
+            if src != nil {
+                src[k] = fmt.Sprintf("%s (group %s)", grp.src[k], g)
             }
-            for k, v := range vs {
-                host.Vars[k] = v
+        }
+    }
+    for k, v := range i.hostVars[host] {
+        out[k] = v
+        if src != nil {
+            src[k] = fmt.Sprintf("%s (host)", i.hostSrc[host][k])
+        }
+    }
+    return out
+}
+
+// memberships maps each host to every group containing it, including
+// ancestors of its direct groups and `all`.
+func (i *Inventory) memberships() map[string][]string {
+    out := map[string][]string{}
+    for g, hosts := range i.Groups() {
+        for _, h := range hosts {
+            out[h] = append(out[h], g)
+        }
+    }
+    return out
+}
+
+// depths gives each group its longest distance from `all`.
+func (i *Inventory) depths() map[string]int {
+    depth := map[string]int{}
+    var visit func(name string, d int, seen map[string]bool)
+    visit = func(name string, d int, seen map[string]bool) {
+        if seen[name] {
+            return // cycle
+        }
+        if cur, ok := depth[name]; ok && cur >= d {
+            return
+        }
+        depth[name] = d
+        seen[name] = true
+        for _, c := range i.groups[name].Children {
+            visit(c, d+1, seen)
+        }
+        delete(seen, name)
+    }
+    visit("all", 0, map[string]bool{})
+    // groups unreachable from all sit just below it
+    for name := range i.groups {
+        if _, ok := depth[name]; !ok {
+            visit(name, 1, map[string]bool{})
+        }
+    }
+    return depth
+}
+
+// Groups maps every group name to the names of the hosts below it,
+// including hosts of its child groups, without duplicates. `all` holds
+// every host.
+func (i *Inventory) Groups() map[string][]string {
+    out := map[string][]string{}
+    for name := range i.groups {
+        seen := map[string]bool{}
+        var members []string
+        var walk func(g string, path map[string]bool)
+        walk = func(g string, path map[string]bool) {
+            if path[g] {
+                return
+            }
+            path[g] = true
+            for _, h := range i.groups[g].Hosts {
+                if !seen[h] {
+                    seen[h] = true
+                    members = append(members, h)
+                }
             }
-            if limit == "" || matchLimit(limit, name, h) {
-                out = append(out, host)
+            for _, c := range i.groups[g].Children {
+                walk(c, path)
             }
+            delete(path, g)
         }
-        for cn, cg := range g.Children {
-            walk(cn, cg)
+        walk(name, map[string]bool{})
+        out[name] = members
+    }
+    out["all"] = append([]string(nil), i.order...)
+    return out
+}
+
+// BaseDir is the directory of the first inventory source.
+func (i *Inventory) BaseDir() string {
+    if len(i.sources) == 0 {
+        return "."
+    }
+    return sourceDir(i.sources[0])
+}
+
+// Dirs lists the directory of each inventory source without duplicates, a
+// directory source being its own; group_vars and host_vars are read there.
+func (i *Inventory) Dirs() []string {
+    var out []string
+    for _, s := range i.sources {
+        if d := sourceDir(s); !containsString(out, d) {
+            out = append(out, d)
         }
     }
-    walk("all", i.r.All)
     return out
 }
 
-func matchLimit(limit, groupName, hostName string) bool {
-    if limit == "" {
-        return true
+func sourceDir(path string) string {
+    if st, err := os.Stat(path); err == nil && st.IsDir() {
+        return filepath.Clean(path)
     }
-    if limit == groupName || limit == hostName {
-        return true
+    return filepath.Dir(path)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+    keys := make([]string, 0, len(m))
+    for k := range m {
+        keys = append(keys, k)
     }
-    return false
+    sort.Strings(keys)
+    return keys
 }
 
-func (i *Inventory) BaseDir() string {
-    return filepath.Dir(i.file)
+func containsString(list []string, s string) bool {
+    for _, e := range list {
+        if e == s {
+            return true
+        }
+    }
+    return false
 }
@@ -0,0 +1,91 @@
+package inventory
+
+import (
+    "os"
+    "path/filepath"
+    "reflect"
+    "testing"
+
+    "gopsi/pkg/vault"
+)
+
+func TestGroupsAndVarPrecedence(t *testing.T) {
+    src := `
+all:
+  vars: { level: all, ntp: pool.ntp.org, region: global }
+  children:
+    prod:
+      vars: { level: prod, region: eu }
+      children:
+        web:
+          vars: { level: web }
+          hosts:
+            web1: { host: 10.0.0.1 }
+            web2: { level: host }
+    monitored:
+      hosts:
+        web1: { port: 2222 }
+`
+    p := filepath.Join(t.TempDir(), "inv.yml")
+    if err := os.WriteFile(p, []byte(src), 0644); err != nil { t.Fatal(err) }
+    inv, err := LoadFromFile(p)
+    if err != nil { t.Fatal(err) }
+    hs, err := inv.Hosts("")
+    if err != nil { t.Fatal(err) }
+    if len(hs) != 2 { t.Fatalf("web1 listed twice should appear once, got %d hosts", len(hs)) }
+    byName := map[string]Host{}
+    for _, h := range hs { byName[h.Name] = h }
+
+    w1 := byName["web1"]
+    if !reflect.DeepEqual(w1.Groups, []string{"monitored", "prod", "web"}) { t.Fatalf("groups: %v", w1.Groups) }
+    if w1.Addr != "10.0.0.1" || w1.Vars["port"] != 2222 { t.Fatalf("host vars from both listings should merge: %+v", w1) }
+    if w1.Vars["level"] != "web" || w1.Vars["region"] != "eu" || w1.Vars["ntp"] != "pool.ntp.org" { t.Fatalf("all < parent < child: %v", w1.Vars) }
+    if byName["web2"].Vars["level"] != "host" { t.Fatalf("host vars win: %v", byName["web2"].Vars) }
+
+    g := inv.Groups()
+    if !reflect.DeepEqual(g["prod"], []string{"web1", "web2"}) || !reflect.DeepEqual(g["all"], []string{"web1", "web2"}) { t.Fatalf("groups: %v", g) }
+}
+
+func TestLoadVarsDir(t *testing.T) {
+    dir := t.TempDir()
+    write := func(rel, data string) {
+        p := filepath.Join(dir, rel)
+        if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil { t.Fatal(err) }
+        if err := os.WriteFile(p, []byte(data), 0644); err != nil { t.Fatal(err) }
+    }
+    write("inv.yml", `
+all:
+  vars: { ntp: inline }
+  children:
+    web:
+      vars: { level: inline }
+      hosts:
+        web1: { port: 22 }
+`)
+    write("group_vars/all.yml", "ntp: time.example.com\n")
+    write("group_vars/web.yml", "level: file\nsize: small\n")
+    write("group_vars/web/zz.yaml", "size: large\n")
+    write("group_vars/unknown.yml", "x: 1\n")
+    write("host_vars/web1.json", `{"port": 2222}`)
+    secret, err := vault.Encrypt([]byte("db_password: hunter2\n"), []byte("pw"))
+    if err != nil { t.Fatal(err) }
+    write("host_vars/web1/secrets.yml", string(secret))
+
+    inv, err := LoadFromFile(filepath.Join(dir, "inv.yml"))
+    if err != nil { t.Fatal(err) }
+    if err := inv.LoadVarsDir(dir, nil); err == nil { t.Fatal("encrypted file without a password should fail") }
+    if err := inv.LoadVarsDir(dir, []byte("pw")); err != nil { t.Fatal(err) }
+    hs, err := inv.Hosts("")
+    if err != nil { t.Fatal(err) }
+    v := hs[0].Vars
+    if v["ntp"] != "time.example.com" || v["level"] != "file" || v["size"] != "large" { t.Fatalf("group_vars: %v", v) }
+    if v["port"] != 2222 || v["db_password"] != "hunter2" { t.Fatalf("host_vars: %v", v) }
+
+    // a playbook directory loaded afterwards wins
+    play := t.TempDir()
+    if err := os.MkdirAll(filepath.Join(play, "group_vars"), 0755); err != nil { t.Fatal(err) }
+    if err := os.WriteFile(filepath.Join(play, "group_vars", "web"), []byte("level: play\n"), 0644); err != nil { t.Fatal(err) }
+    if err := inv.LoadVarsDir(play, nil); err != nil { t.Fatal(err) }
+    hs, _ = inv.Hosts("")
+    if hs[0].Vars["level"] != "play" { t.Fatalf("playbook group_vars should win: %v", hs[0].Vars) }
+}
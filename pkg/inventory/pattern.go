@@ -0,0 +1,200 @@
+package inventory
+
+import (
+    "bufio"
+    "fmt"
+    "net"
+    "os"
+    "path"
+    "regexp"
+    "strings"
+)
+
+// Host patterns select hosts for play `hosts:` and --limit:
+//
+//   all, *            every host
+//   web               a group (all hosts below it) or a host name
+//   web*, db-??       globs over group and host names
+//   ~db[0-9]+         regular expressions matching whole group or host names
+//   web:db, web,db    union
+//   web:&prod         intersection
+//   all:!canary       exclusion
+//   @retry-file       host names listed one per line in a file
+//
+// As in Ansible, unions are applied first, then intersections, then
+// exclusions, whatever their position; results keep inventory order.
+
+// Select returns the hosts matching pattern, using groups (group name to
+// member host names) to resolve group names.
+func Select(hosts []Host, pattern string, groups map[string][]string) ([]Host, error) {
+    terms, err := splitPattern(pattern)
+    if err != nil {
+        return nil, err
+    }
+    var union, inter, excl []map[string]bool
+    for _, t := range terms {
+        var list *[]map[string]bool
+        switch {
+        case strings.HasPrefix(t, "!"):
+            list, t = &excl, t[1:]
+        case strings.HasPrefix(t, "&"):
+            list, t = &inter, t[1:]
+        default:
+            list = &union
+        }
+        set, err := matchTerm(t, hosts, groups)
+        if err != nil {
+            return nil, err
+        }
+        *list = append(*list, set)
+    }
+    var out []Host
+    for _, h := range hosts {
+        ok := false
+        for _, s := range union {
+            if s[h.Name] { ok = true; break }
+        }
+        for _, s := range inter {
+            ok = ok && s[h.Name]
+        }
+        for _, s := range excl {
+            ok = ok && !s[h.Name]
+        }
+        if ok {
+            out = append(out, h)
+        }
+    }
+    return out, nil
+}
+
+// splitPattern splits on commas, or on colons when there are no commas.
+// A ~regex term runs to the next comma or colon outside (), [] and {}, so
+// `~web{1,2}` and `~db(:\d+)?` stay whole. A lone IPv6 address is one term;
+// combine addresses with commas. A pattern consisting only of exclusions or
+// intersections implies `all`.
+func splitPattern(pattern string) ([]string, error) {
+    pattern = strings.TrimSpace(pattern)
+    if pattern == "" {
+        return nil, fmt.Errorf("empty host pattern")
+    }
+    sep := byte(':')
+    if _, comma := scanPattern(pattern, ','); comma {
+        sep = ','
+    }
+    raw, _ := scanPattern(pattern, sep)
+    if sep == ':' && net.ParseIP(strings.TrimLeft(pattern, "!&")) != nil {
+        raw = []string{pattern}
+    }
+    var terms []string
+    positive := false
+    for _, t := range raw {
+        if t = strings.TrimSpace(t); t == "" {
+            continue
+        }
+        if !strings.HasPrefix(t, "!") && !strings.HasPrefix(t, "&") {
+            positive = true
+        }
+        terms = append(terms, t)
+    }
+    if !positive {
+        terms = append([]string{"all"}, terms...)
+    }
+    return terms, nil
+}
+
+// scanPattern cuts pattern at sep and reports whether it cut at a comma. A
+// ~regex term also ends at a comma or colon, but only outside brackets.
+func scanPattern(pattern string, sep byte) ([]string, bool) {
+    var terms []string
+    start, depth, regex, comma := 0, 0, false, false
+    for i := 0; i < len(pattern); i++ {
+        c := pattern[i]
+        if i == start {
+            regex = strings.HasPrefix(strings.TrimLeft(pattern[start:], " !&"), "~")
+        }
+        if regex {
+            switch c {
+            case '(', '[', '{':
+                depth++
+                continue
+            case ')', ']', '}':
+                if depth > 0 { depth-- }
+                continue
+            case '\\':
+                i++
+                continue
+            }
+            if depth > 0 || (c != ',' && c != ':') { continue }
+        } else if c != sep {
+            continue
+        }
+        terms = append(terms, pattern[start:i])
+        start, depth, comma = i+1, 0, comma || c == ','
+    }
+    return append(terms, pattern[start:]), comma
+}
+
+func matchTerm(t string, hosts []Host, groups map[string][]string) (map[string]bool, error) {
+    set := map[string]bool{}
+    addGroup := func(g string) {
+        for _, n := range groups[g] { set[n] = true }
+    }
+    switch {
+    case t == "all" || t == "*":
+        for _, h := range hosts { set[h.Name] = true }
+    case strings.HasPrefix(t, "@"):
+        names, err := readRetryFile(t[1:])
+        if err != nil {
+            return nil, err
+        }
+        for _, n := range names { set[n] = true }
+    case strings.HasPrefix(t, "~"):
+        // anchored, so ~web does not match db-web-proxy
+        re, err := regexp.Compile("^(?:" + t[1:] + ")$")
+        if err != nil {
+            return nil, fmt.Errorf("host pattern %q: %w", t, err)
+        }
+        for g := range groups {
+            if re.MatchString(g) { addGroup(g) }
+        }
+        for _, h := range hosts {
+            if re.MatchString(h.Name) { set[h.Name] = true }
+        }
+    case strings.ContainsAny(t, "*?["):
+        if _, err := path.Match(t, ""); err != nil {
+            return nil, fmt.Errorf("host pattern %q: %w", t, err)
+        }
+        for g := range groups {
+            if ok, _ := path.Match(t, g); ok { addGroup(g) }
+        }
+        for _, h := range hosts {
+            if ok, _ := path.Match(t, h.Name); ok { set[h.Name] = true }
+        }
+    default:
+        addGroup(t)
+        for _, h := range hosts {
+            if h.Name == t { set[h.Name] = true }
+        }
+    }
+    return set, nil
+}
+
+// readRetryFile reads host names, one per line; blank lines and # comments
+// are ignored.
+func readRetryFile(file string) ([]string, error) {
+    f, err := os.Open(file)
+    if err != nil {
+        return nil, fmt.Errorf("limit file: %w", err)
+    }
+    defer f.Close()
+    var out []string
+    sc := bufio.NewScanner(f)
+    for sc.Scan() {
+        line := strings.TrimSpace(sc.Text())
+        if line == "" || strings.HasPrefix(line, "#") {
+            continue
+        }
+        out = append(out, line)
+    }
+    return out, sc.Err()
+}
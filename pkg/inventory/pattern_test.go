@@ -0,0 +1,87 @@
+package inventory
+
+import (
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "testing"
+)
+
+const testInventory = `
+all:
+  hosts:
+    canary: {}
+  children:
+    web:
+      hosts:
+        web1: {}
+        web2: {}
+    db:
+      hosts:
+        db1: {}
+        db12: {}
+    prod:
+      children:
+        web: {}
+      hosts:
+        db1: {}
+`
+
+func TestHostPatterns(t *testing.T) {
+    dir := t.TempDir()
+    p := filepath.Join(dir, "inv.yml")
+    if err := os.WriteFile(p, []byte(testInventory), 0644); err != nil { t.Fatal(err) }
+    retry := filepath.Join(dir, "site.retry")
+    if err := os.WriteFile(retry, []byte("web2\n# comment\ndb1\n"), 0644); err != nil { t.Fatal(err) }
+    inv, err := LoadFromFile(p)
+    if err != nil { t.Fatal(err) }
+
+    cases := map[string]string{
+        "web":            "web1,web2",
+        "web*":           "web1,web2",
+        "~db[0-9]+":      "db1,db12",
+        "~eb":            "",
+        "~.*b1":          "db1,web1",
+        "~web{1,2}":      "web1,web2",
+        "~db(:\\d+)?":    "db1,db12",
+        "~db1{1,2}:web1": "db1,web1",
+        "~(web|db)1:!db1": "web1",
+        "~w[e:]b1,canary": "canary,web1",
+        "web:db":         "db1,db12,web1,web2",
+        "web,db1":        "db1,web1,web2",
+        "prod:&db":       "db1",
+        "all:!canary:!db": "web1,web2",
+        "!web":           "canary,db1,db12",
+        "@" + retry:      "db1,web2",
+        "nosuchgroup":    "",
+    }
+    for pat, want := range cases {
+        hs, err := inv.Hosts(pat)
+        if err != nil { t.Fatalf("%s: %v", pat, err) }
+        var got []string
+        for _, h := range hs { got = append(got, h.Name) }
+        sort.Strings(got)
+        if strings.Join(got, ",") != want { t.Errorf("%s: got %s want %s", pat, strings.Join(got, ","), want) }
+    }
+    if _, err := inv.Hosts("~db[0-"); err == nil { t.Error("expected regex error") }
+    if _, err := inv.Hosts("@" + filepath.Join(dir, "missing")); err == nil { t.Error("expected missing retry file error") }
+}
+
+func TestIPv6Patterns(t *testing.T) {
+    hosts := []Host{{Name: "web1"}, {Name: "2001:db8::5"}, {Name: "::1"}}
+    cases := map[string]string{
+        "2001:db8::5":      "2001:db8::5",
+        "!2001:db8::5":     "::1,web1",
+        "web1,2001:db8::5": "2001:db8::5,web1",
+        "::1,!web1":        "::1",
+    }
+    for pat, want := range cases {
+        hs, err := Select(hosts, pat, nil)
+        if err != nil { t.Fatalf("%s: %v", pat, err) }
+        var got []string
+        for _, h := range hs { got = append(got, h.Name) }
+        sort.Strings(got)
+        if strings.Join(got, ",") != want { t.Errorf("%s: got %s want %s", pat, strings.Join(got, ","), want) }
+    }
+}
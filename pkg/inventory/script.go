@@ -0,0 +1,224 @@
+package inventory
+
+import (
+    "bytes"
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "strings"
+    "time"
+
+    "gopkg.in/yaml.v3"
+)
+
+// Options tunes how inventory sources are loaded.
+type Options struct {
+    // CacheDir holds the output of inventory scripts; "" disables caching.
+    CacheDir string
+    // CacheTTL is how long cached script output is reused; 0 disables caching.
+    CacheTTL time.Duration
+    // ScriptTimeout bounds each script run; 0 means DefaultScriptTimeout.
+    ScriptTimeout time.Duration
+}
+
+// DefaultScriptTimeout bounds a dynamic inventory script run.
+const DefaultScriptTimeout = 2 * time.Minute
+
+// ansibleGroup is a group in Ansible's dynamic inventory JSON; a group may
+// also be given as a plain list of hosts.
+type ansibleGroup struct {
+    Hosts    []string       `yaml:"hosts"`
+    Vars     map[string]any `yaml:"vars"`
+    Children []string       `yaml:"children"`
+}
+
+// isScript reports whether path is an executable that is not a static
+// inventory file.
+func isScript(path string, mode os.FileMode) bool {
+    switch strings.ToLower(filepath.Ext(path)) {
+    case ".yml", ".yaml", ".json", ".ini":
+        return false
+    }
+    return mode.IsRegular() && mode&0111 != 0
+}
+
+// addScript runs a dynamic inventory executable, or reuses its cached
+// output, and adds the result. The script is run with --list and prints
+// either Ansible's JSON (groups plus _meta.hostvars; without _meta each
+// host is queried with --host <name>) or Gopsi's native schema, the YAML
+// inventory as JSON with an `all` root.
+func (i *Inventory) addScript(path string) error {
+    out, err := i.scriptOutput(path)
+    if err != nil {
+        return err
+    }
+    if isNative(out) {
+        return i.addYAMLFile(path, out)
+    }
+    return i.addAnsible(path, out)
+}
+
+// isNative reports whether script output uses Gopsi's schema.
+func isNative(out []byte) bool {
+    var r root
+    return yaml.Unmarshal(out, &r) == nil && (len(r.All.Hosts) > 0 || len(r.All.Children) > 0)
+}
+
+// scriptOutput returns the script's --list output with _meta.hostvars
+// filled in, from the cache when it is fresh.
+func (i *Inventory) scriptOutput(path string) ([]byte, error) {
+    cache := ""
+    if i.opts.CacheDir != "" && i.opts.CacheTTL > 0 {
+        abs, err := filepath.Abs(path)
+        if err != nil {
+            return nil, err
+        }
+        sum := sha256.Sum256([]byte(abs))
+        cache = filepath.Join(i.opts.CacheDir, hex.EncodeToString(sum[:8])+".json")
+        if st, err := os.Stat(cache); err == nil && time.Since(st.ModTime()) < i.opts.CacheTTL {
+            return os.ReadFile(cache)
+        }
+    }
+    out, err := i.runScript(path, "--list")
+    if err != nil {
+        return nil, err
+    }
+    if !isNative(out) {
+        if out, err = i.fillHostVars(path, out); err != nil {
+            return nil, err
+        }
+    }
+    if cache != "" {
+        if err := os.MkdirAll(filepath.Dir(cache), 0700); err != nil {
+            return nil, err
+        }
+        // the output may carry secrets, keep it private
+        if err := os.WriteFile(cache, out, 0600); err != nil {
+            return nil, err
+        }
+    }
+    return out, nil
+}
+
+// fillHostVars adds _meta.hostvars to Ansible output that lacks it by
+// running the script with --host for every host.
+func (i *Inventory) fillHostVars(path string, out []byte) ([]byte, error) {
+    var top map[string]json.RawMessage
+    if err := json.Unmarshal(out, &top); err != nil {
+        return nil, fmt.Errorf("%s: %w", path, err)
+    }
+    if _, ok := top["_meta"]; ok {
+        return out, nil
+    }
+    groups, err := decodeAnsible(path, out)
+    if err != nil {
+        return nil, err
+    }
+    hostvars := map[string]map[string]any{}
+    for _, name := range sortedKeys(groups) {
+        for _, h := range groups[name].Hosts {
+            if _, ok := hostvars[h]; ok {
+                continue
+            }
+            b, err := i.runScript(path, "--host", h)
+            if err != nil {
+                return nil, err
+            }
+            var vars map[string]any
+            if err := json.Unmarshal(b, &vars); err != nil {
+                return nil, fmt.Errorf("%s --host %s: %w", path, h, err)
+            }
+            hostvars[h] = vars
+        }
+    }
+    meta, err := json.Marshal(map[string]any{"hostvars": hostvars})
+    if err != nil {
+        return nil, err
+    }
+    top["_meta"] = meta
+    return json.Marshal(top)
+}
+
+func (i *Inventory) runScript(path string, args ...string) ([]byte, error) {
+    timeout := i.opts.ScriptTimeout
+    if timeout <= 0 {
+        timeout = DefaultScriptTimeout
+    }
+    ctx, cancel := context.WithTimeout(context.Background(), timeout)
+    defer cancel()
+    cmd := exec.CommandContext(ctx, path, args...)
+    var stderr bytes.Buffer
+    cmd.Stderr = &stderr
+    out, err := cmd.Output()
+    if err != nil {
+        if msg := strings.TrimSpace(stderr.String()); msg != "" {
+            err = fmt.Errorf("%w: %s", err, msg)
+        }
+        return nil, fmt.Errorf("inventory script %s %s: %w", path, strings.Join(args, " "), err)
+    }
+    return out, nil
+}
+
+// decodeAnsible parses Ansible's --list output into groups; _meta is left
+// out. It decodes JSON as YAML so integers stay ints, as in YAML
+// inventories.
+func decodeAnsible(path string, out []byte) (map[string]ansibleGroup, error) {
+    var top map[string]yaml.Node
+    if err := yaml.Unmarshal(out, &top); err != nil {
+        return nil, fmt.Errorf("%s: %w", path, err)
+    }
+    groups := map[string]ansibleGroup{}
+    for name, node := range top {
+        if name == "_meta" {
+            continue
+        }
+        var g ansibleGroup
+        if err := node.Decode(&g.Hosts); err != nil {
+            if err := node.Decode(&g); err != nil {
+                return nil, fmt.Errorf("%s: group %s: %w", path, name, err)
+            }
+        }
+        groups[name] = g
+    }
+    return groups, nil
+}
+
+func (i *Inventory) addAnsible(path string, out []byte) error {
+    groups, err := decodeAnsible(path, out)
+    if err != nil {
+        return err
+    }
+    var meta struct {
+        Meta struct {
+            HostVars map[string]map[string]any `yaml:"hostvars"`
+        } `yaml:"_meta"`
+    }
+    if err := yaml.Unmarshal(out, &meta); err != nil {
+        return fmt.Errorf("%s: _meta: %w", path, err)
+    }
+    names := sortedKeys(groups)
+    for _, name := range names {
+        g := groups[name]
+        grp := i.group(name)
+        for k, v := range g.Vars {
+            grp.setVar(ansibleVar(k), v, path)
+        }
+        for _, h := range g.Hosts {
+            vars := map[string]any{}
+            for k, v := range meta.Meta.HostVars[h] {
+                vars[ansibleVar(k)] = v
+            }
+            i.addHost(path, name, h, vars)
+        }
+        for _, c := range g.Children {
+            i.addChild(name, c)
+        }
+    }
+    i.adoptOrphans(names)
+    return nil
+}
@@ -0,0 +1,45 @@
+package inventory
+
+import (
+    "os"
+    "path/filepath"
+    "reflect"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestInventoryScript(t *testing.T) {
+    dir := t.TempDir()
+    calls := filepath.Join(dir, "calls")
+    script := filepath.Join(dir, "cmdb")
+    src := `#!/bin/sh
+echo "$@" >> ` + calls + `
+case "$1" in
+--list) echo '{"web": {"hosts": ["w1", "w2"], "vars": {"ansible_user": "deploy"}}, "prod": {"children": ["web"]}, "db": ["d1"]}' ;;
+--host) echo "{\"ansible_host\": \"10.0.0.$(echo $2 | tr -dc 0-9)\", \"ansible_port\": 2222}" ;;
+esac
+`
+    if err := os.WriteFile(script, []byte(src), 0755); err != nil { t.Fatal(err) }
+    opts := Options{CacheDir: filepath.Join(dir, "cache"), CacheTTL: time.Hour}
+    for run := 0; run < 2; run++ {
+        inv, err := LoadWith(opts, script)
+        if err != nil { t.Fatal(err) }
+        hs, err := inv.Hosts("prod")
+        if err != nil { t.Fatal(err) }
+        if len(hs) != 2 || hs[0].Addr != "10.0.0.1" || hs[0].Vars["port"] != 2222 || hs[0].Vars["user"] != "deploy" { t.Fatalf("hosts: %+v", hs) }
+        if g := inv.Groups(); !reflect.DeepEqual(g["db"], []string{"d1"}) { t.Fatalf("groups: %v", g) }
+    }
+    b, _ := os.ReadFile(calls)
+    if got := strings.Fields(strings.ReplaceAll(string(b), "\n", " ")); len(got) != 7 { t.Fatalf("second load should hit the cache, calls: %q", b) }
+
+    native := filepath.Join(dir, "native")
+    if err := os.WriteFile(native, []byte("#!/bin/sh\necho '{\"all\": {\"hosts\": {\"n1\": {\"host\": \"192.0.2.9\"}}}}'\n"), 0755); err != nil { t.Fatal(err) }
+    inv, err := Load(native)
+    if err != nil { t.Fatal(err) }
+    if hs, _ := inv.Hosts(""); len(hs) != 1 || hs[0].Addr != "192.0.2.9" { t.Fatalf("native: %+v", hs) }
+
+    failing := filepath.Join(dir, "failing")
+    if err := os.WriteFile(failing, []byte("#!/bin/sh\necho cmdb down >&2\nexit 1\n"), 0755); err != nil { t.Fatal(err) }
+    if _, err := Load(failing); err == nil || !strings.Contains(err.Error(), "cmdb down") { t.Fatalf("want script stderr in error, got %v", err) }
+}
@@ -0,0 +1,149 @@
+package inventory
+
+import (
+    "fmt"
+    "os"
+    "path/filepath"
+    "strings"
+    "unicode/utf8"
+
+    "gopkg.in/yaml.v3"
+
+    "gopsi/pkg/vault"
+)
+
+// LoadVarsDir merges dir/group_vars and dir/host_vars into the inventory.
+// Each group or host may have a file named after it (no extension, .yml,
+// .yaml or .json) and/or a directory of such files, read in name order
+// after the single file.
+// Values override the inventory's inline vars for the same group or host,
+// so the precedence between groups and hosts is unchanged; call it for the
+// inventory directory first, then the playbook directory. Files encrypted
+// with `gopsi vault` are decrypted with pass. Entries naming unknown
+// groups or hosts are ignored.
+func (i *Inventory) LoadVarsDir(dir string, pass []byte) error {
+    gv, err := readVarsTree(filepath.Join(dir, "group_vars"), pass)
+    if err != nil {
+        return err
+    }
+    for _, name := range sortedKeys(gv) {
+        g, ok := i.groups[name]
+        if !ok {
+            continue
+        }
+        for _, f := range gv[name] {
+            for k, v := range f.vars {
+                g.setVar(k, v, f.path)
+            }
+        }
+    }
+    hv, err := readVarsTree(filepath.Join(dir, "host_vars"), pass)
+    if err != nil {
+        return err
+    }
+    for _, name := range sortedKeys(hv) {
+        if _, ok := i.hostVars[name]; !ok {
+            continue
+        }
+        for _, f := range hv[name] {
+            for k, v := range f.vars {
+                i.setHostVar(name, k, v, f.path)
+            }
+        }
+    }
+    return nil
+}
+
+type varsFile struct {
+    path string
+    vars map[string]any
+}
+
+// readVarsTree reads a group_vars or host_vars directory into name -> files
+// in merge order. A missing directory yields nothing.
+func readVarsTree(dir string, pass []byte) (map[string][]varsFile, error) {
+    entries, err := os.ReadDir(dir)
+    if os.IsNotExist(err) {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    out := map[string][]varsFile{}
+    // a file named after the group or host merges before its directory
+    for _, e := range entries {
+        if e.IsDir() || !isVarsFile(e.Name()) {
+            continue
+        }
+        p := filepath.Join(dir, e.Name())
+        vars, err := readVarsFile(p, pass)
+        if err != nil {
+            return nil, err
+        }
+        name := strings.TrimSuffix(e.Name(), filepath.Ext(e.Name()))
+        out[name] = append(out[name], varsFile{p, vars})
+    }
+    for _, e := range entries {
+        if !e.IsDir() {
+            continue
+        }
+        p := filepath.Join(dir, e.Name())
+        files, err := os.ReadDir(p)
+        if err != nil {
+            return nil, err
+        }
+        for _, f := range files {
+            if f.IsDir() || !isVarsFile(f.Name()) {
+                continue
+            }
+            fp := filepath.Join(p, f.Name())
+            vars, err := readVarsFile(fp, pass)
+            if err != nil {
+                return nil, err
+            }
+            out[e.Name()] = append(out[e.Name()], varsFile{fp, vars})
+        }
+    }
+    return out, nil
+}
+
+func isVarsFile(name string) bool {
+    if strings.HasPrefix(name, ".") {
+        return false
+    }
+    switch filepath.Ext(name) {
+    case "", ".yml", ".yaml", ".json":
+        return true
+    }
+    return false
+}
+
+// readVarsFile parses a YAML/JSON map, decrypting it first when it is a
+// binary `gopsi vault` file or a $GOPSI_VAULT; string.
+func readVarsFile(file string, pass []byte) (map[string]any, error) {
+    b, err := os.ReadFile(file)
+    if err != nil {
+        return nil, err
+    }
+    encrypted := vault.IsEncrypted(string(b)) || !utf8.Valid(b)
+    if encrypted {
+        if len(pass) == 0 {
+            return nil, fmt.Errorf("%s is vault-encrypted but no vault password was given", file)
+        }
+        if vault.IsEncrypted(string(b)) {
+            s, err := vault.DecryptString(string(b), pass)
+            if err != nil {
+                return nil, fmt.Errorf("%s: %w", file, err)
+            }
+            b = []byte(s)
+        } else if b, err = vault.Decrypt(b, pass); err != nil {
+            return nil, fmt.Errorf("%s: %w", file, err)
+        }
+    }
+    var vars map[string]any
+    if err := yaml.Unmarshal(b, &vars); err != nil {
+        return nil, fmt.Errorf("%s: %w", file, err)
+    }
+    return vars, nil
+}
+
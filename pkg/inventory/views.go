@@ -0,0 +1,107 @@
+package inventory
+
+import (
+    "encoding/json"
+    "fmt"
+    "sort"
+    "strings"
+)
+
+// Graph renders the group tree like `ansible-inventory --graph`: groups
+// are prefixed with @, child groups come before the group's own hosts.
+func (i *Inventory) Graph() string {
+    var b strings.Builder
+    prefix := func(depth int) string {
+        if depth == 0 {
+            return ""
+        }
+        return "  " + strings.Repeat("|  ", depth-1) + "|--"
+    }
+    var walk func(name string, depth int, path map[string]bool)
+    walk = func(name string, depth int, path map[string]bool) {
+        if path[name] {
+            fmt.Fprintf(&b, "%s@%s: (cycle)\n", prefix(depth), name)
+            return
+        }
+        fmt.Fprintf(&b, "%s@%s:\n", prefix(depth), name)
+        path[name] = true
+        g := i.groups[name]
+        children := append([]string(nil), g.Children...)
+        sort.Strings(children)
+        for _, c := range children {
+            walk(c, depth+1, path)
+        }
+        for _, h := range g.Hosts {
+            fmt.Fprintf(&b, "%s%s\n", prefix(depth+1), h)
+        }
+        delete(path, name)
+    }
+    walk("all", 0, map[string]bool{})
+    return b.String()
+}
+
+// HostVars returns the merged vars of host, as tasks see them, and for
+// each var where the winning value was set: "<file> (group <name>)" or
+// "<file> (host)".
+func (i *Inventory) HostVars(host string) (map[string]any, map[string]string, error) {
+    if _, ok := i.hostVars[host]; !ok {
+        return nil, nil, fmt.Errorf("host %q not found in inventory", host)
+    }
+    src := map[string]string{}
+    vars := i.mergeVars(host, i.memberships()[host], i.depths(), src)
+    return vars, src, nil
+}
+
+// Export renders the inventory as dynamic inventory JSON in Ansible's
+// schema: every group with its direct hosts, vars and children, and the
+// hosts' own vars under _meta.hostvars. Connection vars are written under
+// their ansible_* names. It can be fed back through -i as a .json file or
+// printed by an inventory script.
+func (i *Inventory) Export() ([]byte, error) {
+    out := map[string]any{}
+    for name, g := range i.groups {
+        e := map[string]any{}
+        if len(g.Hosts) > 0 {
+            e["hosts"] = g.Hosts
+        }
+        if len(g.Vars) > 0 {
+            e["vars"] = ansibleVars(g.Vars)
+        }
+        if len(g.Children) > 0 {
+            children := append([]string(nil), g.Children...)
+            sort.Strings(children)
+            e["children"] = children
+        }
+        out[name] = e
+    }
+    hostvars := map[string]any{}
+    for h, vars := range i.hostVars {
+        if len(vars) > 0 {
+            hostvars[h] = ansibleVars(vars)
+        }
+    }
+    out["_meta"] = map[string]any{"hostvars": hostvars}
+    return json.MarshalIndent(out, "", "  ")
+}
+
+// exportAliases inverts ansibleAliases; of two Ansible spellings the
+// current one wins over the deprecated *_pass.
+var exportAliases = func() map[string]string {
+    m := map[string]string{}
+    for a, g := range ansibleAliases {
+        if a == "ansible_ssh_pass" || a == "ansible_become_pass" { continue }
+        m[g] = a
+    }
+    return m
+}()
+
+// ansibleVars returns a copy of vars with Gopsi connection vars renamed to
+// their Ansible names, e.g. port -> ansible_port.
+func ansibleVars(vars map[string]any) map[string]any {
+    out := make(map[string]any, len(vars))
+    for k, v := range vars {
+        if a, ok := exportAliases[k]; ok { k = a }
+        out[k] = v
+    }
+    return out
+}
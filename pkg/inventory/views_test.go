@@ -0,0 +1,65 @@
+package inventory
+
+import (
+    "encoding/json"
+    "os"
+    "path/filepath"
+    "reflect"
+    "testing"
+)
+
+func TestGraphHostVarsAndExport(t *testing.T) {
+    dir := t.TempDir()
+    p := filepath.Join(dir, "inv.yml")
+    src := `
+all:
+  vars: { ntp: pool }
+  children:
+    prod:
+      children:
+        web:
+          vars: { level: web }
+          hosts:
+            web1: { port: 2222 }
+    db:
+      hosts:
+        db1: {}
+`
+    if err := os.WriteFile(p, []byte(src), 0644); err != nil { t.Fatal(err) }
+    if err := os.MkdirAll(filepath.Join(dir, "group_vars"), 0755); err != nil { t.Fatal(err) }
+    gv := filepath.Join(dir, "group_vars", "prod.yml")
+    if err := os.WriteFile(gv, []byte("level: prod\nregion: eu\n"), 0644); err != nil { t.Fatal(err) }
+    inv, err := Load(p)
+    if err != nil { t.Fatal(err) }
+    if err := inv.LoadVarsDir(dir, nil); err != nil { t.Fatal(err) }
+
+    want := "@all:\n  |--@db:\n  |  |--db1\n  |--@prod:\n  |  |--@web:\n  |  |  |--web1\n"
+    if g := inv.Graph(); g != want { t.Fatalf("graph:\n%s", g) }
+
+    vars, sources, err := inv.HostVars("web1")
+    if err != nil { t.Fatal(err) }
+    if vars["level"] != "web" || vars["region"] != "eu" || vars["port"] != 2222 { t.Fatalf("vars: %v", vars) }
+    wantSrc := map[string]string{
+        "ntp":    p + " (group all)",
+        "region": gv + " (group prod)",
+        "level":  p + " (group web)",
+        "port":   p + " (host)",
+    }
+    if !reflect.DeepEqual(sources, wantSrc) { t.Fatalf("sources: %v", sources) }
+    if _, _, err := inv.HostVars("nope"); err == nil { t.Fatal("unknown host should fail") }
+
+    out, err := inv.Export()
+    if err != nil { t.Fatal(err) }
+    var doc struct {
+        Meta struct{ Hostvars map[string]map[string]any } `json:"_meta"`
+    }
+    if err := json.Unmarshal(out, &doc); err != nil { t.Fatal(err) }
+    if hv := doc.Meta.Hostvars["web1"]; hv["ansible_port"] != float64(2222) || hv["port"] != nil { t.Fatalf("export hostvars: %v", hv) }
+    exp := filepath.Join(dir, "export.json")
+    if err := os.WriteFile(exp, out, 0644); err != nil { t.Fatal(err) }
+    back, err := Load(exp)
+    if err != nil { t.Fatal(err) }
+    if !reflect.DeepEqual(back.Groups(), inv.Groups()) { t.Fatalf("export round trip: %v != %v", back.Groups(), inv.Groups()) }
+    bv, _, _ := back.HostVars("web1")
+    if !reflect.DeepEqual(bv, vars) { t.Fatalf("export round trip vars: %v != %v", bv, vars) }
+}
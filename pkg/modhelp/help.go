@@ -2,6 +2,9 @@ package modhelp
 
 import "fmt"
 
+// Every module registers the same shape (changed, msg, rc, stdout, stderr,
+// data, artifacts, skipped, failed); the REGISTER sections list the keys each
+// module fills in beyond changed/msg.
 var docs = map[string]string{
     "command": `NAME
   command - run a command with optional guards
@@ -20,10 +23,16 @@ ARGS
   creates   string   path; skip apply if exists
   removes   string   path; skip apply if absent
   env       map      environment variables
-  become    bool     sudo
+  become    bool     run privileged via become_method/become_user; the task's become keyword wins
 
 ARTIFACTS
   stdout, stderr, exit, cmd, sudo
+
+REGISTER
+  rc        int      exit status
+  stdout    string   command output
+  stderr    string   command error output
+  msg       string   stdout and stderr combined
 `,
     "shell": `NAME
   shell - run a shell command through bash
@@ -38,10 +47,16 @@ SYNOPSIS
 ARGS
   _         string   shell command
   env       map      environment
-  become    bool     sudo
+  become    bool     run privileged via become_method/become_user; the task's become keyword wins
 
 ARTIFACTS
   stdout, stderr, exit, cmd, sudo
+
+REGISTER
+  rc        int      exit status
+  stdout    string   command output
+  stderr    string   command error output
+  msg       string   stdout and stderr combined
 `,
     "file": `NAME
   file - ensure a file present or absent with content and permissions
@@ -58,9 +73,15 @@ ARGS
   state      string   present|absent
   content    string   required when state=present
   mode       string   optional octal permissions
+  become     bool     run privileged via become_method/become_user; the task's become keyword wins
 
 ARTIFACTS
   path, file_name, dest, before, after, mode
+
+REGISTER
+  artifacts.dest    string   full file path
+  artifacts.before  string   sha256 before the change
+  artifacts.after   string   sha256 of the desired content
 `,
     "copy": `NAME
   copy - copy local content to remote path
@@ -76,9 +97,15 @@ ARGS
   content   string   inline content
   dest      string   required
   mode      string   octal permissions
+  become    bool     run privileged via become_method/become_user; the task's become keyword wins
 
 ARTIFACTS
   dest, before, after
+
+REGISTER
+  artifacts.dest    string   destination path
+  artifacts.before  string   sha256 before the change
+  artifacts.after   string   sha256 of the new content
 `,
     "template": `NAME
   template - render template with vars and copy to remote
@@ -94,6 +121,11 @@ ARGS
 
 ARTIFACTS
   dest, before, after, mode
+
+REGISTER
+  data.before       string   sha256 of the remote file (check)
+  data.after        string   sha256 of the rendered template (check)
+  artifacts.dest    string   destination path
 `,
     "lineinfile": `NAME
   lineinfile - ensure a line in a text file
@@ -111,9 +143,16 @@ ARGS
   line      string   required
   regexp    string   optional
   state     string   present|absent
+  become    bool     run privileged via become_method/become_user; the task's become keyword wins
 
 ARTIFACTS
   path, stdout, stderr, exit
+
+REGISTER
+  rc        int      exit status of the edit
+  stdout    string   edit output
+  stderr    string   edit error output
+  artifacts.present bool   line present before the change (check)
 `,
     "get_url": `NAME
   get_url - download a URL to a file
@@ -126,10 +165,15 @@ ARGS
   url       string   required
   dest      string   required
   checksum  string   optional sha256
-  become    bool     sudo
+  become    bool     run privileged via become_method/become_user; the task's become keyword wins
 
 ARTIFACTS
   url, dest, exit, stderr
+
+REGISTER
+  rc        int      exit status of the download
+  stderr    string   download error output
+  artifacts.have    string   sha256 of the existing file (check)
 `,
     "unarchive": `NAME
   unarchive - extract an archive
@@ -141,10 +185,30 @@ SYNOPSIS
 ARGS
   src       string   required (.tar.gz|.tgz|.zip)
   dest      string   required
-  become    bool     sudo
+  become    bool     run privileged via become_method/become_user; the task's become keyword wins
 
 ARTIFACTS
   src, dest, exit, stderr
+
+REGISTER
+  rc        int      exit status of the extraction
+  stderr    string   extraction error output
+`,
+    "setup": `NAME
+  setup - gather facts again mid-play
+
+SYNOPSIS
+  - name: refresh network facts after reconfiguring
+    setup: { gather_subset: network }
+
+ARGS
+  gather_subset  string|list  all (default), min, hardware, network; "!x" excludes
+
+ARTIFACTS
+  gather_subset
+
+REGISTER
+  data.facts    map      the gathered facts; they are also merged into facts
 `,
     "git": `NAME
   git - clone or update a git repository
@@ -157,10 +221,15 @@ ARGS
   repo      string   required
   dest      string   required
   version   string   optional branch/tag
-  become    bool     sudo
+  become    bool     run privileged via become_method/become_user; the task's become keyword wins
 
 ARTIFACTS
   repo, dest, version, exit, stderr
+
+REGISTER
+  rc        int      exit status of clone/checkout
+  stderr    string   git error output
+  artifacts.cloned  bool     repository already present (check)
 `,
     "pip": `NAME
   pip - manage Python packages
@@ -175,10 +244,15 @@ ARGS
   name      string   required
   state     string   present|absent
   virtualenv string  optional venv path
-  become    bool     sudo
+  become    bool     run privileged via become_method/become_user; the task's become keyword wins
 
 ARTIFACTS
   name, state, venv, exit, stderr
+
+REGISTER
+  rc        int      exit status of pip
+  stderr    string   pip error output
+  artifacts.installed bool   package installed before the change (check)
 `,
     "package": `NAME
   package - install or remove packages via auto-detected manager
@@ -190,10 +264,15 @@ SYNOPSIS
 ARGS
   name      string   required
   action    string   install|remove
-  become    bool     sudo
+  become    bool     run privileged via become_method/become_user; the task's become keyword wins
 
 ARTIFACTS
   name, manager, cmd, exit
+
+REGISTER
+  rc        int      exit status of the package manager
+  artifacts.manager   string   detected package manager
+  artifacts.installed bool     package installed before the change (check)
 `,
     "service": `NAME
   service - manage services via systemd
@@ -205,9 +284,14 @@ SYNOPSIS
 ARGS
   name      string   required
   state     string   started|stopped|restarted
+  become    bool     run privileged via become_method/become_user; the task's become keyword wins
 
 ARTIFACTS
   name, state, active, cmd
+
+REGISTER
+  artifacts.active  bool     unit active before the change (check)
+  artifacts.cmd     string   systemctl command that was run
 `,
     "cron": `NAME
   cron - manage cron entries
@@ -232,9 +316,15 @@ ARGS
   month     string   default '*'
   weekday   string   default '*'
   state     string   present|absent
+  become    bool     run privileged via become_method/become_user; the task's become keyword wins
 
 ARTIFACTS
   user, name, exit, stderr
+
+REGISTER
+  rc        int      exit status of the crontab update
+  stderr    string   crontab error output
+  artifacts.present bool     entry present before the change (check)
 `,
 }
 
@@ -58,11 +58,12 @@ func (m mod) Apply(ctx context.Context, c module.Conn, args map[string]any) (mod
     if v := str(args["mode"]); v != "" { if mv, err := parseOctal(v); err == nil { mode = mv } }
     // ensure parent directory exists on remote
     dir := filepath.Dir(dest)
-    _, _, _, _ = c.Exec(ctx, fmt.Sprintf("mkdir -p %q", dir), nil, true)
+    _, _, _, _ = c.Exec(ctx, fmt.Sprintf("mkdir -p %q", dir), nil, boolVal(args["become"]))
     if err := c.Put(ctx, bytes.NewReader(data), dest, mode); err != nil { return module.Result{}, err }
     return module.Result{Changed: true, Msg: "copied", Artifacts: map[string]any{"dest": dest}}, nil
 }
 
+func boolVal(v any) bool { b, _ := v.(bool); return b }
 func sum(b []byte) string { s := sha256.Sum256(b); return hex.EncodeToString(s[:]) }
 func parseOctal(s string) (os.FileMode, error) { var m uint32; _, err := fmt.Sscanf(s, "%o", &m); return os.FileMode(m), err }
 func str(v any) string { if v == nil { return "" }; return fmt.Sprintf("%v", v) }
@@ -49,11 +49,12 @@ func (m mod) Apply(ctx context.Context, c module.Conn, args map[string]any) (mod
     state := str(args["state"]) 
     var cmd string
     if state == "present" { cmd = add } else { cmd = del }
-    _, errOut, exit, err := c.Exec(ctx, cmd, nil, true)
+    _, errOut, exit, err := c.Exec(ctx, cmd, nil, boolVal(args["become"]))
     if err != nil { return module.Result{}, err }
     return module.Result{Changed: exit == 0, Artifacts: map[string]any{"user": user, "name": name, "exit": exit, "stderr": errOut}}, nil
 }
 
 func str(v any) string { if v == nil { return "" }; return fmt.Sprintf("%v", v) }
+func boolVal(v any) bool { b, _ := v.(bool); return b }
 
 func init() { module.Register(mod{}) }
@@ -58,11 +58,11 @@ func (m mod) Apply(ctx context.Context, c module.Conn, args map[string]any) (mod
     dest := filepath.Join(base, fname)
     state := str(args["state"])
     if state == "absent" {
-        _, _, _, err := c.Exec(ctx, fmt.Sprintf("rm -rf %q", dest), nil, true)
+        _, _, _, err := c.Exec(ctx, fmt.Sprintf("rm -rf %q", dest), nil, boolVal(args["become"]))
         if err != nil { return module.Result{}, err }
         return module.Result{Changed: true, Msg: "removed", Artifacts: map[string]any{"path": base, "file_name": fname, "dest": dest, "state": state}}, nil
     }
-    _, _, _, err := c.Exec(ctx, fmt.Sprintf("mkdir -p %q", base), nil, true)
+    _, _, _, err := c.Exec(ctx, fmt.Sprintf("mkdir -p %q", base), nil, boolVal(args["become"]))
     if err != nil { return module.Result{}, err }
     mode := os.FileMode(0644)
     if v := str(args["mode"]); v != "" { if mv, err := parseOctal(v); err == nil { mode = mv } }
@@ -97,6 +97,7 @@ func render(args map[string]any, key string) string {
 	return buf.String()
 }
 
+func boolVal(v any) bool { b, _ := v.(bool); return b }
 func sum(b []byte) string { s := sha256.Sum256(b); return hex.EncodeToString(s[:]) }
 func parseOctal(s string) (os.FileMode, error) { var m uint32; _, err := fmt.Sscanf(s, "%o", &m); return os.FileMode(m), err }
 
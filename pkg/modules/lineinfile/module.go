@@ -40,9 +40,9 @@ func (m mod) Apply(ctx context.Context, c module.Conn, args map[string]any) (mod
     var cmd string
     if state == "present" {
         if re != "" {
-            cmd = fmt.Sprintf("bash -lc 'if grep -E %q %q >/dev/null 2>&1; then sed -i -E \"s/%s/%s/\" %q; else echo %q | sudo -n tee -a %q >/dev/null; fi'", re, path, re, line, path, line, path)
+            cmd = fmt.Sprintf("bash -lc 'if grep -E %q %q >/dev/null 2>&1; then sed -i -E \"s/%s/%s/\" %q; else echo %q >> %q; fi'", re, path, re, line, path, line, path)
         } else {
-            cmd = fmt.Sprintf("bash -lc 'grep -F %q %q >/dev/null 2>&1 || echo %q | sudo -n tee -a %q >/dev/null'", line, path, line, path)
+            cmd = fmt.Sprintf("bash -lc 'grep -F %q %q >/dev/null 2>&1 || echo %q >> %q'", line, path, line, path)
         }
     } else {
         if re != "" {
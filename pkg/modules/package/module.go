@@ -56,24 +56,25 @@ func (m mod) Apply(ctx context.Context, c module.Conn, args map[string]any) (mod
     var cmd string
     switch mgr {
     case "apt":
-        if act == "install" { cmd = fmt.Sprintf("bash -lc 'sudo -n apt-get update -y && sudo -n apt-get install -y %q'", name) } else { cmd = fmt.Sprintf("bash -lc 'sudo -n apt-get remove -y %q'", name) }
+        if act == "install" { cmd = fmt.Sprintf("bash -lc 'apt-get update -y && apt-get install -y %q'", name) } else { cmd = fmt.Sprintf("bash -lc 'apt-get remove -y %q'", name) }
     case "dnf":
-        if act == "install" { cmd = fmt.Sprintf("bash -lc 'sudo -n dnf install -y %q'", name) } else { cmd = fmt.Sprintf("bash -lc 'sudo -n dnf remove -y %q'", name) }
+        if act == "install" { cmd = fmt.Sprintf("bash -lc 'dnf install -y %q'", name) } else { cmd = fmt.Sprintf("bash -lc 'dnf remove -y %q'", name) }
     case "yum":
-        if act == "install" { cmd = fmt.Sprintf("bash -lc 'sudo -n yum install -y %q'", name) } else { cmd = fmt.Sprintf("bash -lc 'sudo -n yum remove -y %q'", name) }
+        if act == "install" { cmd = fmt.Sprintf("bash -lc 'yum install -y %q'", name) } else { cmd = fmt.Sprintf("bash -lc 'yum remove -y %q'", name) }
     case "apk":
-        if act == "install" { cmd = fmt.Sprintf("bash -lc 'sudo -n apk add %q'", name) } else { cmd = fmt.Sprintf("bash -lc 'sudo -n apk del %q'", name) }
+        if act == "install" { cmd = fmt.Sprintf("bash -lc 'apk add %q'", name) } else { cmd = fmt.Sprintf("bash -lc 'apk del %q'", name) }
     case "zypper":
-        if act == "install" { cmd = fmt.Sprintf("bash -lc 'sudo -n zypper -n install -y %q'", name) } else { cmd = fmt.Sprintf("bash -lc 'sudo -n zypper -n remove -y %q'", name) }
+        if act == "install" { cmd = fmt.Sprintf("bash -lc 'zypper -n install -y %q'", name) } else { cmd = fmt.Sprintf("bash -lc 'zypper -n remove -y %q'", name) }
     default:
-        if act == "install" { cmd = fmt.Sprintf("bash -lc 'sudo -n rpm -Uvh %q'", name) } else { cmd = fmt.Sprintf("bash -lc 'sudo -n rpm -e %q'", name) }
+        if act == "install" { cmd = fmt.Sprintf("bash -lc 'rpm -Uvh %q'", name) } else { cmd = fmt.Sprintf("bash -lc 'rpm -e %q'", name) }
     }
-    _, _, exit, err := c.Exec(ctx, cmd, nil, false)
+    _, _, exit, err := c.Exec(ctx, cmd, nil, boolVal(args["become"]))
     if err != nil { return module.Result{}, err }
     return module.Result{Changed: true, Artifacts: map[string]any{"name": name, "action": act, "manager": mgr, "cmd": cmd, "exit": exit}}, nil
 }
 
 func str(v any) string { if v == nil { return "" }; return fmt.Sprintf("%v", v) }
+func boolVal(v any) bool { b, _ := v.(bool); return b }
 
 func init() { module.Register(mod{}) }
 
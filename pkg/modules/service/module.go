@@ -45,19 +45,20 @@ func (m mod) Apply(ctx context.Context, c module.Conn, args map[string]any) (mod
     var cmd string
     switch state {
     case "started":
-        cmd = fmt.Sprintf("sudo -n systemctl start %q", name)
+        cmd = fmt.Sprintf("systemctl start %q", name)
     case "stopped":
-        cmd = fmt.Sprintf("sudo -n systemctl stop %q", name)
+        cmd = fmt.Sprintf("systemctl stop %q", name)
     case "restarted":
-        cmd = fmt.Sprintf("sudo -n systemctl restart %q", name)
+        cmd = fmt.Sprintf("systemctl restart %q", name)
     default:
         return module.Result{Changed: false}, nil
     }
-    _, _, _, err := c.Exec(ctx, cmd, nil, false)
+    _, _, _, err := c.Exec(ctx, cmd, nil, boolVal(args["become"]))
     if err != nil { return module.Result{}, err }
     return module.Result{Changed: true, Artifacts: map[string]any{"name": name, "state": state, "cmd": cmd}}, nil
 }
 
 func str(v any) string { if v == nil { return "" }; return fmt.Sprintf("%v", v) }
+func boolVal(v any) bool { b, _ := v.(bool); return b }
 
 func init() { module.Register(mod{}) }
@@ -0,0 +1,47 @@
+package setup
+
+import (
+    "context"
+    "fmt"
+
+    "gopsi/pkg/facts"
+    "gopsi/pkg/module"
+)
+
+// mod re-gathers facts mid-play. The runner merges Data["facts"] into the
+// host's `facts` var, so a result carrying facts updates them.
+type mod struct{}
+
+func (m mod) Name() string { return "setup" }
+
+func (m mod) Validate(args map[string]any) error {
+    _, err := facts.ResolveSubset(subset(args["gather_subset"]))
+    return err
+}
+
+// Check gathers too: it only reads from the host, so facts stay current
+// in check mode.
+func (m mod) Check(ctx context.Context, c module.Conn, args map[string]any) (module.Result, error) {
+    return m.Apply(ctx, c, args)
+}
+
+func (m mod) Apply(ctx context.Context, c module.Conn, args map[string]any) (module.Result, error) {
+    fs, err := facts.GatherSubset(ctx, c, subset(args["gather_subset"]))
+    if err != nil { return module.Result{}, err }
+    return module.Result{Changed: false, Data: map[string]any{"facts": fs}, Artifacts: map[string]any{"gather_subset": fs["gather_subset"]}}, nil
+}
+
+// subset accepts "network,hardware" or a list.
+func subset(v any) []string {
+    switch x := v.(type) {
+    case nil:
+        return nil
+    case []any:
+        var out []string
+        for _, e := range x { out = append(out, fmt.Sprintf("%v", e)) }
+        return out
+    }
+    return []string{fmt.Sprintf("%v", v)}
+}
+
+func init() { module.Register(mod{}) }
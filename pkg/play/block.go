@@ -0,0 +1,59 @@
+package play
+
+import "strconv"
+
+// IsBlock reports whether the task groups other tasks instead of running a
+// module.
+func (t Task) IsBlock() bool { return t.Block != nil }
+
+// Flatten lists the tasks that run modules, descending into blocks in
+// block, rescue, always order.
+func Flatten(tasks []Task) []Task {
+    var out []Task
+    for _, t := range tasks {
+        if !t.IsBlock() { out = append(out, t); continue }
+        out = append(out, Flatten(t.Block)...)
+        out = append(out, Flatten(t.Rescue)...)
+        out = append(out, Flatten(t.Always)...)
+    }
+    return out
+}
+
+// assignIDs numbers tasks below prefix, descending into blocks; see Task.ID.
+func assignIDs(tasks []Task, prefix string) {
+    for i := range tasks {
+        t := &tasks[i]
+        t.ID = prefix + "." + strconv.Itoa(i+1)
+        assignIDs(t.Block, t.ID)
+        assignIDs(t.Rescue, t.ID+".rescue")
+        assignIDs(t.Always, t.ID+".always")
+    }
+}
+
+// inherit applies what a block (or play) passes down to its tasks: tags and
+// notify are merged, when conditions are prepended, become settings, vars
+// and ignore_errors fill in what the task leaves unset. Tasks of nested
+// blocks get them too.
+func (t *Task) inherit(p Task) {
+    t.Tags = inheritTags(t.Tags, p.Tags)
+    t.Notify = inheritTags(t.Notify, p.Notify)
+    if _, set := t.Raw["ignore_errors"]; set {
+        // a nested block's own ignore_errors wins for its tasks
+        p.IgnoreErrors = t.IgnoreErrors
+    } else if p.IgnoreErrors {
+        t.IgnoreErrors = true
+    }
+    if len(p.When) > 0 { t.When = append(append([]string{}, p.When...), t.When...) }
+    if t.Become == nil { t.Become = p.Become }
+    if t.BecomeUser == "" { t.BecomeUser = p.BecomeUser }
+    if t.BecomeMethod == "" { t.BecomeMethod = p.BecomeMethod }
+    if len(p.Vars) > 0 {
+        vars := make(map[string]any, len(p.Vars)+len(t.Vars))
+        for k, v := range p.Vars { vars[k] = v }
+        for k, v := range t.Vars { vars[k] = v }
+        t.Vars = vars
+    }
+    for _, list := range [][]Task{t.Block, t.Rescue, t.Always} {
+        for i := range list { list[i].inherit(p) }
+    }
+}
@@ -1,7 +1,10 @@
 package play
 
 import (
+    "fmt"
     "os"
+    "strconv"
+    "strings"
 
     "gopkg.in/yaml.v3"
 )
@@ -12,7 +15,9 @@ func LoadPlaybook(path string) (Playbook, error) {
     var pb Playbook
     var rawList []map[string]any
     if err := yaml.Unmarshal(b, &rawList); err == nil && len(rawList) > 0 {
-        for _, p := range rawList { parsePlay(&pb, p) }
+        for _, p := range rawList {
+            if err := parsePlay(&pb, p); err != nil { return Playbook{}, err }
+        }
         if pb.SchemaVersion == 0 { pb.SchemaVersion = 1 }
         return pb, nil
     }
@@ -20,45 +25,159 @@ func LoadPlaybook(path string) (Playbook, error) {
     if err := yaml.Unmarshal(b, &rawMap); err != nil { return Playbook{}, err }
     if sv, ok := rawMap["schema_version"].(int); ok { pb.SchemaVersion = sv } else { pb.SchemaVersion = 1 }
     if ps, ok := rawMap["plays"].([]any); ok {
-        for _, p := range ps { parsePlay(&pb, p.(map[string]any)) }
+        for _, p := range ps {
+            if err := parsePlay(&pb, p.(map[string]any)); err != nil { return Playbook{}, err }
+        }
     }
     return pb, nil
 }
 
-func parsePlay(pb *Playbook, p map[string]any) {
+func parsePlay(pb *Playbook, p map[string]any) error {
     var pl Play
     if v, ok := p["hosts"].(string); ok { pl.Hosts = v }
+    if v, ok := p["hosts"].([]any); ok { pl.Hosts = strings.Join(stringList(v), ",") }
     if v, ok := p["become"].(bool); ok { pl.Become = v }
+    if v, ok := p["become_user"].(string); ok { pl.BecomeUser = v }
+    if v, ok := p["become_method"].(string); ok { pl.BecomeMethod = v }
     if v, ok := p["vars"].(map[string]any); ok { pl.Vars = v }
     if v, ok := p["serial"].(int); ok { pl.Serial = v }
+    if v, ok := p["any_errors_fatal"].(bool); ok { pl.AnyErrorsFatal = v }
+    if _, ok := p["max_fail_percentage"]; ok { v := number(p["max_fail_percentage"]); pl.MaxFailPercentage = &v }
+    if v, ok := p["gather_facts"].(bool); ok { pl.GatherFacts = &v }
+    pl.GatherSubset = stringList(p["gather_subset"])
+    pl.Tags = stringList(p["tags"])
     if ts, ok := p["tasks"].([]any); ok {
         for _, t := range ts {
-            tm, _ := t.(map[string]any)
-            task := Task{Raw: tm}
-            if v, ok := tm["name"].(string); ok { task.Name = v }
-            if v, ok := tm["tags"].([]any); ok { for _, x := range v { if s, ok := x.(string); ok { task.Tags = append(task.Tags, s) } } }
-            if v, ok := tm["when"].(string); ok { task.When = v }
-            if v, ok := tm["notify"].([]any); ok { for _, x := range v { if s, ok := x.(string); ok { task.Notify = append(task.Notify, s) } } }
-            if v, ok := tm["register"].(string); ok { task.Register = v }
-            for k, val := range tm {
-                switch k {
-                case "name", "tags", "when", "notify", "register":
-                default:
-                    task.Module = k
-                    if args, ok := val.(map[string]any); ok { task.Args = args } else { task.Args = map[string]any{"_": val} }
-                }
-            }
+            task, err := parseTask(t)
+            if err != nil { return fmt.Errorf("play %q: %w", pl.Hosts, err) }
+            task.inherit(Task{Tags: pl.Tags})
             pl.Tasks = append(pl.Tasks, task)
         }
     }
     if hs, ok := p["handlers"].([]any); ok {
         for _, t := range hs {
-            tm, _ := t.(map[string]any)
-            task := Task{Raw: tm}
-            if v, ok := tm["name"].(string); ok { task.Name = v }
-            for k, val := range tm { if k == "name" { continue }; task.Module = k; if args, ok := val.(map[string]any); ok { task.Args = args } else { task.Args = map[string]any{"_": val} } }
+            task, err := parseTask(t)
+            if err != nil { return fmt.Errorf("play %q: %w", pl.Hosts, err) }
             pl.Handlers = append(pl.Handlers, task)
         }
     }
+    n := strconv.Itoa(len(pb.Plays) + 1)
+    assignIDs(pl.Tasks, n)
+    assignIDs(pl.Handlers, n+".handlers")
     pb.Plays = append(pb.Plays, pl)
+    return nil
+}
+
+// notOnBlocks lists task keywords that make no sense on a block; they are
+// rejected rather than dropped.
+var notOnBlocks = []string{"register", "loop", "with_items", "loop_control"}
+
+// parseTask is shared by tasks and handlers; any key that is not a task
+// keyword names the module.
+func parseTask(t any) (Task, error) {
+    tm, _ := t.(map[string]any)
+    task := Task{Raw: tm}
+    if v, ok := tm["name"].(string); ok { task.Name = v }
+    task.Tags = stringList(tm["tags"])
+    task.When = conditions(tm["when"])
+    task.Notify = stringList(tm["notify"])
+    if v, ok := tm["register"].(string); ok { task.Register = v }
+    if v, ok := tm["become"].(bool); ok { task.Become = &v }
+    if v, ok := tm["become_user"].(string); ok { task.BecomeUser = v }
+    if v, ok := tm["become_method"].(string); ok { task.BecomeMethod = v }
+    if v, ok := tm["loop"]; ok { task.Loop = v }
+    if v, ok := tm["with_items"]; ok { task.Loop = v; task.WithItems = true }
+    if lc, ok := tm["loop_control"].(map[string]any); ok { task.LoopControl = parseLoopControl(lc) }
+    task.ChangedWhen = conditions(tm["changed_when"])
+    task.FailedWhen = conditions(tm["failed_when"])
+    if v, ok := tm["ignore_errors"].(bool); ok { task.IgnoreErrors = v }
+    if v, ok := tm["vars"].(map[string]any); ok { task.Vars = v }
+    task.Until = conditions(tm["until"])
+    if v, ok := tm["retries"].(int); ok { task.Retries = v }
+    task.Delay = number(tm["delay"])
+    if _, ok := tm["block"]; ok {
+        for _, k := range notOnBlocks {
+            if _, ok := tm[k]; ok { return Task{}, fmt.Errorf("block %q: %s is not allowed on a block", task.Name, k) }
+        }
+        var err error
+        if task.Block, err = parseTasks(tm["block"]); err != nil { return Task{}, err }
+        if task.Rescue, err = parseTasks(tm["rescue"]); err != nil { return Task{}, err }
+        if task.Always, err = parseTasks(tm["always"]); err != nil { return Task{}, err }
+        for _, list := range [][]Task{task.Block, task.Rescue, task.Always} {
+            for i := range list { list[i].inherit(task) }
+        }
+        return task, nil
+    }
+    for k, val := range tm {
+        switch k {
+        case "name", "tags", "when", "notify", "register", "loop", "with_items", "loop_control", "become", "become_user", "become_method",
+            "changed_when", "failed_when", "ignore_errors", "vars", "until", "retries", "delay":
+        default:
+            task.Module = k
+            if args, ok := val.(map[string]any); ok { task.Args = args } else { task.Args = map[string]any{"_": val} }
+        }
+    }
+    return task, nil
+}
+
+// parseTasks parses a block section; a present but empty section gives an
+// empty, non-nil list.
+func parseTasks(v any) ([]Task, error) {
+    list, ok := v.([]any)
+    if !ok { return nil, nil }
+    tasks := make([]Task, 0, len(list))
+    for _, t := range list {
+        task, err := parseTask(t)
+        if err != nil { return nil, err }
+        tasks = append(tasks, task)
+    }
+    return tasks, nil
+}
+
+func parseLoopControl(lc map[string]any) LoopControl {
+    var c LoopControl
+    if v, ok := lc["loop_var"].(string); ok { c.LoopVar = v }
+    if v, ok := lc["index_var"].(string); ok { c.IndexVar = v }
+    if v, ok := lc["label"].(string); ok { c.Label = v }
+    c.Pause = number(lc["pause"])
+    return c
+}
+
+// number accepts a whole or fractional number, such as seconds or a
+// percentage.
+func number(v any) float64 {
+    switch x := v.(type) {
+    case int:
+        return float64(x)
+    case float64:
+        return x
+    }
+    return 0
+}
+
+// conditions accepts a single `when` expression or a list of them (implicit
+// AND); YAML scalars such as `true` are kept as expression text.
+func conditions(v any) []string {
+    switch x := v.(type) {
+    case nil:
+        return nil
+    case []any:
+        var out []string
+        for _, e := range x { out = append(out, fmt.Sprintf("%v", e)) }
+        return out
+    }
+    return []string{fmt.Sprintf("%v", v)}
+}
+
+// stringList accepts either a single string or a list of strings.
+func stringList(v any) []string {
+    switch x := v.(type) {
+    case string:
+        return []string{x}
+    case []any:
+        var out []string
+        for _, e := range x { if s, ok := e.(string); ok { out = append(out, s) } }
+        return out
+    }
+    return nil
 }
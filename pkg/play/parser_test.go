@@ -2,23 +2,193 @@ package play
 
 import (
     "os"
+    "strings"
     "testing"
 )
 
+// writePlaybook writes y to a temporary playbook file and returns its path.
+func writePlaybook(t *testing.T, y string) string {
+    t.Helper()
+    f, err := os.CreateTemp(t.TempDir(), "pb-*.yml")
+    if err != nil { t.Fatal(err) }
+    if _, err := f.WriteString(y); err != nil { t.Fatal(err) }
+    _ = f.Close()
+    return f.Name()
+}
+
+// loadPlaybookString writes y to a temporary file and loads it.
+func loadPlaybookString(t *testing.T, y string) Playbook {
+    t.Helper()
+    pb, err := LoadPlaybook(writePlaybook(t, y))
+    if err != nil { t.Fatal(err) }
+    return pb
+}
+
 func TestLoadPlaybook(t *testing.T) {
-    y := []byte(`- hosts: all
+    y := `- hosts: all
   vars: { a: 1 }
   tasks:
   - name: hello
     command: echo hello
-`)
-    f, err := os.CreateTemp(t.TempDir(), "pb-*.yml")
-    if err != nil { t.Fatal(err) }
-    if _, err := f.Write(y); err != nil { t.Fatal(err) }
-    _ = f.Close()
-    pb, err := LoadPlaybook(f.Name())
-    if err != nil { t.Fatal(err) }
+`
+    pb := loadPlaybookString(t, y)
     if len(pb.Plays) != 1 { t.Fatalf("expected 1 play") }
     if pb.Plays[0].Hosts != "all" { t.Fatalf("wrong hosts") }
     if len(pb.Plays[0].Tasks) != 1 { t.Fatalf("expected 1 task") }
 }
+
+func TestLoadPlaybookHandlers(t *testing.T) {
+    y := `- hosts: all
+  tasks:
+  - name: write config
+    copy: { content: "x", dest: /tmp/x }
+    notify: restart app
+  - meta: flush_handlers
+  handlers:
+  - name: restart app
+    service: { name: app, state: restarted }
+    when: facts.os_family == "Linux"
+`
+    pb := loadPlaybookString(t, y)
+    pl := pb.Plays[0]
+    if len(pl.Tasks[0].Notify) != 1 || pl.Tasks[0].Notify[0] != "restart app" { t.Fatalf("notify not parsed: %v", pl.Tasks[0].Notify) }
+    if pl.Tasks[1].Module != "meta" || pl.Tasks[1].Args["_"] != "flush_handlers" { t.Fatalf("meta not parsed: %+v", pl.Tasks[1]) }
+    if len(pl.Handlers) != 1 || pl.Handlers[0].Module != "service" { t.Fatalf("handler module wrong: %+v", pl.Handlers) }
+    if len(pl.Handlers[0].When) != 1 { t.Fatalf("handler when not parsed") }
+}
+
+func TestTagSelection(t *testing.T) {
+    y := `- hosts: all
+  tags: web
+  tasks:
+  - name: conf
+    command: echo a
+    tags: [conf]
+  - name: debug
+    command: echo b
+    tags: never
+  - name: facts
+    command: echo c
+    tags: [always]
+`
+    pb := loadPlaybookString(t, y)
+    ts := pb.Plays[0].Tasks
+    if len(ts[0].Tags) != 2 || ts[0].Tags[1] != "web" { t.Fatalf("play tags not inherited: %v", ts[0].Tags) }
+    cases := []struct {
+        only, skip []string
+        want       [3]bool
+    }{
+        {nil, nil, [3]bool{true, false, true}},
+        {[]string{"conf"}, nil, [3]bool{true, false, true}},
+        {[]string{"never"}, nil, [3]bool{false, true, true}},
+        {[]string{"web"}, []string{"always"}, [3]bool{true, true, false}},
+        {nil, []string{"conf"}, [3]bool{false, false, true}},
+        {[]string{"untagged"}, nil, [3]bool{false, false, true}},
+    }
+    for _, c := range cases {
+        for i, task := range ts {
+            if got := Selected(task.Tags, c.only, c.skip); got != c.want[i] {
+                t.Errorf("only=%v skip=%v task=%s: got %v want %v", c.only, c.skip, task.Name, got, c.want[i])
+            }
+        }
+    }
+}
+
+func TestLoadPlaybookErrorHandling(t *testing.T) {
+    y := `- hosts: all
+  any_errors_fatal: true
+  max_fail_percentage: 12.5
+  tasks:
+  - name: probe
+    command: grep -q x /etc/app.conf
+    register: probe
+    changed_when: false
+    failed_when: [probe.rc > 1, "'denied' in probe.stderr"]
+    ignore_errors: true
+    until: probe.rc == 0
+    retries: 4
+    delay: 0.5
+`
+    pb := loadPlaybookString(t, y)
+    pl := pb.Plays[0]
+    if !pl.AnyErrorsFatal || pl.MaxFailPercentage == nil || *pl.MaxFailPercentage != 12.5 { t.Fatalf("play failure limits: %+v", pl) }
+    task := pl.Tasks[0]
+    if task.Module != "command" { t.Fatalf("keywords taken for the module: %q", task.Module) }
+    if len(task.ChangedWhen) != 1 || task.ChangedWhen[0] != "false" { t.Fatalf("changed_when: %v", task.ChangedWhen) }
+    if len(task.FailedWhen) != 2 || !task.IgnoreErrors { t.Fatalf("failed_when/ignore_errors: %+v", task) }
+    if len(task.Until) != 1 || task.Retries != 4 || task.Delay != 0.5 { t.Fatalf("until/retries/delay: %+v", task) }
+}
+
+func TestLoadPlaybookBlocks(t *testing.T) {
+    y := `- hosts: all
+  tags: [deploy]
+  tasks:
+  - name: rollout
+    when: env == "prod"
+    become: true
+    vars: { app: api, port: 80 }
+    tags: [rollout]
+    block:
+    - name: stop
+      service: { name: app, state: stopped }
+    - block:
+      - name: copy
+        copy: { src: app, dest: /opt/app }
+        vars: { port: 8080 }
+        when: port > 0
+      become: false
+      tags: [files]
+    rescue:
+    - name: rollback
+      command: /opt/app/rollback
+    always:
+    - name: start
+      service: { name: app, state: started }
+`
+    pb := loadPlaybookString(t, y)
+    b := pb.Plays[0].Tasks[0]
+    if !b.IsBlock() || b.Module != "" || len(b.Block) != 2 || len(b.Rescue) != 1 || len(b.Always) != 1 { t.Fatalf("block not parsed: %+v", b) }
+    var names []string
+    for _, task := range Flatten(pb.Plays[0].Tasks) { names = append(names, task.Name) }
+    if strings.Join(names, ",") != "stop,copy,rollback,start" { t.Fatalf("flatten: %v", names) }
+    var ids []string
+    for _, task := range Flatten(pb.Plays[0].Tasks) { ids = append(ids, task.ID) }
+    if strings.Join(ids, ",") != "1.1.1,1.1.2.1,1.1.rescue.1,1.1.always.1" { t.Fatalf("ids: %v", ids) }
+    cp := b.Block[1].Block[0]
+    if strings.Join(cp.When, " | ") != `env == "prod" | port > 0` { t.Fatalf("when: %v", cp.When) }
+    if cp.Become == nil || *cp.Become { t.Fatalf("inner become should win: %v", cp.Become) }
+    if cp.Vars["app"] != "api" || cp.Vars["port"] != 8080 { t.Fatalf("vars: %v", cp.Vars) }
+    if strings.Join(cp.Tags, ",") != "files,rollout,deploy" { t.Fatalf("tags: %v", cp.Tags) }
+    rb := b.Rescue[0]
+    if rb.Become == nil || !*rb.Become || len(rb.When) != 1 || strings.Join(rb.Tags, ",") != "rollout,deploy" { t.Fatalf("rescue inheritance: %+v", rb) }
+}
+
+func TestBlockKeywords(t *testing.T) {
+    pb := loadPlaybookString(t, `- hosts: all
+  tasks:
+  - block:
+    - name: probe
+      command: /opt/app/probe
+    - block:
+      - name: strict
+        command: /opt/app/check
+      ignore_errors: false
+      notify: page oncall
+    - name: reload
+      command: /opt/app/reload
+      notify: [restart app]
+    ignore_errors: true
+    notify: restart app
+`)
+    b := pb.Plays[0].Tasks[0]
+    probe, strict, reload := b.Block[0], b.Block[1].Block[0], b.Block[2]
+    if !probe.IgnoreErrors || strict.IgnoreErrors || !reload.IgnoreErrors { t.Fatalf("ignore_errors: probe=%v strict=%v reload=%v", probe.IgnoreErrors, strict.IgnoreErrors, reload.IgnoreErrors) }
+    if strings.Join(probe.Notify, ",") != "restart app" || strings.Join(strict.Notify, ",") != "page oncall,restart app" || strings.Join(reload.Notify, ",") != "restart app" {
+        t.Fatalf("notify: %v %v %v", probe.Notify, strict.Notify, reload.Notify)
+    }
+
+    for _, k := range []string{"register: out", "loop: [a, b]", "with_items: [a]"} {
+        _, err := LoadPlaybook(writePlaybook(t, "- hosts: all\n  tasks:\n  - name: grouped\n    "+k+"\n    block:\n    - command: echo\n"))
+        if err == nil || !strings.Contains(err.Error(), "not allowed on a block") { t.Errorf("%s: expected block error, got %v", k, err) }
+    }
+}
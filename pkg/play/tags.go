@@ -0,0 +1,54 @@
+package play
+
+// Special tags: a task tagged "always" runs unless "always" (or another of
+// its tags) is skipped; a task tagged "never" runs only when one of its tags
+// is requested explicitly. "all", "tagged" and "untagged" may be used as
+// selectors in --tags and --skip-tags.
+const (
+    TagAlways = "always"
+    TagNever  = "never"
+)
+
+// Selected reports whether a task with the given tags runs under the
+// --tags (only) and --skip-tags (skip) selections.
+func Selected(tags, only, skip []string) bool {
+    if len(skip) > 0 && matchTags(tags, skip) { return false }
+    if hasTag(tags, TagAlways) { return true }
+    if len(only) == 0 { return !hasTag(tags, TagNever) }
+    return matchTags(tags, only)
+}
+
+// DisplayName is the label used for a task in listings and output.
+func (t Task) DisplayName() string {
+    if t.Name != "" { return t.Name }
+    if t.IsBlock() { return "block" }
+    if s, ok := t.Args["_"].(string); ok { return t.Module + ": " + s }
+    return t.Module
+}
+
+func matchTags(tags, sel []string) bool {
+    for _, s := range sel {
+        switch s {
+        case "all":
+            if !hasTag(tags, TagNever) { return true }
+        case "tagged":
+            if len(tags) > 0 { return true }
+        case "untagged":
+            if len(tags) == 0 { return true }
+        default:
+            if hasTag(tags, s) { return true }
+        }
+    }
+    return false
+}
+
+func hasTag(tags []string, tag string) bool {
+    for _, t := range tags { if t == tag { return true } }
+    return false
+}
+
+// inheritTags appends parent tags not already present on the task.
+func inheritTags(tags, parent []string) []string {
+    for _, p := range parent { if !hasTag(tags, p) { tags = append(tags, p) } }
+    return tags
+}
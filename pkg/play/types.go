@@ -8,19 +8,65 @@ type Playbook struct {
 type Play struct {
     Hosts   string                 `yaml:"hosts"`
     Become  bool                   `yaml:"become"`
+    BecomeUser   string            `yaml:"become_user"`
+    BecomeMethod string            `yaml:"become_method"`
     Serial  int                    `yaml:"serial"`
+    // AnyErrorsFatal and MaxFailPercentage end the run once any host, or
+    // more than that share of the play's hosts, has failed.
+    AnyErrorsFatal    bool         `yaml:"any_errors_fatal"`
+    MaxFailPercentage *float64     `yaml:"max_fail_percentage"`
+    // GatherFacts defaults to true; GatherSubset limits what is gathered.
+    GatherFacts  *bool             `yaml:"gather_facts"`
+    GatherSubset []string          `yaml:"gather_subset"`
     Vars    map[string]any         `yaml:"vars"`
+    Tags    []string               `yaml:"tags"`
     Tasks   []Task                  `yaml:"tasks"`
     Handlers []Task                `yaml:"handlers"`
 }
 
 type Task struct {
+    // ID is the task's position in the playbook, set by LoadPlaybook: "2.3"
+    // is the third task of the second play, "2.3.rescue.1" the first task
+    // of its rescue, "2.handlers.1" the play's first handler.
+    ID      string                 `yaml:"-"`
     Name    string                 `yaml:"name"`
     Module  string                 `yaml:"-"`
     Args    map[string]any         `yaml:"-"`
     Raw     map[string]any         `yaml:",inline"`
     Tags    []string               `yaml:"tags"`
-    When    string                 `yaml:"when"`
+    When    []string               `yaml:"when"`
     Notify  []string               `yaml:"notify"`
     Register string                `yaml:"register"`
+    // Become overrides the play's become when set.
+    Become  *bool                  `yaml:"become"`
+    BecomeUser   string            `yaml:"become_user"`
+    BecomeMethod string            `yaml:"become_method"`
+    Loop    any                    `yaml:"loop"`
+    WithItems bool                 `yaml:"-"`
+    LoopControl LoopControl        `yaml:"loop_control"`
+    // ChangedWhen and FailedWhen override the module's verdict; they see
+    // the registered result. IgnoreErrors lets the host go on after a failure.
+    ChangedWhen  []string          `yaml:"changed_when"`
+    FailedWhen   []string          `yaml:"failed_when"`
+    IgnoreErrors bool              `yaml:"ignore_errors"`
+    // Until repeats the task until it holds, up to Retries more times with
+    // Delay seconds in between; Retries alone repeats it while it fails.
+    Until   []string               `yaml:"until"`
+    Retries int                    `yaml:"retries"`
+    Delay   float64                `yaml:"delay"`
+    // Vars overlay the host vars for this task only.
+    Vars    map[string]any         `yaml:"vars"`
+    // Block, Rescue and Always make the task a block (see IsBlock); the
+    // block's tags, when, become and vars are pushed down at parse time.
+    Block   []Task                 `yaml:"block"`
+    Rescue  []Task                 `yaml:"rescue"`
+    Always  []Task                 `yaml:"always"`
+}
+
+// LoopControl tunes how a looped task runs. Pause is in seconds.
+type LoopControl struct {
+    LoopVar  string                `yaml:"loop_var"`
+    IndexVar string                `yaml:"index_var"`
+    Label    string                `yaml:"label"`
+    Pause    float64               `yaml:"pause"`
 }
@@ -0,0 +1,44 @@
+package runner
+
+import (
+	"fmt"
+
+	"gopsi/pkg/conn"
+	"gopsi/pkg/play"
+)
+
+// become resolves the task's privilege escalation: task keywords override
+// play keywords, which override the host vars become_user and
+// become_method. The password comes from the become_password var (inline
+// vault values are decrypted) or, with --ask-become-pass, a prompt shared by
+// all hosts.
+func (r *Runner) become(pl play.Play, t play.Task, vars map[string]any) (bool, conn.Become, error) {
+	on := pl.Become
+	// a module's own `become:` arg overrides the play; the task keyword wins
+	if v, ok := t.Args["become"].(bool); ok {
+		on = v
+	}
+	if t.Become != nil {
+		on = *t.Become
+	}
+	if !on {
+		return false, conn.Become{}, nil
+	}
+	method, err := conn.ParseBecomeMethod(firstNonEmpty(t.BecomeMethod, pl.BecomeMethod, stringVar(vars, "become_method")))
+	if err != nil {
+		return false, conn.Become{}, err
+	}
+	b := conn.Become{
+		Method: method,
+		User:   firstNonEmpty(t.BecomeUser, pl.BecomeUser, stringVar(vars, "become_user"), "root"),
+	}
+	if b.Password, err = r.secretVar(vars, "become_password"); err != nil {
+		return false, conn.Become{}, err
+	}
+	if b.Password == "" && r.askBecomePass {
+		if b.Password, err = r.ask(fmt.Sprintf("%s password: ", method)); err != nil {
+			return false, conn.Become{}, err
+		}
+	}
+	return true, b, nil
+}
@@ -0,0 +1,41 @@
+package runner
+
+import (
+	"testing"
+
+	"gopsi/pkg/play"
+)
+
+func TestBecomePrecedence(t *testing.T) {
+	r := New(1, false)
+	vars := map[string]any{"become_method": "doas", "become_user": "ops", "become_password": "pw"}
+	pl := play.Play{Become: true, BecomeUser: "app"}
+
+	on, b, err := r.become(pl, play.Task{}, vars)
+	if err != nil || !on || b.Method != "doas" || b.User != "app" || b.Password != "pw" {
+		t.Fatalf("play: on=%v b=%+v err=%v", on, b, err)
+	}
+	off := false
+	if on, _, _ := r.become(pl, play.Task{Become: &off}, vars); on {
+		t.Fatal("task become: false should override the play")
+	}
+	on, b, _ = r.become(play.Play{}, play.Task{Become: new(bool), BecomeMethod: "su"}, nil)
+	if on {
+		t.Fatalf("unexpected become %+v", b)
+	}
+	yes := true
+	on, b, _ = r.become(play.Play{}, play.Task{Become: &yes, BecomeMethod: "su"}, nil)
+	if !on || b.Method != "su" || b.User != "root" {
+		t.Fatalf("task: on=%v b=%+v", on, b)
+	}
+	arg := map[string]any{"become": true}
+	if on, _, _ := r.become(play.Play{}, play.Task{Args: arg}, nil); !on {
+		t.Fatal("module become arg should apply")
+	}
+	if on, _, _ := r.become(play.Play{}, play.Task{Args: arg, Become: &off}, nil); on {
+		t.Fatal("task become keyword should win over the module arg")
+	}
+	if _, _, err := r.become(pl, play.Task{BecomeMethod: "pbrun"}, nil); err == nil {
+		t.Fatal("expected error for unknown become_method")
+	}
+}
@@ -0,0 +1,49 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"gopsi/pkg/module"
+	"gopsi/pkg/play"
+)
+
+// taskError records which task failed so a rescue section can inspect it.
+type taskError struct {
+	task play.Task
+	res  module.Result
+	err  error
+}
+
+func (e *taskError) Error() string { return e.err.Error() }
+func (e *taskError) Unwrap() error { return e.err }
+
+// runBlock runs a block's tasks. When one fails, the rescue section runs
+// with `failed_task` (name, module) and `failed_result` (the failing task's
+// registered value) set, and a rescue that completes clears the failure.
+// The always section runs in every case; its own failure is returned only
+// when nothing failed before.
+func (r *Runner) runBlock(ctx context.Context, hr *hostRun, pl play.Play, t play.Task) error {
+	r.verbosef(2, "BLOCK [%s] host=%s", t.DisplayName(), hr.host.Name)
+	err := r.runTasks(ctx, hr, pl, t.Block)
+	var te *taskError
+	if len(t.Rescue) > 0 && errors.As(err, &te) {
+		r.printFailed(hr.host.Name, te.task.Name, te.err, "rescued")
+		r.tally(hr.host.Name, func(s *hostStats) { s.Rescued++ })
+		r.verbosef(1, "RESCUE [%s] host=%s failed_task=%q", t.DisplayName(), hr.host.Name, te.task.DisplayName())
+		hr.vars["failed_task"] = map[string]any{"name": te.task.DisplayName(), "module": te.task.Module}
+		hr.vars["failed_result"] = registerValue(te.res, false, te.err)
+		err = r.runTasks(ctx, hr, pl, t.Rescue)
+	}
+	if len(t.Always) > 0 {
+		r.verbosef(2, "ALWAYS [%s] host=%s", t.DisplayName(), hr.host.Name)
+		if aerr := r.runTasks(ctx, hr, pl, t.Always); aerr != nil {
+			if err == nil {
+				return aerr
+			}
+			r.verbosef(1, colorRed(fmt.Sprintf("%s always error %s %v", hr.host.Name, t.DisplayName(), aerr)))
+		}
+	}
+	return err
+}
@@ -0,0 +1,71 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"gopsi/pkg/inventory"
+	"gopsi/pkg/module"
+	"gopsi/pkg/play"
+)
+
+// stepMod records the `_` arg of every run and fails when `fail` is true.
+type stepMod struct{ ran *[]string }
+
+func (m stepMod) Name() string                       { return "step" }
+func (m stepMod) Validate(args map[string]any) error { return nil }
+func (m stepMod) Check(ctx context.Context, c module.Conn, args map[string]any) (module.Result, error) {
+	return module.Result{Changed: true}, nil
+}
+func (m stepMod) Apply(ctx context.Context, c module.Conn, args map[string]any) (module.Result, error) {
+	*m.ran = append(*m.ran, args["_"].(string))
+	if f, _ := args["fail"].(bool); f {
+		return module.Result{}, errors.New(args["_"].(string) + " broke")
+	}
+	return module.Result{Changed: true}, nil
+}
+
+func TestRunBlock(t *testing.T) {
+	var ran []string
+	module.Register(stepMod{ran: &ran})
+	step := func(name string, fail bool) play.Task {
+		return play.Task{Name: name, Module: "step", Args: map[string]any{"_": name, "fail": fail}}
+	}
+	run := func(tasks ...play.Task) (*hostRun, error) {
+		ran = nil
+		hr := &hostRun{host: inventory.Host{Name: "web1"}, vars: map[string]any{}, notified: map[string]bool{}}
+		return hr, (&Runner{}).runTasks(context.Background(), hr, play.Play{}, tasks)
+	}
+
+	inner := play.Task{Block: []play.Task{step("deploy", false), step("migrate", true), step("unreached", false)}}
+	rescue := step("rollback", false)
+	rescue.When = []string{`failed_task.name == "migrate" and "broke" in failed_result.msg`}
+	hr, err := run(play.Task{
+		Block:  []play.Task{inner},
+		Rescue: []play.Task{rescue},
+		Always: []play.Task{step("notify", false)},
+	}, step("next", false))
+	if err != nil {
+		t.Fatalf("rescued block should not fail: %v", err)
+	}
+	if want := []string{"deploy", "migrate", "rollback", "notify", "next"}; !reflect.DeepEqual(ran, want) {
+		t.Fatalf("ran %v, want %v", ran, want)
+	}
+	if hr.vars["failed_task"].(map[string]any)["module"] != "step" {
+		t.Fatalf("failed_task: %v", hr.vars["failed_task"])
+	}
+
+	_, err = run(play.Task{
+		Block:  []play.Task{step("deploy", true)},
+		Rescue: []play.Task{step("rollback", true)},
+		Always: []play.Task{step("cleanup", false)},
+	}, step("next", false))
+	if err == nil || err.Error() != "rollback broke" {
+		t.Fatalf("failed rescue should fail the host: %v", err)
+	}
+	if want := []string{"deploy", "rollback", "cleanup"}; !reflect.DeepEqual(ran, want) {
+		t.Fatalf("ran %v, want %v", ran, want)
+	}
+}
@@ -0,0 +1,468 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopsi/pkg/conn"
+	"gopsi/pkg/facts"
+	"gopsi/pkg/inventory"
+	"gopsi/pkg/module"
+	"gopsi/pkg/play"
+	"gopsi/pkg/vault"
+)
+
+// connect returns the run's pooled connection for the host, opening it
+// through the transport named by its `connection` var (default ssh; see
+// conn.Transports) on first use. Inventory aliases of the same target
+// share one connection.
+func (r *Runner) connect(ctx context.Context, h inventory.Host) (module.Conn, error) {
+	kind := stringVar(h.Vars, "connection")
+	t := conn.Target{Name: h.Name, Addr: h.Addr, Vars: h.Vars}
+	var key string
+	switch kind {
+	case "", "ssh":
+		cfg, err := r.sshConfig(h)
+		if err != nil {
+			return nil, err
+		}
+		t.SSH = func() (conn.Config, error) { return cfg, nil }
+		key = "ssh://" + cfg.User + "@" + cfg.HostPort()
+		for _, j := range cfg.Jump {
+			key += "+" + j.User + "@" + j.HostPort()
+		}
+	case "local":
+		key = "local://"
+	default:
+		name := stringVar(h.Vars, "container")
+		if name == "" {
+			name = firstNonEmpty(h.Addr, h.Name)
+		}
+		key = kind + "://" + name
+	}
+	r.verbosef(2, "HOST %s connection %s", h.Name, key)
+	return r.pool.Get(ctx, key, func(ctx context.Context) (conn.Closer, error) {
+		c, err := conn.Open(ctx, kind, t)
+		if err != nil {
+			return nil, err
+		}
+		if sc, ok := c.(*conn.SSHConn); ok {
+			r.verbosef(1, "HOST %s authenticated via %s", h.Name, sc.AuthMethod())
+		}
+		return c, nil
+	})
+}
+
+// hostFacts returns the facts for a play: gathered once per host and
+// reused in later plays that need no other subset, read from the fact
+// cache when fresh, or skipped with `gather_facts: false`.
+func (r *Runner) hostFacts(ctx context.Context, h inventory.Host, c module.Conn, pl play.Play) (facts.Facts, error) {
+	r.factsMu.Lock()
+	fs, ok := r.factsCache[h.Name]
+	r.factsMu.Unlock()
+	if pl.GatherFacts != nil && !*pl.GatherFacts {
+		if !ok {
+			if fs, ok = r.factCache.Load(h.Name, nil); !ok {
+				fs = facts.Facts{}
+			}
+		}
+		r.verbosef(2, "%s facts not gathered (gather_facts: false)", h.Name)
+		return fs, nil
+	}
+	subset, err := facts.ResolveSubset(pl.GatherSubset)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", h.Name, err)
+	}
+	if ok && fs.Covers(subset) {
+		r.verbosef(2, "%s facts cached", h.Name)
+		return fs, nil
+	}
+	if cached, hit := r.factCache.Load(h.Name, subset); hit {
+		r.verbosef(2, "%s facts from fact cache", h.Name)
+		r.factsMu.Lock()
+		r.factsCache[h.Name] = cached
+		r.factsMu.Unlock()
+		return cached, nil
+	}
+	gathered, err := facts.GatherSubset(ctx, c, subset)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		gathered = facts.Merge(fs, gathered)
+	}
+	r.storeFacts(h.Name, gathered)
+	r.verbosef(1, "%s facts %v %v %v", h.Name, gathered["distro"], gathered["distro_version"], gathered["architecture"])
+	r.verbosef(3, "%s facts %s", h.Name, summarizeMap(gathered, 4096))
+	return gathered, nil
+}
+
+// storeFacts records a host's facts for later plays and in the fact cache.
+func (r *Runner) storeFacts(host string, fs facts.Facts) {
+	r.factsMu.Lock()
+	r.factsCache[host] = fs
+	r.factsMu.Unlock()
+	if err := r.factCache.Save(host, fs); err != nil {
+		r.verbosef(1, "%s fact cache: %v", host, err)
+	}
+}
+
+// sshConfig builds SSH dial settings from the host's inventory vars:
+// user, port, ssh_keepalive, ssh_max_sessions, proxy_jump, ssh_private_key_file (one path or a list),
+// ssh_private_key_passphrase, ssh_password, ssh_agent, ssh_host_key_checking,
+// ssh_known_hosts_file and ssh_config_file. Matching ~/.ssh/config Host
+// blocks fill in HostName, Port, User, IdentityFile and ProxyJump where the
+// inventory is silent.
+func (r *Runner) sshConfig(h inventory.Host) (conn.Config, error) {
+	sshCfg, err := loadSSHConfig(h.Vars)
+	if err != nil {
+		return conn.Config{}, err
+	}
+	addr := h.Addr
+	if addr == "" {
+		addr = h.Name
+	}
+	hc := sshCfg.Lookup(addr)
+	if hc.HostName != "" {
+		addr = hc.HostName
+	}
+	user := stringVar(h.Vars, "user")
+	if user == "" {
+		user = hc.User
+	}
+	if user == "" {
+		user = os.Getenv("USER")
+	}
+	port := intVar(h.Vars, "port")
+	if port == 0 {
+		port = hc.Port
+	}
+	hkMode := r.hostKeyMode
+	if v := stringVar(h.Vars, "ssh_host_key_checking"); v != "" {
+		m, err := conn.ParseHostKeyMode(v)
+		if err != nil {
+			return conn.Config{}, err
+		}
+		hkMode = m
+	}
+	knownHosts := expandHome(stringVar(h.Vars, "ssh_known_hosts_file"))
+	if knownHosts == "" {
+		knownHosts = conn.DefaultKnownHostsFile()
+	}
+	if hkMode == conn.HostKeyOff {
+		r.verbosef(1, colorYellow(fmt.Sprintf("HOST %s host key checking disabled", h.Name)))
+	}
+	passphrase, err := r.secretVar(h.Vars, "ssh_private_key_passphrase")
+	if err != nil {
+		return conn.Config{}, err
+	}
+	password, err := r.secretVar(h.Vars, "ssh_password")
+	if err != nil {
+		return conn.Config{}, err
+	}
+	keys := pathList(h.Vars["ssh_private_key_file"])
+	if len(keys) == 0 {
+		keys = hc.IdentityFiles
+	}
+	if len(keys) == 0 {
+		keys = defaultIdentityFiles()
+	}
+	useAgent := boolVar(h.Vars, "ssh_agent", true)
+	keepAlive := defaultKeepAlive
+	if _, ok := h.Vars["ssh_keepalive"]; ok {
+		keepAlive = intVar(h.Vars, "ssh_keepalive")
+	}
+	cfg := conn.Config{
+		User:       user,
+		Addr:       addr,
+		Port:       port,
+		Timeout:    15 * time.Second,
+		Identities: identities(keys, passphrase),
+		Agent:      useAgent,
+		Password:   password,
+		Passphrase: func(path string) ([]byte, error) {
+			s, err := r.ask(fmt.Sprintf("Enter passphrase for key '%s': ", path))
+			return []byte(s), err
+		},
+		HostKeyMode:    hkMode,
+		KnownHostsFile: knownHosts,
+		KeepAlive:      time.Duration(keepAlive) * time.Second,
+		MaxSessions:    intVar(h.Vars, "ssh_max_sessions"),
+	}
+	jumps, ok := h.Vars["proxy_jump"]
+	if !ok {
+		jumps = hc.ProxyJump
+	}
+	if cfg.Jump, err = jumpHops(jumps, sshCfg, cfg, keys, passphrase); err != nil {
+		return conn.Config{}, fmt.Errorf("%s: proxy_jump: %w", h.Name, err)
+	}
+	r.verbosef(1, "HOST %s connect user=%s addr=%s identities=%d agent=%v password=%v", h.Name, user, cfg.HostPort(), len(cfg.Identities), useAgent && os.Getenv("SSH_AUTH_SOCK") != "", password != "")
+	r.verbosef(2, "HOST %s host_key_checking=%s known_hosts=%s", h.Name, hkMode, knownHosts)
+	for _, id := range cfg.Identities {
+		r.verbosef(2, "HOST %s identity %s", h.Name, id.Path)
+	}
+	for _, j := range cfg.Jump {
+		r.verbosef(1, "HOST %s via %s@%s", h.Name, j.User, j.HostPort())
+	}
+	return cfg, nil
+}
+
+// defaultKeepAlive is the ssh_keepalive interval in seconds.
+const defaultKeepAlive = 30
+
+// loadSSHConfig reads ssh_config_file (default ~/.ssh/config); "none"
+// disables ssh_config lookups.
+func loadSSHConfig(vars map[string]any) (*conn.SSHConfig, error) {
+	file := expandHome(stringVar(vars, "ssh_config_file"))
+	switch file {
+	case "none":
+		return &conn.SSHConfig{}, nil
+	case "":
+		file = conn.DefaultSSHConfigFile()
+	}
+	c, err := conn.LoadSSHConfig(file)
+	if err != nil {
+		return nil, fmt.Errorf("ssh config %s: %w", file, err)
+	}
+	return c, nil
+}
+
+// maxJumpDepth bounds ProxyJump chains followed through ssh_config.
+const maxJumpDepth = 8
+
+// jumpHops turns proxy_jump into dial configs for each hop. v is a
+// comma-separated "[user@]host[:port]" string, or a list of such strings
+// and maps with host, port, user and ssh_private_key_file. Hop settings not
+// given fall back to the hop's ssh_config block, then to the target's user
+// and keys; auth and host key options are inherited from the target.
+func jumpHops(v any, sshCfg *conn.SSHConfig, target conn.Config, keys []string, passphrase string) ([]conn.Config, error) {
+	var specs []jumpSpec
+	switch t := v.(type) {
+	case nil:
+	case string:
+		for _, s := range strings.Split(t, ",") {
+			if s = strings.TrimSpace(s); s == "" || s == "none" {
+				continue
+			}
+			sp, err := parseJump(s)
+			if err != nil {
+				return nil, err
+			}
+			specs = append(specs, sp)
+		}
+	case []any:
+		for _, e := range t {
+			switch hv := e.(type) {
+			case string:
+				sp, err := parseJump(hv)
+				if err != nil {
+					return nil, err
+				}
+				specs = append(specs, sp)
+			case map[string]any:
+				sp := jumpSpec{host: stringVar(hv, "host"), user: stringVar(hv, "user"), port: intVar(hv, "port"), keys: pathList(hv["ssh_private_key_file"])}
+				if sp.host == "" {
+					return nil, fmt.Errorf("hop without host: %v", hv)
+				}
+				specs = append(specs, sp)
+			default:
+				return nil, fmt.Errorf("unsupported hop %v", e)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("want a string or list, got %T", v)
+	}
+	// the first hop may itself be reached through its own ProxyJump
+	for depth := 0; len(specs) > 0; depth++ {
+		pj := sshCfg.Lookup(specs[0].host).ProxyJump
+		if pj == "" {
+			break
+		}
+		if depth == maxJumpDepth {
+			return nil, fmt.Errorf("ProxyJump chain from ssh config deeper than %d hops", maxJumpDepth)
+		}
+		var pre []jumpSpec
+		for _, s := range strings.Split(pj, ",") {
+			sp, err := parseJump(strings.TrimSpace(s))
+			if err != nil {
+				return nil, err
+			}
+			pre = append(pre, sp)
+		}
+		specs = append(pre, specs...)
+	}
+	var out []conn.Config
+	for _, sp := range specs {
+		hc := sshCfg.Lookup(sp.host)
+		hop := target
+		hop.Jump = nil
+		hop.Password = ""
+		hop.Addr = sp.host
+		if hc.HostName != "" {
+			hop.Addr = hc.HostName
+		}
+		hop.Port = sp.port
+		if hop.Port == 0 {
+			hop.Port = hc.Port
+		}
+		hop.User = firstNonEmpty(sp.user, hc.User, target.User)
+		hopKeys := sp.keys
+		if len(hopKeys) == 0 {
+			hopKeys = hc.IdentityFiles
+		}
+		if len(hopKeys) == 0 {
+			hopKeys = keys
+		}
+		hop.Identities = identities(hopKeys, passphrase)
+		out = append(out, hop)
+	}
+	return out, nil
+}
+
+type jumpSpec struct {
+	user, host string
+	port       int
+	keys       []string
+}
+
+// parseJump parses "[ssh://][user@]host[:port]"; IPv6 hosts need brackets.
+func parseJump(s string) (jumpSpec, error) {
+	var sp jumpSpec
+	s = strings.TrimPrefix(s, "ssh://")
+	if i := strings.LastIndex(s, "@"); i >= 0 {
+		sp.user, s = s[:i], s[i+1:]
+	}
+	sp.host = s
+	if strings.HasPrefix(s, "[") || strings.Count(s, ":") == 1 {
+		host, port, err := net.SplitHostPort(s)
+		if err != nil {
+			return sp, fmt.Errorf("hop %q: %w", s, err)
+		}
+		sp.host = host
+		if sp.port, err = strconv.Atoi(port); err != nil {
+			return sp, fmt.Errorf("hop %q: bad port", s)
+		}
+	}
+	if sp.host == "" {
+		return sp, fmt.Errorf("hop %q: missing host", s)
+	}
+	return sp, nil
+}
+
+func identities(keys []string, passphrase string) []conn.Identity {
+	var ids []conn.Identity
+	for _, p := range keys {
+		ids = append(ids, conn.Identity{Path: p, Passphrase: []byte(passphrase)})
+	}
+	return ids
+}
+
+// pathList reads a path or list of paths, expanding env vars and ~.
+func pathList(v any) []string {
+	var out []string
+	switch t := v.(type) {
+	case string:
+		out = append(out, expandHome(os.ExpandEnv(t)))
+	case []any:
+		for _, e := range t {
+			if s, ok := e.(string); ok {
+				out = append(out, expandHome(os.ExpandEnv(s)))
+			}
+		}
+	}
+	return out
+}
+
+// defaultIdentityFiles returns the default OpenSSH identities that exist.
+func defaultIdentityFiles() []string {
+	var out []string
+	for _, name := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+		p := filepath.Join(os.Getenv("HOME"), ".ssh", name)
+		if _, err := os.Stat(p); err == nil {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+func intVar(vars map[string]any, key string) int {
+	switch v := vars[key].(type) {
+	case int:
+		return v
+	case float64:
+		return int(v)
+	case string:
+		n, _ := strconv.Atoi(os.ExpandEnv(v))
+		return n
+	}
+	return 0
+}
+
+func firstNonEmpty(ss ...string) string {
+	for _, s := range ss {
+		if s != "" {
+			return s
+		}
+	}
+	return ""
+}
+
+// secretVar returns a string var, decrypting inline vault values
+// ($GOPSI_VAULT;...) with the run's vault password.
+func (r *Runner) secretVar(vars map[string]any, key string) (string, error) {
+	v, _ := vars[key].(string)
+	if !vault.IsEncrypted(v) {
+		return stringVar(vars, key), nil
+	}
+	if len(r.vaultPass) == 0 {
+		return "", fmt.Errorf("%s is vault-encrypted but no vault password was given", key)
+	}
+	s, err := vault.DecryptString(v, r.vaultPass)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", key, err)
+	}
+	return s, nil
+}
+
+// ask prompts once per distinct prompt and reuses the answer for every host.
+// Without a prompter (non-interactive runs) it fails.
+func (r *Runner) ask(prompt string) (string, error) {
+	r.promptMu.Lock()
+	defer r.promptMu.Unlock()
+	if s, ok := r.answers[prompt]; ok {
+		return s, nil
+	}
+	if r.prompt == nil {
+		return "", fmt.Errorf("cannot prompt %q: not interactive", prompt)
+	}
+	s, err := r.prompt(prompt)
+	if err != nil {
+		return "", err
+	}
+	if r.answers == nil {
+		r.answers = map[string]string{}
+	}
+	r.answers[prompt] = s
+	return s, nil
+}
+
+func boolVar(vars map[string]any, key string, def bool) bool {
+	switch v := vars[key].(type) {
+	case bool:
+		return v
+	case string:
+		switch v {
+		case "true", "yes", "1":
+			return true
+		case "false", "no", "0":
+			return false
+		}
+	}
+	return def
+}
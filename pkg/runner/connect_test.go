@@ -0,0 +1,51 @@
+package runner
+
+import (
+	"strings"
+	"testing"
+
+	"gopsi/pkg/conn"
+)
+
+func TestJumpHops(t *testing.T) {
+	sshCfg, err := conn.ParseSSHConfig(strings.NewReader(`
+Host edge
+    HostName 203.0.113.7
+    User edgeuser
+Host inner
+    ProxyJump edge
+    Port 2200
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := conn.Config{User: "deploy", Addr: "10.1.0.9", Password: "pw"}
+
+	hops, err := jumpHops("ops@inner,[2001:db8::1]:2022", sshCfg, target, []string{"/k/target"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, h := range hops {
+		got = append(got, h.User+"@"+h.HostPort())
+		if h.Password != "" {
+			t.Fatalf("password leaked to hop %s", h.Addr)
+		}
+	}
+	want := "edgeuser@203.0.113.7:22 ops@inner:2200 deploy@[2001:db8::1]:2022"
+	if strings.Join(got, " ") != want {
+		t.Fatalf("hops = %v, want %s", got, want)
+	}
+
+	hops, err = jumpHops([]any{map[string]any{"host": "b1", "user": "u1", "port": 2222, "ssh_private_key_file": "/k/b1"}, "b2"}, &conn.SSHConfig{}, target, []string{"/k/target"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hops) != 2 || hops[0].Identities[0].Path != "/k/b1" || hops[1].Identities[0].Path != "/k/target" || hops[1].User != "deploy" {
+		t.Fatalf("unexpected hops %+v", hops)
+	}
+
+	if _, err := jumpHops([]any{map[string]any{"user": "x"}}, &conn.SSHConfig{}, target, nil, ""); err == nil {
+		t.Fatal("expected error for hop without host")
+	}
+}
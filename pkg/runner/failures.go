@@ -0,0 +1,110 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopsi/pkg/conn"
+)
+
+// errAborted stops the remaining tasks of a host once its play has been
+// aborted by any_errors_fatal or max_fail_percentage.
+var errAborted = errors.New("play aborted")
+
+// unreachableError marks a host whose connection could not be opened or
+// dropped during the play.
+type unreachableError struct{ err error }
+
+func (e unreachableError) Error() string { return e.err.Error() }
+func (e unreachableError) Unwrap() error { return e.err }
+
+// isUnreachable tells a host that could not be connected to, or whose
+// connection dropped during a task, from one whose task failed.
+func isUnreachable(err error) bool {
+	return errors.As(err, new(unreachableError)) || conn.IsTransport(err)
+}
+
+// HostFailures is returned by Run when hosts failed or could not be
+// reached. Those hosts are left out of later plays; the others ran to the
+// end unless Aborted says why the run was cut short.
+type HostFailures struct {
+	Failed      map[string]error
+	Unreachable map[string]error
+	Aborted     string
+}
+
+func (e *HostFailures) Error() string {
+	var lines []string
+	for _, group := range []struct {
+		label string
+		errs  map[string]error
+	}{{"UNREACHABLE", e.Unreachable}, {"FAILED", e.Failed}} {
+		hosts := make([]string, 0, len(group.errs))
+		for h := range group.errs {
+			hosts = append(hosts, h)
+		}
+		sort.Strings(hosts)
+		for _, h := range hosts {
+			msg := group.errs[h].Error()
+			if !strings.HasPrefix(msg, h+":") {
+				msg = h + ": " + msg
+			}
+			lines = append(lines, group.label+" "+msg)
+		}
+	}
+	if e.Aborted != "" {
+		lines = append(lines, "run aborted: "+e.Aborted)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// recordFailure files a host's play error as failed or unreachable.
+func (r *Runner) recordFailure(host string, err error) {
+	unreachable := isUnreachable(err)
+	r.tally(host, func(s *hostStats) {
+		if unreachable {
+			s.Unreachable++
+		} else {
+			s.Failed++
+		}
+	})
+	r.statsMu.Lock()
+	defer r.statsMu.Unlock()
+	if unreachable {
+		r.unreachable[host] = err
+		return
+	}
+	r.failed[host] = err
+}
+
+// down reports whether the host failed or was unreachable in an earlier play.
+func (r *Runner) down(host string) bool {
+	r.statsMu.Lock()
+	defer r.statsMu.Unlock()
+	return r.failed[host] != nil || r.unreachable[host] != nil
+}
+
+// hostFailures returns the run's outcome as Run's error, nil when every host
+// came through.
+func (r *Runner) hostFailures(aborted string) error {
+	if len(r.failed) == 0 && len(r.unreachable) == 0 && aborted == "" {
+		return nil
+	}
+	return &HostFailures{Failed: r.failed, Unreachable: r.unreachable, Aborted: aborted}
+}
+
+// abortReason tells whether a play must be aborted now that failed of its
+// total hosts have failed.
+func abortReason(anyFatal bool, maxPct *float64, failed, total int) string {
+	switch {
+	case failed == 0:
+		return ""
+	case anyFatal:
+		return "any_errors_fatal"
+	case maxPct != nil && float64(failed)*100 > *maxPct*float64(total):
+		return fmt.Sprintf("max_fail_percentage %g exceeded (%d of %d hosts failed)", *maxPct, failed, total)
+	}
+	return ""
+}
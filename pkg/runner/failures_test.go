@@ -0,0 +1,58 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestAbortReason(t *testing.T) {
+	pct := func(f float64) *float64 { return &f }
+	cases := []struct {
+		anyFatal      bool
+		maxPct        *float64
+		failed, total int
+		abort         bool
+	}{
+		{false, nil, 3, 4, false},
+		{true, nil, 0, 4, false},
+		{true, nil, 1, 4, true},
+		{false, pct(25), 1, 4, false},
+		{false, pct(25), 2, 4, true},
+		{false, pct(0), 1, 10, true},
+		{false, pct(33.3), 1, 3, true},
+	}
+	for _, c := range cases {
+		if got := abortReason(c.anyFatal, c.maxPct, c.failed, c.total); (got != "") != c.abort {
+			t.Errorf("%+v: got %q", c, got)
+		}
+	}
+}
+
+func TestHostFailures(t *testing.T) {
+	r := &Runner{failed: map[string]error{}, unreachable: map[string]error{}}
+	if err := r.hostFailures(""); err != nil {
+		t.Fatalf("clean run: %v", err)
+	}
+	r.recordFailure("web2", errors.New(`web2: task "deploy" failed`))
+	r.recordFailure("db1", unreachableError{errors.New("db1: dial tcp: i/o timeout")})
+	r.recordFailure("web1", errors.New("connection reset"))
+	r.recordFailure("web3", &taskError{err: fmt.Errorf("web3: task %q: %w", "deploy", io.EOF)})
+	if !r.down("db1") || !r.down("web2") || !r.down("web3") || r.down("web4") {
+		t.Fatal("down hosts not tracked")
+	}
+	err := r.hostFailures("")
+	var hf *HostFailures
+	if !errors.As(err, &hf) || len(hf.Failed) != 2 || len(hf.Unreachable) != 2 {
+		t.Fatalf("failures: %#v", err)
+	}
+	want := "UNREACHABLE db1: dial tcp: i/o timeout\nUNREACHABLE web3: task \"deploy\": EOF\nFAILED web1: connection reset\nFAILED web2: task \"deploy\" failed"
+	if err.Error() != want {
+		t.Fatalf("message:\n%s\nwant:\n%s", err, want)
+	}
+	if msg := r.hostFailures("any_errors_fatal").Error(); !strings.HasSuffix(msg, "run aborted: any_errors_fatal") {
+		t.Fatalf("aborted message: %s", msg)
+	}
+}
@@ -0,0 +1,136 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopsi/pkg/inventory"
+	"gopsi/pkg/module"
+	"gopsi/pkg/play"
+)
+
+func TestHandlers(t *testing.T) {
+	var ran []string
+	module.Register(stepMod{ran: &ran})
+	step := func(name string, fail bool, notify ...string) play.Task {
+		return play.Task{Name: name, Module: "step", Args: map[string]any{"_": name, "fail": fail}, Notify: notify}
+	}
+	pl := play.Play{Handlers: []play.Task{
+		step("reload config", false),
+		step("restart app", false, "reload config"),
+		step("clear cache", false),
+	}}
+	newHost := func() *hostRun {
+		ran = nil
+		return &hostRun{host: inventory.Host{Name: "web1"}, vars: map[string]any{}, notified: map[string]bool{}}
+	}
+	ctx := context.Background()
+	r := &Runner{}
+
+	// deduplicated, declaration order, and a handler notifying one declared before it
+	hr := newHost()
+	if err := r.runTasks(ctx, hr, pl, []play.Task{
+		step("a", false, "clear cache", "restart app"),
+		step("b", false, "restart app", "clear cache"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.flushHandlers(ctx, hr, pl); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a", "b", "restart app", "clear cache", "reload config"}; !reflect.DeepEqual(ran, want) {
+		t.Fatalf("ran %v, want %v", ran, want)
+	}
+	if len(hr.notified) != 0 {
+		t.Fatalf("handlers left queued: %v", hr.notified)
+	}
+
+	// meta: flush_handlers runs queued handlers mid-play
+	hr = newHost()
+	if err := r.runTasks(ctx, hr, pl, []play.Task{
+		step("a", false, "clear cache"),
+		{Module: "meta", Args: map[string]any{"_": "flush_handlers"}},
+		step("b", false),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a", "clear cache", "b"}; !reflect.DeepEqual(ran, want) {
+		t.Fatalf("ran %v, want %v", ran, want)
+	}
+
+	// meta tasks honor when
+	hr = newHost()
+	hr.vars["flush"] = false
+	if err := r.runTasks(ctx, hr, pl, []play.Task{
+		step("a", false, "clear cache"),
+		{Module: "meta", Args: map[string]any{"_": "flush_handlers"}, When: []string{"flush"}},
+		step("b", false),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a", "b"}; !reflect.DeepEqual(ran, want) {
+		t.Fatalf("ran %v, want %v", ran, want)
+	}
+
+	// meta tasks ignore tag selection
+	hr = newHost()
+	tagged := func(t play.Task) play.Task { t.Tags = []string{"deploy"}; return t }
+	if err := (&Runner{onlyTags: []string{"deploy"}}).runTasks(ctx, hr, pl, []play.Task{
+		tagged(step("a", false, "clear cache")),
+		{Module: "meta", Args: map[string]any{"_": "flush_handlers"}},
+		step("untagged", false),
+		tagged(step("b", false)),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a", "clear cache", "b"}; !reflect.DeepEqual(ran, want) {
+		t.Fatalf("ran %v, want %v", ran, want)
+	}
+
+	// --force-handlers runs handlers after a failure; without it they are dropped
+	for _, force := range []bool{false, true} {
+		hr = newHost()
+		r := &Runner{forceHandlers: force}
+		err := r.runTasks(ctx, hr, pl, []play.Task{step("a", false, "clear cache"), step("b", true)})
+		if err == nil {
+			t.Fatal("expected task failure")
+		}
+		if err := r.failHost(ctx, hr, pl, err); err == nil || !strings.Contains(err.Error(), "b broke") {
+			t.Fatalf("failHost should return the task error: %v", err)
+		}
+		want := []string{"a", "b"}
+		if force {
+			want = append(want, "clear cache")
+		}
+		if !reflect.DeepEqual(ran, want) {
+			t.Fatalf("force=%v: ran %v, want %v", force, ran, want)
+		}
+	}
+
+	// notifying an unknown handler is an error
+	hr = newHost()
+	err := r.runTasks(ctx, hr, pl, []play.Task{step("a", false, "no such handler")})
+	var te *taskError
+	if !errors.As(err, &te) || !strings.Contains(err.Error(), `unknown handler "no such handler"`) {
+		t.Fatalf("unknown handler: %v", err)
+	}
+}
+
+func TestHandlerCycle(t *testing.T) {
+	var ran []string
+	module.Register(stepMod{ran: &ran})
+	h := func(name, notify string) play.Task {
+		return play.Task{Name: name, Module: "step", Args: map[string]any{"_": name}, Notify: []string{notify}}
+	}
+	pl := play.Play{Handlers: []play.Task{h("a", "b"), h("b", "a")}}
+	hr := &hostRun{host: inventory.Host{Name: "web1"}, vars: map[string]any{}, notified: map[string]bool{"b": true}}
+	if err := (&Runner{}).flushHandlers(context.Background(), hr, pl); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"b", "a"}; !reflect.DeepEqual(ran, want) {
+		t.Fatalf("ran %v, want %v", ran, want)
+	}
+}
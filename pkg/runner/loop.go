@@ -0,0 +1,172 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"gopsi/pkg/eval"
+	"gopsi/pkg/module"
+	"gopsi/pkg/play"
+)
+
+// runLoop runs a `loop`/`with_items` task once per item. Each item gets its
+// own `when` evaluation and result line; the registered value has the usual
+// shape plus `results`, one registered value per item. The task counts as
+// changed if any item changed. changed_when, failed_when and retries apply
+// per item; the first failing item stops the loop.
+func (r *Runner) runLoop(ctx context.Context, hr *hostRun, pl play.Play, t play.Task) (module.Result, error) {
+	h := hr.host
+	base := taskVars(hr.vars, t)
+	items, err := loopItems(t, base)
+	if err != nil {
+		err = fmt.Errorf("%s: task %q loop: %w", h.Name, t.DisplayName(), err)
+		if t.Register != "" {
+			hr.vars[t.Register] = registerValue(module.Result{}, false, err)
+		}
+		return module.Result{}, err
+	}
+	lc := t.LoopControl
+	loopVar := lc.LoopVar
+	if loopVar == "" {
+		loopVar = "item"
+	}
+	results := make([]any, 0, len(items))
+	agg := module.Result{}
+	skipped := true
+	var runErr error
+	for i, item := range items {
+		if i > 0 && lc.Pause > 0 {
+			select {
+			case <-ctx.Done():
+				runErr = ctx.Err()
+			case <-time.After(time.Duration(lc.Pause * float64(time.Second))):
+			}
+			if runErr != nil {
+				break
+			}
+		}
+		vars := make(map[string]any, len(base)+2)
+		for k, v := range base {
+			vars[k] = v
+		}
+		vars[loopVar] = item
+		if lc.IndexVar != "" {
+			vars[lc.IndexVar] = i
+		}
+		label := r.loopLabel(lc.Label, item, vars)
+		res, itemSkipped, attempts, err := r.attempt(ctx, hr, pl, t, vars)
+		rv := registerValue(res, itemSkipped, err)
+		if !itemSkipped {
+			rv["attempts"] = attempts
+		}
+		rv["item"] = item
+		rv["label"] = label
+		results = append(results, rv)
+		if err != nil {
+			r.verbosef(1, colorRed(fmt.Sprintf("%s item error %s item=%s %v", h.Name, t.Name, label, err)))
+			runErr = err
+			break
+		}
+		if itemSkipped {
+			continue
+		}
+		skipped = false
+		r.printItem(h.Name, t.Name, label, res, r.check)
+		if res.Changed {
+			agg.Changed = true
+		}
+	}
+	r.countTask(h.Name, agg, skipped && runErr == nil, t.IgnoreErrors, runErr)
+	agg.Msg = fmt.Sprintf("%d items", len(results))
+	agg.Data = map[string]any{"results": results}
+	if t.Register != "" {
+		rv := registerValue(agg, skipped && runErr == nil, runErr)
+		rv["results"] = results
+		hr.vars[t.Register] = rv
+	}
+	if runErr != nil && t.IgnoreErrors {
+		r.ignoreFailure(hr, t, runErr)
+		return module.Result{}, nil
+	}
+	if runErr != nil {
+		return agg, runErr
+	}
+	if skipped {
+		return agg, nil
+	}
+	r.printColored(h.Name, t.Name, agg, r.check)
+	if r.verbosity > 0 {
+		r.verbosef(1, "")
+	}
+	return agg, r.notify(hr, pl, t, agg)
+}
+
+// loopItems resolves the task's loop value. A string is an expression over
+// the host vars (`loop: packages` or `loop: "{{ .packages }}"`); a map loops
+// over its entries as {key, value} sorted by key; with_items flattens nested
+// lists one level.
+func loopItems(t play.Task, vars map[string]any) ([]any, error) {
+	v := t.Loop
+	if s, ok := v.(string); ok {
+		expr := strings.TrimSpace(s)
+		if strings.HasPrefix(expr, "{{") && strings.HasSuffix(expr, "}}") {
+			expr = strings.TrimPrefix(strings.TrimSpace(expr[2:len(expr)-2]), ".")
+		}
+		val, err := eval.Eval(expr, vars)
+		if err != nil {
+			return nil, err
+		}
+		v = val
+	}
+	var items []any
+	rv := reflect.ValueOf(v)
+	switch {
+	case v == nil:
+	case rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			items = append(items, rv.Index(i).Interface())
+		}
+	case rv.Kind() == reflect.Map:
+		keys := make([]string, 0, rv.Len())
+		byKey := map[string]any{}
+		for _, k := range rv.MapKeys() {
+			ks := fmt.Sprintf("%v", k.Interface())
+			keys = append(keys, ks)
+			byKey[ks] = rv.MapIndex(k).Interface()
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			items = append(items, map[string]any{"key": k, "value": byKey[k]})
+		}
+	default:
+		return nil, fmt.Errorf("loop needs a list or map, got %T", v)
+	}
+	if t.WithItems {
+		var flat []any
+		for _, it := range items {
+			if sub, ok := it.([]any); ok {
+				flat = append(flat, sub...)
+			} else {
+				flat = append(flat, it)
+			}
+		}
+		items = flat
+	}
+	return items, nil
+}
+
+// loopLabel renders loop_control.label, or prints the item itself.
+func (r *Runner) loopLabel(label string, item any, vars map[string]any) string {
+	if label != "" {
+		return r.renderString(label, vars)
+	}
+	s := fmt.Sprintf("%v", item)
+	if len(s) > 80 {
+		s = s[:80] + "..."
+	}
+	return s
+}
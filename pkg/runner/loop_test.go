@@ -0,0 +1,55 @@
+package runner
+
+import (
+	"reflect"
+	"testing"
+
+	"gopsi/pkg/play"
+)
+
+func TestLoopItems(t *testing.T) {
+	vars := map[string]any{
+		"pkgs":  []any{"tmux", "vim"},
+		"users": map[string]any{"bob": 2, "alice": 1},
+	}
+	cases := []struct {
+		task play.Task
+		want []any
+	}{
+		{play.Task{Loop: []any{"a", "b"}}, []any{"a", "b"}},
+		{play.Task{Loop: "pkgs"}, []any{"tmux", "vim"}},
+		{play.Task{Loop: "{{ .pkgs }}"}, []any{"tmux", "vim"}},
+		{play.Task{Loop: "users"}, []any{
+			map[string]any{"key": "alice", "value": 1},
+			map[string]any{"key": "bob", "value": 2},
+		}},
+		{play.Task{Loop: []any{"a", []any{"b", "c"}}, WithItems: true}, []any{"a", "b", "c"}},
+	}
+	for _, c := range cases {
+		got, err := loopItems(c.task, vars)
+		if err != nil {
+			t.Fatalf("%v: %v", c.task.Loop, err)
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%v: got %v want %v", c.task.Loop, got, c.want)
+		}
+	}
+	if _, err := loopItems(play.Task{Loop: "missing"}, vars); err == nil {
+		t.Fatal("expected error for undefined loop variable")
+	}
+}
+
+func TestRenderArgs(t *testing.T) {
+	r := &Runner{}
+	args := map[string]any{"name": "{{ .item }}", "opts": []any{"-x {{ .item }}"}, "literal": "{{ workdir }}", "n": 3}
+	got := r.renderArgs("package", args, map[string]any{"item": "tmux"})
+	if got["name"] != "tmux" || got["opts"].([]any)[0] != "-x tmux" {
+		t.Fatalf("not rendered: %v", got)
+	}
+	if got["literal"] != "{{ workdir }}" || got["n"] != 3 {
+		t.Fatalf("unexpected rewrite: %v", got)
+	}
+	if args["name"] != "{{ .item }}" {
+		t.Fatal("task args were modified in place")
+	}
+}
@@ -0,0 +1,90 @@
+package runner
+
+import (
+	"fmt"
+	"strings"
+
+	"gopsi/pkg/eval"
+	"gopsi/pkg/module"
+	"gopsi/pkg/play"
+)
+
+// outcome applies the task's changed_when and failed_when to one module run
+// (or loop item). Both see the registered value as described at
+// resultScope.
+// As in Ansible, a non-zero `exit` artifact (command, shell, ...) fails the
+// run. failed_when replaces the module's verdict entirely: a module error or
+// non-zero rc whose failed_when is false succeeds.
+func (r *Runner) outcome(hr *hostRun, t play.Task, vars map[string]any, res module.Result, skipped bool, err error) (module.Result, error) {
+	if skipped {
+		return res, nil
+	}
+	if rc, ok := res.Artifacts["exit"].(int); ok && rc != 0 && err == nil {
+		err = fmt.Errorf("%s: task %q: non-zero return code %d", hr.host.Name, t.DisplayName(), rc)
+		if stderr, _ := res.Artifacts["stderr"].(string); strings.TrimSpace(stderr) != "" {
+			err = fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr))
+		}
+	}
+	if len(t.ChangedWhen) > 0 || len(t.FailedWhen) > 0 {
+		scope, name := resultScope(t, vars)
+		scope[name] = registerValue(res, false, err)
+		if len(t.ChangedWhen) > 0 {
+			changed, cerr := eval.All(t.ChangedWhen, scope)
+			if cerr != nil {
+				return res, fmt.Errorf("%s: task %q changed_when: %w", hr.host.Name, t.DisplayName(), cerr)
+			}
+			res.Changed = changed
+			scope[name] = registerValue(res, false, err)
+		}
+		if len(t.FailedWhen) > 0 {
+			failed, ferr := eval.All(t.FailedWhen, scope)
+			switch {
+			case ferr != nil:
+				err = fmt.Errorf("%s: task %q failed_when: %w", hr.host.Name, t.DisplayName(), ferr)
+			case failed && err == nil:
+				err = fmt.Errorf("%s: task %q failed_when matched: %s", hr.host.Name, t.DisplayName(), strings.Join(t.FailedWhen, " and "))
+			case !failed && err != nil:
+				r.verbosef(2, "FAILED_WHEN [%s] host=%s false, ignoring error: %v", t.DisplayName(), hr.host.Name, err)
+				err = nil
+			}
+		}
+	}
+	return res, err
+}
+
+// resultScope copies vars for evaluating a task's result conditions and
+// returns the name the registered value goes under: the task's `register`
+// name, or `result` without one.
+func resultScope(t play.Task, vars map[string]any) (map[string]any, string) {
+	name := t.Register
+	if name == "" {
+		name = "result"
+	}
+	scope := make(map[string]any, len(vars)+1)
+	for k, v := range vars {
+		scope[k] = v
+	}
+	return scope, name
+}
+
+// ignoreFailure reports a failed task whose ignore_errors is set; the host
+// carries on as if it had succeeded without a change.
+func (r *Runner) ignoreFailure(hr *hostRun, t play.Task, err error) {
+	r.printFailed(hr.host.Name, t.Name, err, "ignored")
+	if !r.json {
+		fmt.Println(colorCyan(fmt.Sprintf("%s | %s | ...ignoring", hr.host.Name, t.Name)))
+	}
+	if r.verbosity > 0 {
+		r.verbosef(1, "")
+	}
+}
+
+// printFailed reports a failure the host recovers from; how it recovers
+// ("ignored", "rescued") is a flag in JSON output.
+func (r *Runner) printFailed(host, name string, err error, how string) {
+	if r.json {
+		fmt.Printf("{\"host\":%q,\"task\":%q,\"changed\":false,\"failed\":true,%q:true,\"check\":%v,\"msg\":%q}\n", host, name, how, r.check, err.Error())
+		return
+	}
+	fmt.Println(colorRed(fmt.Sprintf("%s | %s | failed: %v", host, name, err)))
+}
@@ -0,0 +1,70 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"gopsi/pkg/inventory"
+	"gopsi/pkg/module"
+	_ "gopsi/pkg/modules/command"
+	"gopsi/pkg/play"
+)
+
+func TestOutcome(t *testing.T) {
+	r := &Runner{}
+	hr := &hostRun{host: inventory.Host{Name: "web1"}}
+	vars := map[string]any{"ok_codes": []any{0, 1}}
+	exit := func(rc int) module.Result {
+		return module.Result{Changed: rc == 0, Artifacts: map[string]any{"exit": rc, "stdout": "ALREADY DONE"}}
+	}
+	cases := []struct {
+		name        string
+		task        play.Task
+		res         module.Result
+		err         error
+		wantChanged bool
+		wantErr     bool
+	}{
+		{"no conditions", play.Task{}, exit(0), nil, true, false},
+		{"module error", play.Task{}, module.Result{}, errors.New("boom"), false, true},
+		{"non-zero rc fails", play.Task{}, exit(1), nil, false, true},
+		{"negative rc fails", play.Task{}, exit(-1), nil, false, true},
+		{"failed_when sees rc failure", play.Task{Register: "out", FailedWhen: []string{"out is failed and out.rc != 3"}}, exit(3), nil, false, false},
+		{"rc in allowed list", play.Task{Register: "out", FailedWhen: []string{"out.rc not in ok_codes"}}, exit(1), nil, false, false},
+		{"rc outside allowed list", play.Task{Register: "out", FailedWhen: []string{"out.rc not in ok_codes"}}, exit(2), nil, false, true},
+		{"failed_when false clears error", play.Task{FailedWhen: []string{"false"}}, module.Result{}, errors.New("boom"), false, false},
+		{"changed_when on stdout", play.Task{ChangedWhen: []string{`"ALREADY" not in result.stdout`}}, exit(0), nil, false, false},
+		{"failed_when sees changed_when", play.Task{ChangedWhen: []string{"false"}, FailedWhen: []string{"result is changed"}}, exit(0), nil, false, false},
+		{"bad expression", play.Task{FailedWhen: []string{"result.rc >"}}, exit(0), nil, true, true},
+	}
+	for _, c := range cases {
+		res, err := r.outcome(hr, c.task, vars, c.res, false, c.err)
+		if res.Changed != c.wantChanged || (err != nil) != c.wantErr {
+			t.Errorf("%s: changed=%v err=%v", c.name, res.Changed, err)
+		}
+	}
+	if _, err := r.outcome(hr, play.Task{FailedWhen: []string{"true"}}, vars, module.Result{}, true, nil); err != nil {
+		t.Errorf("skipped task should not be judged: %v", err)
+	}
+}
+
+func TestCommandNonZeroRC(t *testing.T) {
+	run := func(task play.Task) (*hostRun, error) {
+		hr := &hostRun{host: inventory.Host{Name: "web1"}, conn: &memConn{rc: 2}, vars: map[string]any{}, notified: map[string]bool{}}
+		return hr, (&Runner{}).runTasks(context.Background(), hr, play.Play{}, []play.Task{task})
+	}
+	task := play.Task{Name: "probe", Module: "command", Args: map[string]any{"_": "false"}, Register: "out"}
+	if _, err := run(task); err == nil {
+		t.Fatal("rc 2 should fail the host")
+	}
+	task.IgnoreErrors = true
+	hr, err := run(task)
+	if err != nil {
+		t.Fatalf("ignore_errors should let the host go on: %v", err)
+	}
+	out := hr.vars["out"].(map[string]any)
+	if out["failed"] != true || out["rc"] != 2 || out["changed"] != false {
+		t.Fatalf("registered %v", out)
+	}
+}
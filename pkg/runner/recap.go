@@ -0,0 +1,175 @@
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"gopsi/pkg/module"
+	"gopsi/pkg/play"
+)
+
+// slowestTasks is how many rows the timing table shows.
+const slowestTasks = 10
+
+// hostStats are a host's PLAY RECAP counters. Ok includes changed tasks;
+// a failure that was rescued or ignored is counted as such, not as failed.
+// Loops count once, like any other task.
+type hostStats struct {
+	Ok          int `json:"ok"`
+	Changed     int `json:"changed"`
+	Unreachable int `json:"unreachable"`
+	Failed      int `json:"failed"`
+	Skipped     int `json:"skipped"`
+	Rescued     int `json:"rescued"`
+	Ignored     int `json:"ignored"`
+}
+
+// taskTiming is how long a task took, over the hosts that ran it.
+type taskTiming struct {
+	Task  string        `json:"task"`
+	Hosts int           `json:"hosts"`
+	Max   time.Duration `json:"-"`
+	Total time.Duration `json:"-"`
+}
+
+// tally updates the counters of host, creating them on first use.
+func (r *Runner) tally(host string, fn func(s *hostStats)) {
+	r.statsMu.Lock()
+	defer r.statsMu.Unlock()
+	if r.stats == nil {
+		r.stats = map[string]*hostStats{}
+	}
+	s := r.stats[host]
+	if s == nil {
+		s = &hostStats{}
+		r.stats[host] = s
+	}
+	fn(s)
+}
+
+// countTask records the outcome of a task (or whole loop) on host.
+func (r *Runner) countTask(host string, res module.Result, skipped, ignored bool, err error) {
+	r.tally(host, func(s *hostStats) {
+		switch {
+		case skipped:
+			s.Skipped++
+		case err != nil && ignored:
+			s.Ignored++
+		case err == nil:
+			s.Ok++
+			if res.Changed {
+				s.Changed++
+			}
+		}
+	})
+}
+
+// timeTask adds one host's run of a task to the timing table. Tasks are
+// told apart by ID, so same-named tasks of different plays get their own
+// rows; tasks built without one (in code) fall back to their name.
+func (r *Runner) timeTask(t play.Task, d time.Duration) {
+	r.statsMu.Lock()
+	defer r.statsMu.Unlock()
+	if r.timings == nil {
+		r.timings = map[string]*taskTiming{}
+	}
+	key := t.ID
+	if key == "" {
+		key = t.DisplayName()
+	}
+	tt := r.timings[key]
+	if tt == nil {
+		tt = &taskTiming{Task: t.DisplayName()}
+		r.timings[key] = tt
+	}
+	tt.Hosts++
+	tt.Total += d
+	if d > tt.Max {
+		tt.Max = d
+	}
+}
+
+// slowest returns up to n tasks, slowest host run first.
+func (r *Runner) slowest(n int) []taskTiming {
+	list := make([]taskTiming, 0, len(r.timings))
+	for _, tt := range r.timings {
+		list = append(list, *tt)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Max != list[j].Max {
+			return list[i].Max > list[j].Max
+		}
+		return list[i].Task < list[j].Task
+	})
+	if len(list) > n {
+		list = list[:n]
+	}
+	return list
+}
+
+// printRecap prints the PLAY RECAP, one line per host, and the slowest
+// tasks; with --json it prints them as a single `recap` record instead.
+func (r *Runner) printRecap(dur time.Duration) {
+	r.statsMu.Lock()
+	defer r.statsMu.Unlock()
+	hosts := make([]string, 0, len(r.stats))
+	for h := range r.stats {
+		hosts = append(hosts, h)
+	}
+	sort.Strings(hosts)
+	slow := r.slowest(slowestTasks)
+	if r.json {
+		type timing struct {
+			taskTiming
+			MaxSeconds   float64 `json:"max_seconds"`
+			TotalSeconds float64 `json:"total_seconds"`
+		}
+		rec := struct {
+			Recap    map[string]*hostStats `json:"recap"`
+			Slowest  []timing              `json:"slowest_tasks"`
+			Duration float64               `json:"duration_seconds"`
+		}{Recap: r.stats, Slowest: []timing{}, Duration: dur.Seconds()}
+		if rec.Recap == nil {
+			rec.Recap = map[string]*hostStats{}
+		}
+		for _, tt := range slow {
+			rec.Slowest = append(rec.Slowest, timing{tt, tt.Max.Seconds(), tt.Total.Seconds()})
+		}
+		b, _ := json.Marshal(rec)
+		fmt.Println(string(b))
+		return
+	}
+	width := 0
+	for _, h := range hosts {
+		if len(h) > width {
+			width = len(h)
+		}
+	}
+	fmt.Println()
+	fmt.Println("PLAY RECAP " + strings.Repeat("*", 60))
+	for _, h := range hosts {
+		s := r.stats[h]
+		name := fmt.Sprintf("%-*s", width, h)
+		switch {
+		case s.Failed > 0 || s.Unreachable > 0:
+			name = colorRed(name)
+		case s.Changed > 0:
+			name = colorYellow(name)
+		default:
+			name = colorGreen(name)
+		}
+		fmt.Printf("%s : ok=%-4d changed=%-4d unreachable=%-4d failed=%-4d skipped=%-4d rescued=%-4d ignored=%d\n",
+			name, s.Ok, s.Changed, s.Unreachable, s.Failed, s.Skipped, s.Rescued, s.Ignored)
+	}
+	if len(slow) > 0 {
+		fmt.Println()
+		fmt.Println("SLOWEST TASKS " + strings.Repeat("*", 57))
+		for _, tt := range slow {
+			fmt.Printf("%10s  total %10s  hosts=%-4d %s\n", tt.Max.Round(time.Millisecond), tt.Total.Round(time.Millisecond), tt.Hosts, tt.Task)
+		}
+	}
+	fmt.Printf("\nRun finished in %s\n", dur.Round(time.Millisecond))
+}
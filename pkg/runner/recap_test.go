@@ -0,0 +1,68 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"gopsi/pkg/inventory"
+	"gopsi/pkg/module"
+	"gopsi/pkg/play"
+)
+
+func TestRecapCounts(t *testing.T) {
+	r := &Runner{failed: map[string]error{}, unreachable: map[string]error{}}
+	boom := errors.New("boom")
+	r.countTask("web1", module.Result{Changed: true}, false, false, nil)
+	r.countTask("web1", module.Result{}, false, false, nil)
+	r.countTask("web1", module.Result{}, true, false, nil)
+	r.countTask("web1", module.Result{}, false, true, boom)
+	r.countTask("web1", module.Result{}, false, false, boom) // counted by recordFailure
+	r.recordFailure("web1", boom)
+	r.recordFailure("db1", unreachableError{boom})
+	want := hostStats{Ok: 2, Changed: 1, Skipped: 1, Ignored: 1, Failed: 1}
+	if got := *r.stats["web1"]; got != want {
+		t.Fatalf("web1 = %+v, want %+v", got, want)
+	}
+	if got := *r.stats["db1"]; got != (hostStats{Unreachable: 1}) {
+		t.Fatalf("db1 = %+v", got)
+	}
+
+	install := play.Task{ID: "1.1", Name: "install"}
+	r.timeTask(install, 3*time.Second)
+	r.timeTask(install, 5*time.Second)
+	r.timeTask(play.Task{ID: "1.2", Name: "ping"}, time.Second)
+	r.timeTask(play.Task{ID: "1.3", Name: "copy"}, 4*time.Second)
+	r.timeTask(play.Task{ID: "2.1", Name: "install"}, 2*time.Second)
+	slow := r.slowest(2)
+	if len(slow) != 2 || slow[0].Task != "install" || slow[0].Max != 5*time.Second || slow[0].Total != 8*time.Second || slow[0].Hosts != 2 || slow[1].Task != "copy" {
+		t.Fatalf("slowest = %+v", slow)
+	}
+	if slow = r.slowest(10); len(slow) != 4 || slow[2].Task != "install" || slow[2].Total != 2*time.Second {
+		t.Fatalf("same-named tasks of different plays should be timed apart: %+v", slow)
+	}
+}
+
+func TestRecapOnBadHostPattern(t *testing.T) {
+	stdout := os.Stdout
+	rd, wr, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stdout = wr
+	r := NewWithOptions(1, false, true, 0)
+	err = r.Run(context.Background(), []inventory.Host{{Name: "web1"}}, play.Playbook{Plays: []play.Play{{Hosts: "~web[0-"}}})
+	os.Stdout = stdout
+	wr.Close()
+	out, _ := io.ReadAll(rd)
+	if err == nil {
+		t.Fatal("expected host pattern error")
+	}
+	if !strings.Contains(string(out), `{"recap":`) {
+		t.Fatalf("recap not printed: %q", out)
+	}
+}
@@ -0,0 +1,59 @@
+package runner
+
+import (
+	"fmt"
+
+	"gopsi/pkg/module"
+)
+
+// registerValue builds the value stored under a task's `register` name. The
+// shape is the same for every module so conditions and templates can rely on
+// it: changed, msg, rc, stdout, stderr, data, artifacts, skipped, failed,
+// attempts. attempts is 1, or 0 when skipped; retried tasks overwrite it.
+// rc, stdout and stderr are lifted from the module's artifacts ("exit",
+// "stdout", "stderr") and default to 0 and "".
+func registerValue(res module.Result, skipped bool, err error) map[string]any {
+	data := res.Data
+	if data == nil {
+		data = map[string]any{}
+	}
+	arts := res.Artifacts
+	if arts == nil {
+		arts = map[string]any{}
+	}
+	msg := res.Msg
+	if err != nil && msg == "" {
+		msg = err.Error()
+	}
+	attempts := 1
+	if skipped {
+		attempts = 0
+	}
+	rc := 0
+	if v, ok := arts["exit"].(int); ok {
+		rc = v
+	}
+	return map[string]any{
+		"changed":   res.Changed && err == nil,
+		"msg":       msg,
+		"rc":        rc,
+		"stdout":    artifactString(arts, "stdout"),
+		"stderr":    artifactString(arts, "stderr"),
+		"data":      data,
+		"artifacts": arts,
+		"skipped":   skipped,
+		"failed":    err != nil,
+		"attempts":  attempts,
+	}
+}
+
+func artifactString(arts map[string]any, key string) string {
+	v, ok := arts[key]
+	if !ok || v == nil {
+		return ""
+	}
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return fmt.Sprintf("%v", v)
+}
@@ -0,0 +1,79 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"gopsi/pkg/eval"
+	"gopsi/pkg/module"
+	"gopsi/pkg/play"
+)
+
+// Defaults for tasks with `until`: retries counts the runs after the first.
+const (
+	DefaultRetries = 3
+	DefaultDelay   = 5 * time.Second
+)
+
+// attempt runs one task (or loop item) through execTask and outcome,
+// repeating it while `until` is false or, without `until` but with
+// `retries`, while it fails (a non-zero rc included, see outcome). `until`
+// sees the registered value, including `attempts`, as described at
+// resultScope.
+func (r *Runner) attempt(ctx context.Context, hr *hostRun, pl play.Play, t play.Task, vars map[string]any) (module.Result, bool, int, error) {
+	retries := t.Retries
+	if retries <= 0 && len(t.Until) > 0 {
+		retries = DefaultRetries
+	}
+	delay := DefaultDelay
+	if t.Delay > 0 {
+		delay = time.Duration(t.Delay * float64(time.Second))
+	}
+	var res module.Result
+	var skipped bool
+	var err error
+	n := 0
+	for {
+		n++
+		res, skipped, err = r.execTask(ctx, hr, pl, t, vars)
+		res, err = r.outcome(hr, t, vars, res, skipped, err)
+		if skipped {
+			return res, true, 0, nil
+		}
+		done := err == nil
+		if len(t.Until) > 0 {
+			scope, name := resultScope(t, vars)
+			rv := registerValue(res, false, err)
+			rv["attempts"] = n
+			scope[name] = rv
+			ok, uerr := eval.All(t.Until, scope)
+			if uerr != nil {
+				err = fmt.Errorf("%s: task %q until: %w", hr.host.Name, t.DisplayName(), uerr)
+				break
+			}
+			done = ok
+		}
+		if done || n > retries {
+			if !done && err == nil {
+				err = fmt.Errorf("%s: task %q: until %s still false after %d attempts", hr.host.Name, t.DisplayName(), strings.Join(t.Until, " and "), n)
+			}
+			break
+		}
+		why := "until false"
+		if err != nil {
+			why = err.Error()
+		}
+		r.verbosef(1, colorYellow(fmt.Sprintf("RETRY [%s] host=%s attempt=%d/%d %s, retrying in %s", t.DisplayName(), hr.host.Name, n, retries+1, why, delay)))
+		select {
+		case <-ctx.Done():
+			return res, false, n, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+	if n > 1 {
+		r.verbosef(1, "ATTEMPTS [%s] host=%s %d ok=%v", t.DisplayName(), hr.host.Name, n, err == nil)
+	}
+	return res, false, n, err
+}
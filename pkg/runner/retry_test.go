@@ -0,0 +1,92 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"gopsi/pkg/inventory"
+	"gopsi/pkg/module"
+	"gopsi/pkg/play"
+)
+
+// flakyMod fails until it has run `ok_after` times, then reports "ready" on
+// stdout. With `rc` it fails like a command: no error, exit code rc.
+type flakyMod struct{ runs *int }
+
+func (m flakyMod) Name() string                       { return "flaky" }
+func (m flakyMod) Validate(args map[string]any) error { return nil }
+func (m flakyMod) Check(ctx context.Context, c module.Conn, args map[string]any) (module.Result, error) {
+	return module.Result{Changed: true}, nil
+}
+func (m flakyMod) Apply(ctx context.Context, c module.Conn, args map[string]any) (module.Result, error) {
+	*m.runs++
+	if *m.runs < args["ok_after"].(int) {
+		if rc, ok := args["rc"].(int); ok {
+			return module.Result{Artifacts: map[string]any{"exit": rc, "stderr": "not ready"}}, nil
+		}
+		return module.Result{}, errors.New("connection reset")
+	}
+	return module.Result{Changed: true, Artifacts: map[string]any{"stdout": "ready", "exit": 0}}, nil
+}
+
+func TestAttempt(t *testing.T) {
+	var runs int
+	module.Register(flakyMod{runs: &runs})
+	task := func(okAfter int) play.Task {
+		return play.Task{Name: "download", Module: "flaky", Register: "dl", Args: map[string]any{"ok_after": okAfter}, Delay: 0.001}
+	}
+	run := func(t play.Task) (*hostRun, *Runner, error) {
+		runs = 0
+		r := &Runner{}
+		hr := &hostRun{host: inventory.Host{Name: "web1"}, vars: map[string]any{}, notified: map[string]bool{}}
+		_, err := r.runTask(context.Background(), hr, play.Play{}, t)
+		return hr, r, err
+	}
+
+	tk := task(3)
+	tk.Retries = 5
+	hr, r, err := run(tk)
+	if err != nil || runs != 3 || hr.vars["dl"].(map[string]any)["attempts"] != 3 {
+		t.Fatalf("retries: err=%v runs=%d dl=%v", err, runs, hr.vars["dl"])
+	}
+	if s := r.stats["web1"]; s == nil || s.Ok != 1 || s.Changed != 1 {
+		t.Fatalf("retried task should count once: %+v", s)
+	}
+
+	tk = task(10)
+	tk.Retries = 2
+	if _, _, err = run(tk); err == nil || runs != 3 {
+		t.Fatalf("retries exhausted: err=%v runs=%d", err, runs)
+	}
+
+	tk = task(3)
+	tk.Args["rc"] = 7
+	tk.Retries = 5
+	if hr, _, err = run(tk); err != nil || runs != 3 || hr.vars["dl"].(map[string]any)["rc"] != 0 {
+		t.Fatalf("retry on rc: err=%v runs=%d dl=%v", err, runs, hr.vars["dl"])
+	}
+	tk.Retries = 1
+	if hr, _, err = run(tk); err == nil || runs != 2 || hr.vars["dl"].(map[string]any)["rc"] != 7 {
+		t.Fatalf("rc retries exhausted: err=%v runs=%d dl=%v", err, runs, hr.vars["dl"])
+	}
+
+	tk = task(1)
+	tk.Until = []string{"dl.attempts >= 2"}
+	if hr, _, err = run(tk); err != nil || runs != 2 {
+		t.Fatalf("until: err=%v runs=%d", err, runs)
+	}
+
+	tk.Until = []string{`dl.stdout == "never"`}
+	tk.Retries = 1
+	if _, _, err = run(tk); err == nil || runs != 2 {
+		t.Fatalf("until never true: err=%v runs=%d", err, runs)
+	}
+
+	tk = task(1)
+	tk.When = []string{"false"}
+	tk.Retries = 3
+	if hr, _, err = run(tk); err != nil || runs != 0 || hr.vars["dl"].(map[string]any)["attempts"] != 0 {
+		t.Fatalf("skipped: err=%v runs=%d dl=%v", err, runs, hr.vars["dl"])
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"path/filepath"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"gopsi/pkg/conn"
@@ -21,14 +22,31 @@ import (
 )
 
 type Runner struct {
-	forks        int
-	check        bool
-	json         bool
-	verbosity    int
-	statsMu      sync.Mutex
-	statsTotal   int
-	statsSuccess int
-	runStart     time.Time
+	forks         int
+	check         bool
+	json          bool
+	verbosity     int
+	forceHandlers bool
+	askBecomePass bool
+	onlyTags      []string
+	skipTags      []string
+	hostKeyMode   conn.HostKeyMode
+	inv           *inventory.Inventory
+	groupsVar     map[string]any
+	vaultPass     []byte
+	prompt        func(string) (string, error)
+	promptMu      sync.Mutex
+	answers       map[string]string
+	pool          *conn.Pool
+	factsMu       sync.Mutex
+	factsCache    map[string]facts.Facts
+	factCache     facts.Cache
+	statsMu       sync.Mutex
+	stats         map[string]*hostStats
+	timings       map[string]*taskTiming
+	failed        map[string]error
+	unreachable   map[string]error
+	runStart      time.Time
 }
 
 func New(forks int, check bool) *Runner { return &Runner{forks: forks, check: check} }
@@ -36,6 +54,42 @@ func NewWithOptions(forks int, check bool, json bool, verbosity int) *Runner {
 	return &Runner{forks: forks, check: check, json: json, verbosity: verbosity}
 }
 
+// SetForceHandlers makes notified handlers run even when a later task fails.
+func (r *Runner) SetForceHandlers(v bool) { r.forceHandlers = v }
+
+// SetAskBecomePass prompts once for the become password when a host has no
+// become_password var.
+func (r *Runner) SetAskBecomePass(v bool) { r.askBecomePass = v }
+
+// SetTags restricts the run to tasks selected by --tags and --skip-tags.
+func (r *Runner) SetTags(only, skip []string) {
+	r.onlyTags = only
+	r.skipTags = skip
+}
+
+// SetInventory lets play `hosts:` patterns resolve group names.
+func (r *Runner) SetInventory(inv *inventory.Inventory) { r.inv = inv }
+
+// SetHostKeyMode sets the default host key checking mode; the inventory var
+// ssh_host_key_checking overrides it per host.
+func (r *Runner) SetHostKeyMode(m conn.HostKeyMode) { r.hostKeyMode = m }
+
+// SetVaultPassword sets the password used to decrypt inline vault vars.
+func (r *Runner) SetVaultPassword(p string) { r.vaultPass = []byte(p) }
+
+// SetFactCache persists gathered facts under dir and reuses them for ttl;
+// a zero ttl disables the cache.
+func (r *Runner) SetFactCache(dir string, ttl time.Duration) {
+	r.factCache = facts.Cache{Dir: dir, TTL: ttl}
+}
+
+// SetPrompt installs an interactive prompt for secrets such as key
+// passphrases; answers are cached for the whole run.
+func (r *Runner) SetPrompt(fn func(prompt string) (string, error)) { r.prompt = fn }
+
+// Run runs the playbook. A host that fails or cannot be reached is left out
+// of the remaining tasks and plays while the others carry on; the run then
+// returns a *HostFailures.
 func (r *Runner) Run(ctx context.Context, hosts []inventory.Host, pb play.Playbook) error {
 	if len(hosts) == 0 {
 		return errors.New("no hosts to run")
@@ -44,85 +98,121 @@ func (r *Runner) Run(ctx context.Context, hosts []inventory.Host, pb play.Playbo
 		return err
 	}
 	r.runStart = time.Now()
-	var mu sync.Mutex
-	var firstErr error
+	// connections and facts live for the whole run, across plays
+	r.factsCache = map[string]facts.Facts{}
+	r.failed = map[string]error{}
+	r.unreachable = map[string]error{}
+	r.stats = map[string]*hostStats{}
+	r.timings = map[string]*taskTiming{}
+	r.pool = conn.NewPool()
+	r.pool.Logf = func(format string, a ...any) { r.verbosef(1, format, a...) }
+	defer r.pool.Close()
+	var groups map[string][]string
+	if r.inv != nil {
+		groups = r.inv.Groups()
+	} else {
+		groups = map[string][]string{}
+		for _, h := range hosts {
+			groups["all"] = append(groups["all"], h.Name)
+		}
+	}
+	r.groupsVar = groupsVar(groups)
+	aborted := ""
 	for _, pl := range pb.Plays {
+		matched, err := inventory.Select(hosts, pl.Hosts, groups)
+		if err != nil {
+			r.printRecap(time.Since(r.runStart))
+			return fmt.Errorf("play hosts %q: %w", pl.Hosts, err)
+		}
 		var target []inventory.Host
-		for _, h := range hosts {
-			if pl.Hosts == "all" || pl.Hosts == h.Name {
-				target = append(target, h)
+		for _, h := range matched {
+			if r.down(h.Name) {
+				r.verbosef(1, "PLAY [%s] skipping %s, failed or unreachable earlier", pl.Hosts, h.Name)
+				continue
 			}
+			target = append(target, h)
 		}
-		conc := r.forks
-		if pl.Serial > 0 && pl.Serial < conc {
-			conc = pl.Serial
-		}
-		sem := make(chan struct{}, conc)
-		var wg sync.WaitGroup
-		for _, h := range target {
-			h := h
-			sem <- struct{}{}
-			wg.Add(1)
-			go func() {
-				defer wg.Done()
-				defer func() { <-sem }()
-				if err := r.runPlay(ctx, h, pl); err != nil {
-					mu.Lock()
-					if firstErr == nil {
-						firstErr = err
-					}
-					mu.Unlock()
-				}
-			}()
+		if len(target) == 0 {
+			r.verbosef(1, "PLAY [%s] no hosts matched", pl.Hosts)
+			continue
 		}
-		wg.Wait()
-		if firstErr != nil {
+		if aborted = r.playHosts(ctx, pl, target); aborted != "" {
 			break
 		}
 	}
-	if !r.json {
-		dur := time.Since(r.runStart)
-		r.verbosef(1, "")
-		r.verbosef(1, summaryLine(r.statsSuccess, r.statsTotal, dur))
-	}
-	return firstErr
+	r.printRecap(time.Since(r.runStart))
+	return r.hostFailures(aborted)
 }
 
-func (r *Runner) runPlay(ctx context.Context, h inventory.Host, pl play.Play) error {
-	keyPath := expandHome(stringVar(h.Vars, "ssh_private_key_file"))
-	if keyPath == "" {
-		keyPath = filepath.Join(os.Getenv("HOME"), ".ssh", "id_rsa")
+// playHosts runs the play on its hosts, forks (or serial) at a time, and
+// records those that fail. Once any_errors_fatal or max_fail_percentage
+// trips, hosts still running stop before their next task and hosts not yet
+// started are not run; the reason is returned and ends the run.
+func (r *Runner) playHosts(ctx context.Context, pl play.Play, target []inventory.Host) string {
+	conc := r.forks
+	if pl.Serial > 0 && pl.Serial < conc {
+		conc = pl.Serial
 	}
-	key, err := os.ReadFile(keyPath)
-	if err != nil {
-		fallback := filepath.Join(os.Getenv("HOME"), ".ssh", "id_ed25519")
-		if _, statErr := os.Stat(fallback); statErr == nil {
-			key, err = os.ReadFile(fallback)
-		}
-		if err != nil {
-			return err
+	sem := make(chan struct{}, conc)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var abort atomic.Bool
+	failed, reason := 0, ""
+	for _, h := range target {
+		h := h
+		sem <- struct{}{}
+		if abort.Load() {
+			<-sem
+			break
 		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			err := r.runPlay(ctx, h, pl, &abort)
+			if err == nil || errors.Is(err, errAborted) {
+				return
+			}
+			r.recordFailure(h.Name, err)
+			mu.Lock()
+			defer mu.Unlock()
+			failed++
+			if why := abortReason(pl.AnyErrorsFatal, pl.MaxFailPercentage, failed, len(target)); why != "" && reason == "" {
+				reason = why
+				abort.Store(true)
+				r.printAbort(pl, h.Name, err, why)
+			}
+		}()
 	}
-	user := stringVar(h.Vars, "user")
-	if user == "" {
-		user = os.Getenv("USER")
+	wg.Wait()
+	return reason
+}
+
+// printAbort reports that a play was aborted when host failed or turned
+// out unreachable.
+func (r *Runner) printAbort(pl play.Play, host string, err error, why string) {
+	if r.json {
+		fmt.Printf("{\"play\":%q,\"host\":%q,\"aborted\":true,\"msg\":%q}\n", pl.Hosts, host, why)
+		return
 	}
-	addr := h.Addr
-	if addr == "" {
-		addr = h.Name
+	state := "failed"
+	if isUnreachable(err) {
+		state = "was unreachable"
 	}
-	r.verbosef(1, "HOST %s connect user=%s addr=%s key=%s", h.Name, user, addr, keyPath)
-	c, err := conn.Dial(user, addr, key, 15*time.Second)
+	fmt.Println(colorRed(fmt.Sprintf("PLAY [%s] aborted after %s %s: %s", pl.Hosts, host, state, why)))
+}
+
+func (r *Runner) runPlay(ctx context.Context, h inventory.Host, pl play.Play, abort *atomic.Bool) error {
+	// every host of the play shows in the recap, even with nothing to count
+	r.tally(h.Name, func(*hostStats) {})
+	c, err := r.connect(ctx, h)
 	if err != nil {
-		return fmt.Errorf("%s: %w", h.Name, err)
+		return unreachableError{fmt.Errorf("%s: %w", h.Name, err)}
 	}
-	defer c.Close()
-	fs, err := facts.Gather(ctx, c)
+	fs, err := r.hostFacts(ctx, h, c, pl)
 	if err != nil {
 		return err
 	}
-	r.verbosef(1, "%s facts %v", h.Name, fs)
-	regs := map[string]any{"facts": map[string]any(fs)}
 	vars := map[string]any{"facts": map[string]any(fs)}
 	for k, v := range pl.Vars {
 		vars[k] = v
@@ -130,86 +220,299 @@ func (r *Runner) runPlay(ctx context.Context, h inventory.Host, pl play.Play) er
 	for k, v := range h.Vars {
 		vars[k] = v
 	}
-	for _, t := range pl.Tasks {
-		if len(t.Tags) > 0 { /* tags filtering to be added */
-		}
-		m := module.Get(t.Module)
-		if m == nil {
-			return fmt.Errorf("unknown module: %s", t.Module)
-		}
-		// propagate become flag for modules that support it
-		t.Args["become"] = pl.Become
-		if err := m.Validate(t.Args); err != nil {
-			r.verbosef(1, "%s validate error %s %v", h.Name, t.Name, err)
-			return err
+	// magic vars cannot be overridden
+	groupNames := make([]any, 0, len(h.Groups))
+	for _, g := range h.Groups {
+		groupNames = append(groupNames, g)
+	}
+	vars["group_names"] = groupNames
+	vars["groups"] = r.groupsVar
+	hr := &hostRun{host: h, conn: c, vars: vars, notified: map[string]bool{}, abort: abort}
+	if err := r.runTasks(ctx, hr, pl, pl.Tasks); err != nil {
+		return r.failHost(ctx, hr, pl, err)
+	}
+	return r.flushHandlers(ctx, hr, pl)
+}
+
+// runTasks runs tasks in order until one fails or the play is aborted. Tag
+// selection applies to the tasks inside blocks, not to blocks themselves,
+// and never to meta tasks: filtering out a flush_handlers would move where
+// handlers run.
+func (r *Runner) runTasks(ctx context.Context, hr *hostRun, pl play.Play, tasks []play.Task) error {
+	for _, t := range tasks {
+		if hr.abort != nil && hr.abort.Load() {
+			return errAborted
 		}
-		t.Args["vars"] = vars
-		if t.When != "" {
-			ok, err := eval.When(t.When, vars)
-			if err != nil {
-				r.verbosef(1, "%s when error %s %v", h.Name, t.Name, err)
+		if t.IsBlock() {
+			if err := r.runBlock(ctx, hr, pl, t); err != nil {
 				return err
 			}
-			if !ok {
-				continue
-			}
+			continue
 		}
-		argsCopy := map[string]any{}
-		for k, v := range t.Args {
-			if k != "vars" {
-				argsCopy[k] = v
+		if t.Module == "meta" {
+			if err := r.runMeta(ctx, hr, pl, t); err != nil {
+				return &taskError{task: t, err: err}
 			}
+			continue
+		}
+		if !play.Selected(t.Tags, r.onlyTags, r.skipTags) {
+			r.verbosef(2, "SKIP [%s] host=%s tags=%v", t.DisplayName(), hr.host.Name, t.Tags)
+			continue
+		}
+		if res, err := r.runTask(ctx, hr, pl, t); err != nil {
+			return &taskError{task: t, res: res, err: err}
 		}
-		r.incTotal()
-		if r.verbosity > 0 {
-			r.verbosef(1, "")
+	}
+	return nil
+}
+
+// groupsVar converts group membership into the `groups` magic var: group
+// name to list of host names.
+func groupsVar(groups map[string][]string) map[string]any {
+	out := make(map[string]any, len(groups))
+	for g, hosts := range groups {
+		list := make([]any, 0, len(hosts))
+		for _, h := range hosts {
+			list = append(list, h)
+		}
+		out[g] = list
+	}
+	return out
+}
+
+// hostRun carries the per-host state of a single play.
+type hostRun struct {
+	host     inventory.Host
+	conn     module.Conn
+	vars     map[string]any
+	notified map[string]bool
+	// abort is set when the play is aborted for every host
+	abort *atomic.Bool
+}
+
+// taskVars overlays the task's own vars (including those of enclosing
+// blocks) on the host vars.
+func taskVars(vars map[string]any, t play.Task) map[string]any {
+	if len(t.Vars) == 0 {
+		return vars
+	}
+	out := make(map[string]any, len(vars)+len(t.Vars))
+	for k, v := range vars {
+		out[k] = v
+	}
+	for k, v := range t.Vars {
+		out[k] = v
+	}
+	return out
+}
+
+// runTask executes one task (or handler) against the host, stores its result
+// under the task's `register` name and queues any handlers it notifies when
+// it reports a change. A failure of an ignore_errors task is reported and
+// swallowed.
+func (r *Runner) runTask(ctx context.Context, hr *hostRun, pl play.Play, t play.Task) (module.Result, error) {
+	t0 := time.Now()
+	if t.Loop != nil {
+		res, err := r.runLoop(ctx, hr, pl, t)
+		r.timeTask(t, time.Since(t0))
+		return res, err
+	}
+	res, skipped, attempts, err := r.attempt(ctx, hr, pl, t, taskVars(hr.vars, t))
+	if !skipped {
+		r.timeTask(t, time.Since(t0))
+	}
+	r.countTask(hr.host.Name, res, skipped, t.IgnoreErrors, err)
+	if fs, ok := res.Data["facts"].(facts.Facts); ok && err == nil {
+		// modules such as setup return facts for the host
+		old, _ := hr.vars["facts"].(map[string]any)
+		merged := facts.Merge(old, fs)
+		hr.vars["facts"] = map[string]any(merged)
+		r.storeFacts(hr.host.Name, merged)
+	}
+	if t.Register != "" {
+		rv := registerValue(res, skipped, err)
+		if !skipped {
+			rv["attempts"] = attempts
 		}
-		r.verbosef(1, "TASK [%s] module=%s host=%s", t.Name, t.Module, h.Name)
-		r.verbosef(2, "ARGS %s %v", t.Name, argsCopy)
-		t0 := time.Now()
-		res, err := m.Check(ctx, c, t.Args)
+		hr.vars[t.Register] = rv
+	}
+	if err != nil && t.IgnoreErrors {
+		r.ignoreFailure(hr, t, err)
+		return module.Result{}, nil
+	}
+	if err != nil || skipped {
+		return res, err
+	}
+	r.printColored(hr.host.Name, t.Name, res, r.check)
+	if r.verbosity > 0 {
+		r.verbosef(1, "")
+	}
+	return res, r.notify(hr, pl, t, res)
+}
+
+// execTask renders args with vars, validates, evaluates `when`, and runs
+// Check (and Apply when a change is predicted outside check mode). skipped
+// is true when `when` is false.
+func (r *Runner) execTask(ctx context.Context, hr *hostRun, pl play.Play, t play.Task, vars map[string]any) (module.Result, bool, error) {
+	h := hr.host
+	m := module.Get(t.Module)
+	if m == nil {
+		return module.Result{}, false, fmt.Errorf("unknown module: %s", t.Module)
+	}
+	become, b, err := r.become(pl, t, vars)
+	if err != nil {
+		return module.Result{}, false, fmt.Errorf("%s: task %q: %w", h.Name, t.DisplayName(), err)
+	}
+	// modules pass the become flag to Exec; the connection escalates, and
+	// installs uploaded files as the become user
+	c := conn.WithBecome(hr.conn, b, become)
+	// render into a fresh map so concurrent hosts never share the task's args
+	args := r.renderArgs(t.Module, t.Args, vars)
+	args["become"] = become
+	if err := m.Validate(args); err != nil {
+		r.verbosef(1, "%s validate error %s %v", h.Name, t.Name, err)
+		return module.Result{}, false, err
+	}
+	if len(t.When) > 0 {
+		ok, err := eval.All(t.When, vars)
 		if err != nil {
-			r.verbosef(1, colorRed(fmt.Sprintf("%s check error %s %v", h.Name, t.Name, err)))
-			return err
+			r.verbosef(1, "%s when error %s %v", h.Name, t.Name, err)
+			return module.Result{}, false, fmt.Errorf("%s: task %q when: %w", h.Name, t.DisplayName(), err)
 		}
-		r.verbosef(2, "CHECK [%s] host=%s changed=%v msg=%s dur=%s", t.Name, h.Name, res.Changed, res.Msg, time.Since(t0))
-		if r.verbosity >= 3 {
-			r.verbosef(3, "DATA [%s] %s", t.Name, summarizeMap(res.Data, 512))
+		if !ok {
+			r.verbosef(2, "SKIP [%s] host=%s when=%v", t.DisplayName(), h.Name, t.When)
+			return module.Result{}, true, nil
 		}
-		if r.check {
-			r.printColored(h.Name, t.Name, res, true)
-			r.incSuccess()
-			continue
+	}
+	argsCopy := map[string]any{}
+	for k, v := range args {
+		argsCopy[k] = v
+	}
+	args["vars"] = vars
+	if r.verbosity > 0 {
+		r.verbosef(1, "")
+	}
+	r.verbosef(1, "TASK [%s] module=%s host=%s", t.Name, t.Module, h.Name)
+	r.verbosef(2, "ARGS %s %v", t.Name, argsCopy)
+	if become {
+		r.verbosef(2, "BECOME %s method=%s user=%s password=%v", t.Name, b.Method, b.User, b.Password != "")
+	}
+	t0 := time.Now()
+	res, err := m.Check(ctx, c, args)
+	if err != nil {
+		r.verbosef(1, colorRed(fmt.Sprintf("%s check error %s %v", h.Name, t.Name, err)))
+		return module.Result{}, false, err
+	}
+	r.verbosef(2, "CHECK [%s] host=%s changed=%v msg=%s dur=%s", t.Name, h.Name, res.Changed, res.Msg, time.Since(t0))
+	if r.verbosity >= 3 {
+		r.verbosef(3, "DATA [%s] %s", t.Name, summarizeMap(res.Data, 512))
+	}
+	if r.check || !res.Changed {
+		return res, false, nil
+	}
+	t1 := time.Now()
+	res, err = m.Apply(ctx, c, args)
+	if err != nil {
+		r.verbosef(1, colorRed(fmt.Sprintf("%s apply error %s %v", h.Name, t.Name, err)))
+		return module.Result{}, false, err
+	}
+	r.verbosef(2, "APPLY [%s] host=%s changed=%v msg=%s dur=%s", t.Name, h.Name, res.Changed, res.Msg, time.Since(t1))
+	if r.verbosity >= 3 {
+		r.verbosef(3, "DATA [%s] %s", t.Name, summarizeMap(res.Data, 512))
+		r.verbosef(3, "ARTIFACTS [%s] %s", t.Name, summarizeMap(res.Artifacts, 512))
+	}
+	return res, false, nil
+}
+
+// notify queues the task's handlers when the result reports a change.
+func (r *Runner) notify(hr *hostRun, pl play.Play, t play.Task, res module.Result) error {
+	if !res.Changed {
+		return nil
+	}
+	for _, name := range t.Notify {
+		if !hasHandler(pl, name) {
+			return fmt.Errorf("%s: task %q notifies unknown handler %q", hr.host.Name, t.Name, name)
 		}
-		if res.Changed {
-			t1 := time.Now()
-			res, err = m.Apply(ctx, c, t.Args)
-			if err != nil {
-				r.verbosef(1, colorRed(fmt.Sprintf("%s apply error %s %v", h.Name, t.Name, err)))
-				return err
+		if !hr.notified[name] {
+			r.verbosef(2, "NOTIFY [%s] host=%s handler=%s", t.Name, hr.host.Name, name)
+		}
+		hr.notified[name] = true
+	}
+	return nil
+}
+
+// flushHandlers runs every notified handler once, in declaration order.
+// Handlers may notify other handlers: passes are repeated until nothing new
+// is notified, so one declared earlier runs in the next pass. A handler runs
+// at most once per flush; notifying it again after it ran is dropped, which
+// also ends notification cycles.
+func (r *Runner) flushHandlers(ctx context.Context, hr *hostRun, pl play.Play) error {
+	ran := map[string]bool{}
+	for {
+		pass := false
+		for _, hd := range pl.Handlers {
+			if !hr.notified[hd.Name] {
+				continue
 			}
-			r.verbosef(2, "APPLY [%s] host=%s changed=%v msg=%s dur=%s", t.Name, h.Name, res.Changed, res.Msg, time.Since(t1))
-			if r.verbosity >= 3 {
-				r.verbosef(3, "DATA [%s] %s", t.Name, summarizeMap(res.Data, 512))
-				r.verbosef(3, "ARTIFACTS [%s] %s", t.Name, summarizeMap(res.Artifacts, 512))
+			delete(hr.notified, hd.Name)
+			if ran[hd.Name] {
+				r.verbosef(1, "HANDLER [%s] host=%s notified again after running, ignored", hd.Name, hr.host.Name)
+				continue
 			}
-			r.printColored(h.Name, t.Name, res, false)
-			r.incSuccess()
-		} else {
-			r.printColored(h.Name, t.Name, res, false)
-			r.incSuccess()
-		}
-		if t.Register != "" {
-			regs[t.Register] = res.Data
-			if res.Artifacts != nil {
-				regs[t.Register+"_artifacts"] = res.Artifacts
+			ran[hd.Name] = true
+			pass = true
+			r.verbosef(1, "RUNNING HANDLER [%s] host=%s", hd.Name, hr.host.Name)
+			if _, err := r.runTask(ctx, hr, pl, hd); err != nil {
+				return err
 			}
 		}
-		if r.verbosity > 0 {
-			r.verbosef(1, "")
+		if !pass {
+			return nil
 		}
 	}
-	return nil
+}
+
+// failHost returns the task error, running already-notified handlers first
+// when --force-handlers is set.
+func (r *Runner) failHost(ctx context.Context, hr *hostRun, pl play.Play, err error) error {
+	if r.forceHandlers {
+		if herr := r.flushHandlers(ctx, hr, pl); herr != nil {
+			r.verbosef(1, colorRed(fmt.Sprintf("%s handler error %v", hr.host.Name, herr)))
+		}
+	}
+	return err
+}
+
+// runMeta handles `meta:` tasks, which control the runner rather than the
+// host. They honor `when` (including a block's) but not tags.
+func (r *Runner) runMeta(ctx context.Context, hr *hostRun, pl play.Play, t play.Task) error {
+	action, _ := t.Args["_"].(string)
+	if len(t.When) > 0 {
+		ok, err := eval.All(t.When, taskVars(hr.vars, t))
+		if err != nil {
+			return fmt.Errorf("%s: task %q when: %w", hr.host.Name, t.DisplayName(), err)
+		}
+		if !ok {
+			r.verbosef(2, "SKIP [meta %s] host=%s when=%v", action, hr.host.Name, t.When)
+			return nil
+		}
+	}
+	switch action {
+	case "flush_handlers":
+		r.verbosef(1, "META [flush_handlers] host=%s", hr.host.Name)
+		return r.flushHandlers(ctx, hr, pl)
+	default:
+		return fmt.Errorf("unsupported meta action: %q", action)
+	}
+}
+
+func hasHandler(pl play.Play, name string) bool {
+	for _, hd := range pl.Handlers {
+		if hd.Name == name {
+			return true
+		}
+	}
+	return false
 }
 
 func (r *Runner) print(host, name string, res module.Result, check bool) {
@@ -233,6 +536,20 @@ func (r *Runner) printColored(host, name string, res module.Result, check bool)
 	}
 }
 
+// printItem reports one loop item as an indented sub-line of its task.
+func (r *Runner) printItem(host, name, label string, res module.Result, check bool) {
+	if r.json {
+		fmt.Printf("{\"host\":%q,\"task\":%q,\"item\":%q,\"changed\":%v,\"check\":%v,\"msg\":%q}\n", host, name, label, res.Changed, check, res.Msg)
+		return
+	}
+	line := fmt.Sprintf("  %s | %s | item=%s | changed=%v", host, name, label, res.Changed)
+	if res.Changed {
+		fmt.Println(colorYellow(line))
+	} else {
+		fmt.Println(colorGreen(line))
+	}
+}
+
 func ensureModulesRegistered() error {
 	// lazy registration
 	// The actual registrations occur in init() of each module package when imported.
@@ -302,22 +619,7 @@ func summarizeMap(m map[string]any, max int) string {
 	return out
 }
 
-func (r *Runner) incTotal() {
-	r.statsMu.Lock()
-	r.statsTotal++
-	r.statsMu.Unlock()
-}
-
-func (r *Runner) incSuccess() {
-	r.statsMu.Lock()
-	r.statsSuccess++
-	r.statsMu.Unlock()
-}
-
-func summaryLine(success, total int, dur time.Duration) string {
-	return fmt.Sprintf("SUMMARY: %s/%d tasks succeeded in %s", colorGreen(fmt.Sprintf("%d", success)), total, dur)
-}
-
 func colorGreen(s string) string  { return "\033[32m" + s + "\033[0m" }
 func colorYellow(s string) string { return "\033[33m" + s + "\033[0m" }
 func colorRed(s string) string    { return "\033[31m" + s + "\033[0m" }
+func colorCyan(s string) string   { return "\033[36m" + s + "\033[0m" }
@@ -0,0 +1,72 @@
+package runner
+
+import (
+	"bytes"
+	"strings"
+	"text/template"
+)
+
+// bodyArgs lists, per module, the args that carry a file body. They reach
+// the module unrendered so a literal "{{" in a config file survives; file
+// renders its own content against vars.
+var bodyArgs = map[string][]string{
+	"copy": {"content"},
+	"file": {"content"},
+}
+
+// renderArgs returns a copy of a task's args with every string containing
+// "{{" rendered as a Go template over vars, e.g. `name: "{{ .item }}"`.
+// Nested maps and lists are copied and rendered too; the module's bodyArgs
+// are copied as is.
+func (r *Runner) renderArgs(module string, args map[string]any, vars map[string]any) map[string]any {
+	out := r.renderMap(args, vars)
+	for _, k := range bodyArgs[module] {
+		if v, ok := args[k]; ok {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+func (r *Runner) renderMap(args map[string]any, vars map[string]any) map[string]any {
+	out := make(map[string]any, len(args)+2)
+	for k, v := range args {
+		out[k] = r.renderValue(v, vars)
+	}
+	return out
+}
+
+func (r *Runner) renderValue(v any, vars map[string]any) any {
+	switch x := v.(type) {
+	case string:
+		return r.renderString(x, vars)
+	case map[string]any:
+		return r.renderMap(x, vars)
+	case []any:
+		out := make([]any, len(x))
+		for i, e := range x {
+			out[i] = r.renderValue(e, vars)
+		}
+		return out
+	}
+	return v
+}
+
+// renderString renders s with vars. Like the modules' own rendering, a
+// string that fails to parse or execute is kept verbatim.
+func (r *Runner) renderString(s string, vars map[string]any) string {
+	if !strings.Contains(s, "{{") {
+		return s
+	}
+	t, err := template.New("arg").Option("missingkey=error").Parse(s)
+	if err != nil {
+		r.verbosef(2, "TEMPLATE %q kept verbatim: %v", s, err)
+		return s
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, vars); err != nil {
+		r.verbosef(2, "TEMPLATE %q kept verbatim: %v", s, err)
+		return s
+	}
+	return buf.String()
+}
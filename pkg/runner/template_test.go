@@ -0,0 +1,53 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"testing"
+
+	"gopsi/pkg/inventory"
+	_ "gopsi/pkg/modules/copy"
+	"gopsi/pkg/play"
+)
+
+// memConn is a remote whose files live in a map; every Exec exits with rc.
+type memConn struct {
+	files map[string]string
+	rc    int
+}
+
+func (c *memConn) Exec(ctx context.Context, cmd string, env map[string]string, sudo bool) (string, string, int, error) {
+	return "", "", c.rc, nil
+}
+func (c *memConn) Put(ctx context.Context, src io.Reader, dst string, mode os.FileMode) error {
+	b, err := io.ReadAll(src)
+	c.files[dst] = string(b)
+	return err
+}
+func (c *memConn) Get(ctx context.Context, src string) (io.ReadCloser, error) {
+	s, ok := c.files[src]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return io.NopCloser(bytes.NewBufferString(s)), nil
+}
+
+func TestCopyContentNotRendered(t *testing.T) {
+	mc := &memConn{files: map[string]string{}}
+	hr := &hostRun{host: inventory.Host{Name: "web1"}, conn: mc, vars: map[string]any{"port": 8080, "dir": "/etc/app"}, notified: map[string]bool{}}
+	bodies := map[string]string{"app.conf": "{{ not_a_var }}", "listen.conf": "listen {{ .port }}\n"}
+	var tasks []play.Task
+	for name, body := range bodies {
+		tasks = append(tasks, play.Task{Name: name, Module: "copy", Args: map[string]any{"dest": "{{ .dir }}/" + name, "content": body}})
+	}
+	if err := (&Runner{}).runTasks(context.Background(), hr, play.Play{}, tasks); err != nil {
+		t.Fatal(err)
+	}
+	for name, body := range bodies {
+		if got, ok := mc.files["/etc/app/"+name]; !ok || got != body {
+			t.Errorf("remote files %v, want %q unchanged at the rendered dest", mc.files, body)
+		}
+	}
+}
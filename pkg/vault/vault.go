@@ -4,8 +4,10 @@ import (
     "crypto/aes"
     "crypto/cipher"
     "crypto/rand"
+    "encoding/base64"
     "errors"
     "io"
+    "strings"
 )
 
 func Encrypt(plaintext, pass []byte) ([]byte, error) {
@@ -41,3 +43,27 @@ func derive(pass []byte) []byte {
     return key
 }
 
+
+// Prefix marks an inline encrypted value, e.g. a vault-encrypted inventory
+// var such as ssh_password or become_password.
+const Prefix = "$GOPSI_VAULT;"
+
+func IsEncrypted(s string) bool { return strings.HasPrefix(strings.TrimSpace(s), Prefix) }
+
+// EncryptString encrypts plaintext into the inline form Prefix+base64.
+func EncryptString(plaintext string, pass []byte) (string, error) {
+    b, err := Encrypt([]byte(plaintext), pass)
+    if err != nil { return "", err }
+    return Prefix + base64.StdEncoding.EncodeToString(b), nil
+}
+
+// DecryptString reverses EncryptString.
+func DecryptString(s string, pass []byte) (string, error) {
+    s = strings.TrimSpace(s)
+    if !strings.HasPrefix(s, Prefix) { return "", errors.New("not a vault string") }
+    b, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(s[len(Prefix):]), ""))
+    if err != nil { return "", err }
+    out, err := Decrypt(b, pass)
+    if err != nil { return "", err }
+    return string(out), nil
+}